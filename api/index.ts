@@ -19,6 +19,11 @@ const db = new aws.dynamodb.Table("festus-db", {
     name: "festus-db",
     streamEnabled: true,
     streamViewType: "NEW_IMAGE",
+    // expires the stored responses of idempotency keys and the results of previews
+    ttl: {
+        attributeName: "ttl",
+        enabled: true,
+    },
     attributes: [{
         name: "pk",
         type: "S",
@@ -76,6 +81,7 @@ const ddbAccess = new aws.iam.Policy("festus-ddb-access", {
                     "dynamodb:BatchGetItem",
                     "dynamodb:BatchWriteItem",
                     "dynamodb:DeleteItem",
+                    "dynamodb:DescribeTable",
                     "dynamodb:GetItem",
                     "dynamodb:PutItem",
                     "dynamodb:Query",
@@ -121,6 +127,12 @@ new aws.iam.RolePolicyAttachment("festus-ddb-stream-access", {
     policyArn: ddbStreamAccess.arn,
 });
 
+// the API reads the table stream to serve watch requests
+new aws.iam.RolePolicyAttachment("festus-api-ddb-stream-access", {
+    role: lambdaRole,
+    policyArn: ddbStreamAccess.arn,
+});
+
 const streamProcessor = new aws.lambda.Function("streamProcessor", {
     code: new pulumi.asset.FileArchive("lambdas/out/account-update/function.zip"),
     name: "festus-stream-processor",
@@ -147,6 +159,77 @@ const eventSourceMapping = new aws.lambda.EventSourceMapping("myEventSourceMappi
     maximumRetryAttempts: 5
 });
 
+const driftDetector = new aws.lambda.Function("driftDetector", {
+    code: new pulumi.asset.FileArchive("lambdas/out/drift-detector/function.zip"),
+    name: "festus-drift-detector",
+    role: streamHandlerRole.arn,
+    handler: "dummy",
+    timeout: 900,
+    memorySize: 1769,
+    sourceCodeHash: std.filebase64sha256({
+        input: "lambdas/out/drift-detector/function.zip",
+    }).then(invoke => invoke.result),
+    runtime: aws.lambda.Runtime.CustomAL2,
+    environment: {
+        variables: {
+            "TABLE_NAME": db.name,
+            "DRIFT_CONCURRENCY": "4",
+        },
+    },
+    ephemeralStorage: { size: 2048 }
+});
+
+const driftSchedule = new aws.cloudwatch.EventRule("festus-drift-schedule", {
+    scheduleExpression: "rate(6 hours)",
+});
+
+new aws.cloudwatch.EventTarget("festus-drift-schedule", {
+    rule: driftSchedule.name,
+    arn: driftDetector.arn,
+});
+
+new aws.lambda.Permission("festus-drift-schedule", {
+    action: "lambda:InvokeFunction",
+    function: driftDetector.name,
+    principal: "events.amazonaws.com",
+    sourceArn: driftSchedule.arn,
+});
+
+const closureSweep = new aws.lambda.Function("closureSweep", {
+    code: new pulumi.asset.FileArchive("lambdas/out/closure-sweep/function.zip"),
+    name: "festus-closure-sweep",
+    role: streamHandlerRole.arn,
+    handler: "dummy",
+    timeout: 900,
+    memorySize: 1769,
+    sourceCodeHash: std.filebase64sha256({
+        input: "lambdas/out/closure-sweep/function.zip",
+    }).then(invoke => invoke.result),
+    runtime: aws.lambda.Runtime.CustomAL2,
+    environment: {
+        variables: {
+            "TABLE_NAME": db.name,
+        },
+    },
+    ephemeralStorage: { size: 2048 }
+});
+
+const closureSchedule = new aws.cloudwatch.EventRule("festus-closure-schedule", {
+    scheduleExpression: "rate(1 day)",
+});
+
+new aws.cloudwatch.EventTarget("festus-closure-schedule", {
+    rule: closureSchedule.name,
+    arn: closureSweep.arn,
+});
+
+new aws.lambda.Permission("festus-closure-schedule", {
+    action: "lambda:InvokeFunction",
+    function: closureSweep.name,
+    principal: "events.amazonaws.com",
+    sourceArn: closureSchedule.arn,
+});
+
 const apiHandler = new aws.lambda.Function("test_lambda", {
     code: new pulumi.asset.FileArchive("lambdas/out/api/function.zip"),
     name: "festus-api-handler",
@@ -160,6 +243,7 @@ const apiHandler = new aws.lambda.Function("test_lambda", {
     environment: {
         variables: {
             "TABLE_NAME": db.name,
+            "STREAM_ARN": db.streamArn,
         },
     },
 });
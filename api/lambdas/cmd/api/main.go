@@ -4,15 +4,18 @@ import (
 	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
 	"github.com/awslabs/aws-lambda-go-api-proxy/gin"
+	"github.com/gin-gonic/gin"
 	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/feed"
 	"github.com/flostadler/festus/api/pkg/handlers"
-	"github.com/gin-gonic/gin"
 )
 
 var ginLambda *ginadapter.GinLambda
@@ -24,35 +27,27 @@ func init() {
 	sess := session.Must(session.NewSession())
     ddb := dynamodb.New(sess)
 
-	orgDB := db.NewOrganizationDB(ddb, os.Getenv("TABLE_NAME"))
-	accountDB := db.NewAccountDB(ddb, os.Getenv("TABLE_NAME"))
-	orgHandler := handlers.NewOrganizationHandler(orgDB)
-	accountsHandler := handlers.NewAccountsHandler(orgDB, accountDB)
-
-	r := gin.Default()
-	r.Use(handlers.Auth())
-
-	root := r.Group("/", handlers.Auth())
+	tableName := os.Getenv("TABLE_NAME")
+	tables := db.NewTables(ddb, tableName)
 
-	orgs := root.Group("/organizations")
-	{
-		orgs.POST("", orgHandler.CreateOrganization)
-		orgs.GET("/:organizationName", orgHandler.GetOrganization)
-		orgs.DELETE("/:organizationName", orgHandler.DeleteOrganization)
-		accounts := orgs.Group("/:organizationName/accounts")
-		{
-			accounts.POST("", accountsHandler.CreateAccount)
-			accounts.GET("/:accountName", accountsHandler.GetAccount)
-			accounts.DELETE("/:accountName", accountsHandler.DeleteAccount)
+	// without the API Gateway authorizer in front, e.g. behind a function URL, JWTs are verified by the API itself
+	var auth gin.HandlerFunc
+	if jwtConfig, ok := handlers.JWTConfigFromEnv(); ok {
+		var err error
+		auth, err = handlers.JWTAuth(jwtConfig, tables.APIKeys)
+		if err != nil {
+			log.Fatalf("invalid JWT configuration: %s", err.Error())
 		}
 	}
 
-	root.GET("/ping", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"message": "pong",
-			"userID":  handlers.GetUserID(c),
-			"requestID": handlers.GetRequestID(c),
-		})
+	r := handlers.NewRouter(handlers.RouterConfig{
+		Tables: tables,
+		Feed:   feed.NewStreamFeed(dynamodbstreams.New(sess), os.Getenv("STREAM_ARN")),
+		Auth:   auth,
+		// API Gateway cuts off requests after 29 seconds, clients resume watching with their Last-Event-ID
+		WatchTimeout: 25 * time.Second,
+		// the REST proxy integration returns responses only once they are complete
+		BufferedWatch: true,
 	})
 
 	ginLambda = ginadapter.New(r)
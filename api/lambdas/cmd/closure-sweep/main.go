@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/flostadler/festus/api/pkg/closure"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/iac"
+)
+
+var sweeper *closure.Sweeper
+
+func init() {
+	sess := session.Must(session.NewSession())
+	ddb := dynamodb.New(sess)
+
+	sweeper = closure.NewSweeper(db.NewTables(ddb, os.Getenv("TABLE_NAME")), iac.CloseAccount, nil)
+}
+
+// Handler is invoked on a schedule by EventBridge
+func Handler(ctx context.Context, _ events.CloudWatchEvent) error {
+	return sweeper.Run(ctx)
+}
+
+func main() {
+	lambda.Start(Handler)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/drift"
+	"github.com/flostadler/festus/api/pkg/iac"
+)
+
+var detector *drift.Detector
+
+func init() {
+	sess := session.Must(session.NewSession())
+	ddb := dynamodb.New(sess)
+
+	concurrency, err := strconv.Atoi(os.Getenv("DRIFT_CONCURRENCY"))
+	if err != nil {
+		concurrency = 4
+	}
+	detector = drift.NewDetector(db.NewTables(ddb, os.Getenv("TABLE_NAME")), iac.DetectDrift, concurrency)
+}
+
+// Handler is invoked on a schedule by EventBridge
+func Handler(ctx context.Context, _ events.CloudWatchEvent) error {
+	return detector.Run(ctx)
+}
+
+func main() {
+	lambda.Start(Handler)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/feed"
+	"github.com/flostadler/festus/api/pkg/handlers"
+	"github.com/flostadler/festus/api/pkg/iac"
+	"github.com/flostadler/festus/api/pkg/stream"
+)
+
+// The local server runs the API and the stream processor in one process against DynamoDB Local.
+// Table changes are handed to the stream processor and published to an in-process bus for watch streams.
+func main() {
+	endpoint := getEnv("DYNAMODB_ENDPOINT", "http://localhost:8000")
+	tableName := getEnv("TABLE_NAME", "festus-db")
+	userID := getEnv("FESTUS_USER", "local")
+	addr := getEnv("ADDR", ":8080")
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(endpoint),
+		Region:      aws.String(getEnv("AWS_REGION", "us-west-2")),
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	}))
+	ddb := dynamodb.New(sess)
+
+	streamArn, err := ensureTable(ddb, tableName)
+	if err != nil {
+		log.Fatalf("failed to create table: %s", err.Error())
+	}
+
+	tables := db.NewTables(ddb, tableName)
+	bus := feed.NewBus()
+	processor := stream.NewHandler(tables, iac.CreateAccount, iac.ApplyOrg, iac.PreviewAccount)
+	go processStream(feed.NewStreamReader(dynamodbstreams.New(sess), streamArn, dynamodbstreams.ShardIteratorTypeTrimHorizon), processor, bus)
+
+	// with JWKS_URI set requests are authenticated with JWTs like in a deployment, otherwise all act as FESTUS_USER
+	auth := handlers.LocalAuth(userID)
+	identity := fmt.Sprintf("user '%s'", userID)
+	if jwtConfig, ok := handlers.JWTConfigFromEnv(); ok {
+		auth, err = handlers.JWTAuth(jwtConfig, tables.APIKeys)
+		if err != nil {
+			log.Fatalf("invalid JWT configuration: %s", err.Error())
+		}
+		identity = fmt.Sprintf("the subject of JWTs verified with %s", jwtConfig.JWKSURI)
+	}
+
+	r := handlers.NewRouter(handlers.RouterConfig{
+		Tables: tables,
+		Feed:   bus,
+		Auth:   auth,
+	})
+	log.Printf("festus listening on %s as %s", addr, identity)
+	if err := r.Run(addr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func processStream(reader *feed.StreamReader, processor *stream.Handler, bus *feed.Bus) {
+	for {
+		records, err := reader.Read()
+		if err != nil {
+			log.Printf("failed to read table stream: %s", err.Error())
+		}
+		if len(records) > 0 {
+			bus.Publish(records)
+			if err := processor.Handle(context.Background(), events.DynamoDBEvent{Records: records}); err != nil {
+				log.Printf("failed to process table changes: %s", err.Error())
+			}
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func ensureTable(ddb *dynamodb.DynamoDB, tableName string) (string, error) {
+	table, err := ddb.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err == nil {
+		return *table.Table.LatestStreamArn, nil
+	}
+
+	created, err := ddb.CreateTable(&dynamodb.CreateTableInput{
+		TableName:   aws.String(tableName),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("pk"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String("sk"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("pk"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("sk"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		StreamSpecification: &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(dynamodb.StreamViewTypeNewImage),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return *created.TableDescription.LatestStreamArn, nil
+}
+
+func getEnv(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
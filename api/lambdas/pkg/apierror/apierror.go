@@ -0,0 +1,157 @@
+// Package apierror defines the errors of the festus API. They are written as RFC 7807 problem details with a stable
+// code that clients can rely on, unlike the human-readable detail
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/validation"
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem details
+const ContentType = "application/problem+json"
+
+// Code identifies the kind of an error. Codes never change once they are published
+type Code string
+
+const (
+	// BadRequestCode means the request is malformed, e.g. its body isn't valid JSON or a query parameter can't be parsed
+	BadRequestCode Code = "bad_request"
+	// ValidationCode means the request is well-formed but its content isn't valid
+	ValidationCode Code = "validation_failed"
+	// UnauthenticatedCode means the request has no valid credentials
+	UnauthenticatedCode Code = "unauthenticated"
+	// ForbiddenCode means the credentials don't allow the request
+	ForbiddenCode Code = "forbidden"
+	// NotFoundCode means the resource or one it depends on doesn't exist
+	NotFoundCode Code = "not_found"
+	// AlreadyExistsCode means a resource with the same name exists already
+	AlreadyExistsCode Code = "already_exists"
+	// ConflictCode means the current state of the resource doesn't allow the request
+	ConflictCode Code = "conflict"
+	// PreconditionFailedCode means the resource was modified concurrently. The request can be retried
+	PreconditionFailedCode Code = "precondition_failed"
+	// IdempotencyKeyReusedCode means the idempotency key of the request was used for a different request
+	IdempotencyKeyReusedCode Code = "idempotency_key_reused"
+	// InternalCode means the request failed unexpectedly. The details are logged, not returned
+	InternalCode Code = "internal"
+)
+
+// Error is an error with the status code and the code it's returned with
+type Error struct {
+	Status int
+	Code   Code
+	Detail string
+	// Extensions are additional members of the problem details, e.g. the scope a request lacks
+	Extensions map[string]interface{}
+	// cause is logged for internal errors
+	cause error
+}
+
+func New(status int, code Code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func BadRequest(detail string) *Error {
+	return New(http.StatusBadRequest, BadRequestCode, detail)
+}
+
+func Validation(detail string) *Error {
+	return New(http.StatusUnprocessableEntity, ValidationCode, detail)
+}
+
+func Unauthenticated(detail string) *Error {
+	return New(http.StatusUnauthorized, UnauthenticatedCode, detail)
+}
+
+func Forbidden(detail string) *Error {
+	return New(http.StatusForbidden, ForbiddenCode, detail)
+}
+
+func NotFound(detail string) *Error {
+	return New(http.StatusNotFound, NotFoundCode, detail)
+}
+
+func AlreadyExists(detail string) *Error {
+	return New(http.StatusConflict, AlreadyExistsCode, detail)
+}
+
+func Conflict(detail string) *Error {
+	return New(http.StatusConflict, ConflictCode, detail)
+}
+
+func PreconditionFailed(detail string) *Error {
+	return New(http.StatusPreconditionFailed, PreconditionFailedCode, detail)
+}
+
+// Internal hides the cause of an unexpected error from the client
+func Internal(cause error) *Error {
+	e := New(http.StatusInternalServerError, InternalCode, "The request failed unexpectedly")
+	e.cause = cause
+	return e
+}
+
+// With adds a member to the problem details
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Extensions == nil {
+		e.Extensions = map[string]interface{}{}
+	}
+	e.Extensions[key] = value
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s", e.Detail, e.cause.Error())
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// From translates any error into an API error. Field errors list every invalid field under "errors". Conditional
+// check failures of DynamoDB mean that an item changed since it was read, everything else that isn't an API error
+// already is internal
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	var fieldErrs validation.Errors
+	if errors.As(err, &fieldErrs) {
+		return Validation(fieldErrs.Error()).With("errors", fieldErrs)
+	}
+	if db.IsConditionalCheckFailed(err) {
+		return PreconditionFailed("The resource was modified concurrently, retry the request")
+	}
+	return Internal(err)
+}
+
+// Abort responds with the problem details of err and aborts the request
+func Abort(c *gin.Context, err error) {
+	apiErr := From(err)
+	if apiErr.cause != nil {
+		fmt.Printf("%s %s failed: %s\n", c.Request.Method, c.Request.URL.Path, apiErr.cause.Error())
+	}
+
+	problem := gin.H{}
+	for key, value := range apiErr.Extensions {
+		problem[key] = value
+	}
+	problem["type"] = "urn:festus:problem:" + string(apiErr.Code)
+	problem["title"] = http.StatusText(apiErr.Status)
+	problem["status"] = apiErr.Status
+	problem["detail"] = apiErr.Detail
+	problem["code"] = apiErr.Code
+	problem["instance"] = c.Request.URL.Path
+
+	// gin keeps a content type that is set already
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(apiErr.Status, problem)
+}
@@ -0,0 +1,120 @@
+// Package closure closes suspended accounts once the grace period of their closure ended
+package closure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// Actor is the actor of the audit entries of closures the sweeper makes
+const Actor = "system:closure-sweeper"
+
+// Closer destroys the stack of an account, which closes the AWS account
+type Closer func(ctx context.Context, account *types.Account, org *types.Organization) error
+
+type Sweeper struct {
+	accountsDb *db.AccountDB
+	orgDb      *db.OrganizationDB
+	auditDb    *db.AuditDB
+	closer     Closer
+	now        func() time.Time
+}
+
+func NewSweeper(tables *db.Tables, closer Closer, now func() time.Time) *Sweeper {
+	if now == nil {
+		now = time.Now
+	}
+	return &Sweeper{
+		accountsDb: tables.Accounts,
+		orgDb:      tables.Organizations,
+		auditDb:    tables.Audit,
+		closer:     closer,
+		now:        now,
+	}
+}
+
+// Run closes the suspended accounts whose grace period ended, one after another. An account that fails to close
+// stays suspended and is retried by the next run.
+func (s *Sweeper) Run(ctx context.Context) error {
+	type due struct {
+		userID  string
+		orgName string
+		account *types.Account
+	}
+	var accounts []due
+	err := s.accountsDb.ScanByStatus(types.Suspended, func(userID string, orgName string, account *types.Account) bool {
+		if account.Closure != nil && !s.now().Before(account.Closure.CloseAfter) {
+			accounts = append(accounts, due{userID: userID, orgName: orgName, account: account})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, d := range accounts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.closeAccount(ctx, d.userID, d.orgName, d.account.AccountName); err != nil {
+			fmt.Printf("failed to close account '%s' in org '%s': %s\n", d.account.AccountName, d.orgName, err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *Sweeper) closeAccount(ctx context.Context, userID string, orgName string, accountName string) error {
+	// the scan is eventually consistent and the closure might have been cancelled in the meantime
+	version, account, err := s.accountsDb.GetItemWithVersion(userID, orgName, accountName, true)
+	if err != nil {
+		return err
+	}
+	if account == nil || account.Status != types.Suspended || account.Closure == nil || s.now().Before(account.Closure.CloseAfter) {
+		return nil
+	}
+
+	org, err := s.orgDb.GetItem(userID, orgName, false)
+	if err != nil {
+		return err
+	}
+	if org == nil {
+		return fmt.Errorf("org does not exist")
+	}
+
+	// closing can't be cancelled anymore
+	err = s.accountsDb.UpdateStatus(userID, orgName, accountName, version, types.Closing)
+	if db.IsConditionalCheckFailed(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.auditDb.RecordTransition(Actor, "", userID, orgName, accountName, version, types.Closing, types.AuditSucceeded, "")
+	version++
+
+	fmt.Printf("Closing account '%s' in org '%s'\n", accountName, orgName)
+	if err := s.closer(ctx, account, org); err != nil {
+		if statusErr := s.accountsDb.UpdateStatus(userID, orgName, accountName, version, types.Suspended); statusErr != nil {
+			fmt.Printf("failed to mark account '%s' as suspended again: %s\n", accountName, statusErr.Error())
+		} else {
+			s.auditDb.RecordTransition(Actor, "", userID, orgName, accountName, version, types.Suspended, types.AuditFailed, err.Error())
+		}
+		return err
+	}
+
+	if err := s.accountsDb.DeleteItem(userID, orgName, accountName); err != nil {
+		return err
+	}
+	// the account is gone, so the entry has no version after the closure
+	s.auditDb.Record(Actor, userID, orgName, &types.AuditEntry{
+		Action:        "account.closed",
+		Target:        "accounts/" + accountName,
+		BeforeVersion: &version,
+		Outcome:       types.AuditSucceeded,
+	})
+	return nil
+}
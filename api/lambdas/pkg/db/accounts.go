@@ -2,11 +2,14 @@ package db
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/flostadler/festus/api/pkg/validation"
 )
 
 type AccountItem struct {
@@ -15,11 +18,47 @@ type AccountItem struct {
 	AccountName     string `dynamodbav:"accountName"`
 	Email           string `dynamodbav:"email"`
 	ParentID        string `dynamodbav:"parentID"`
+	Unit            string `dynamodbav:"unit,omitempty"`
 	AwsAccessKey    string `dynamodbav:"awsAccessKey"`
 	AwsSecretKey    string `dynamodbav:"awsSecretKey"`
 	AwsSessionToken string `dynamodbav:"awsSessionToken"`
+	Tags            map[string]string `dynamodbav:"tags,omitempty"`
+	ParentHistory   []ParentMoveItem  `dynamodbav:"parentHistory,omitempty"`
 	Version int `dynamodbav:"accountVersion"`
+	// AppliedVersion is the version whose desired state the stream processor applied last
+	AppliedVersion *int `dynamodbav:"appliedVersion,omitempty"`
+	// FailedVersion is the version at which the stream processor gave up applying the desired state. The state is
+	// applied again once the account changes
+	FailedVersion *int `dynamodbav:"failedVersion,omitempty"`
 	Status int `dynamodbav:"accountStatus"`
+	LastDeploymentID string `dynamodbav:"lastDeploymentID,omitempty"`
+	DriftStatus string `dynamodbav:"driftStatus,omitempty"`
+	DriftSummary string `dynamodbav:"driftSummary,omitempty"`
+	DriftCheckedAt *time.Time `dynamodbav:"driftCheckedAt,omitempty"`
+	Closure *AccountClosureItem `dynamodbav:"closure,omitempty"`
+	AccountID string `dynamodbav:"accountID,omitempty"`
+	Imported bool `dynamodbav:"imported,omitempty"`
+	AccessRole string `dynamodbav:"accessRole,omitempty"`
+	LastUpdateVersion int `dynamodbav:"lastUpdateVersion,omitempty"`
+	// DeploymentLeaseUntil is the unix time in seconds until which the started deployment owns the account. A
+	// deployment that ends without marking the account provisioned or failed is resumed once it passed
+	DeploymentLeaseUntil int64 `dynamodbav:"deploymentLeaseUntil,omitempty"`
+}
+
+type AccountClosureItem struct {
+	RequestedAt   time.Time `dynamodbav:"requestedAt"`
+	CloseAfter    time.Time `dynamodbav:"closeAfter"`
+	SuspendedUnit string    `dynamodbav:"suspendedUnit,omitempty"`
+	Unit          string    `dynamodbav:"unit,omitempty"`
+	ParentID      string    `dynamodbav:"parentID,omitempty"`
+}
+
+type ParentMoveItem struct {
+	From     string    `dynamodbav:"from"`
+	To       string    `dynamodbav:"to"`
+	FromUnit string    `dynamodbav:"fromUnit,omitempty"`
+	ToUnit   string    `dynamodbav:"toUnit,omitempty"`
+	MovedAt  time.Time `dynamodbav:"movedAt"`
 }
 
 type AccountDB struct {
@@ -39,9 +78,14 @@ func (db *AccountDB) PutItem(UserID string, orgName string, account *types.Accou
 		AccountName:     account.AccountName,
 		Email:           account.Email,
 		ParentID:        account.ParentID,
+		Unit:            account.Unit,
 		AwsAccessKey:    account.AwsAccessKey,
 		AwsSecretKey:    account.AwsSecretKey,
 		AwsSessionToken: account.AwsSessionToken,
+		Tags: account.Tags,
+		AccountID: account.AccountID,
+		Imported: account.Imported,
+		AccessRole: account.AccessRole,
 		Status: int(account.Status),
 		Version: 0,
 	}
@@ -51,15 +95,24 @@ func (db *AccountDB) PutItem(UserID string, orgName string, account *types.Accou
 		return nil, err
 	}
 
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(db.tableName),
-		Item:      item,
-		ConditionExpression: aws.String("attribute_not_exists(pk) AND attribute_not_exists(sk)"),
+	items := []*dynamodb.TransactWriteItem{{
+		Put: &dynamodb.Put{
+			TableName: aws.String(db.tableName),
+			Item:      item,
+			ConditionExpression: aws.String("attribute_not_exists(pk) AND attribute_not_exists(sk)"),
+		},
+	}}
+	if account.Email != "" {
+		email, err := db.putEmail(UserID, orgName, account.AccountName, account.Email)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, email)
 	}
 
-	_, err = db.ddb.PutItem(input)
+	_, err = db.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
 	if err != nil {
-		return nil, err
+		return nil, emailTaken(err, 1)
 	}
 
 	return db.GetItem(UserID, orgName, account.AccountName, true)
@@ -71,6 +124,24 @@ func (db *AccountDB) GetItem(userID string, orgName string, accountName string,
 }
 
 func (db *AccountDB) GetItemWithVersion(userID string, orgName string, accountName string, consistentRead bool) (int, *types.Account, error) {
+	acc, err := db.getItem(userID, orgName, accountName, consistentRead)
+	if err != nil || acc == nil {
+		return 0, nil, err
+	}
+	return acc.Version, acc.ToAccount(), nil
+}
+
+// GetItemWithVersions returns the account together with its current version, the version the stream processor
+// handled last and until when the running deployment owns the account
+func (db *AccountDB) GetItemWithVersions(userID string, orgName string, accountName string) (int, int, time.Time, *types.Account, error) {
+	acc, err := db.getItem(userID, orgName, accountName, true)
+	if err != nil || acc == nil {
+		return 0, 0, time.Time{}, nil, err
+	}
+	return acc.Version, acc.LastHandledVersion(), time.Unix(acc.DeploymentLeaseUntil, 0), acc.ToAccount(), nil
+}
+
+func (db *AccountDB) getItem(userID string, orgName string, accountName string, consistentRead bool) (*AccountItem, error) {
 	input := &dynamodb.GetItemInput{
 		TableName:      aws.String(db.tableName),
 		ConsistentRead: aws.Bool(consistentRead),
@@ -86,30 +157,209 @@ func (db *AccountDB) GetItemWithVersion(userID string, orgName string, accountNa
 
 	result, err := db.ddb.GetItem(input)
 	if err != nil {
-		return 0, nil, err
+		return nil, err
 	}
 
 	if result.Item == nil {
-		return 0, nil, nil
+		return nil, nil
 	}
 
 	var acc AccountItem
 	err = dynamodbattribute.UnmarshalMap(result.Item, &acc)
 	if err != nil {
-		return 0, nil, err
+		return nil, err
+	}
+
+	return &acc, nil
+}
+
+// LastAppliedVersion returns the version whose desired state was applied last, or -1 if none was.
+// Items written before versions were tracked count as applied unless they are still pending.
+func (acc *AccountItem) LastAppliedVersion() int {
+	if acc.AppliedVersion != nil {
+		return *acc.AppliedVersion
+	}
+	if acc.Status == int(types.Pending) {
+		return -1
+	}
+	return acc.Version
+}
+
+// LastHandledVersion returns the version the stream processor handled last, either by applying it or by failing to
+func (acc *AccountItem) LastHandledVersion() int {
+	applied := acc.LastAppliedVersion()
+	if acc.FailedVersion != nil && *acc.FailedVersion > applied {
+		return *acc.FailedVersion
 	}
+	return applied
+}
 
-	return acc.Version, &types.Account{
+func (acc *AccountItem) ToAccount() *types.Account {
+	return &types.Account{
 		AccountName: acc.AccountName,
 		Email: acc.Email,
 		ParentID: acc.ParentID,
+		Unit: acc.Unit,
 		AwsAccessKey: acc.AwsAccessKey,
 		AwsSecretKey: acc.AwsSecretKey,
 		AwsSessionToken: acc.AwsSessionToken,
+		Tags: acc.Tags,
+		ParentHistory: toParentMoves(acc.ParentHistory),
 		Status: types.AccountStatus(acc.Status),
-	}, nil
+		LastDeploymentID: acc.LastDeploymentID,
+		DriftStatus: types.DriftStatus(acc.DriftStatus),
+		DriftSummary: acc.DriftSummary,
+		DriftCheckedAt: acc.DriftCheckedAt,
+		Closure: toClosure(acc.Closure),
+		AccountID: acc.AccountID,
+		Imported: acc.Imported,
+		AccessRole: acc.AccessRole,
+		LastUpdateVersion: acc.LastUpdateVersion,
+	}
+}
+
+func toClosure(item *AccountClosureItem) *types.AccountClosure {
+	if item == nil {
+		return nil
+	}
+	return &types.AccountClosure{
+		RequestedAt:   item.RequestedAt,
+		CloseAfter:    item.CloseAfter,
+		SuspendedUnit: item.SuspendedUnit,
+		Unit:          item.Unit,
+		ParentID:      item.ParentID,
+	}
+}
+
+func toParentMoves(items []ParentMoveItem) []types.ParentMove {
+	if items == nil {
+		return nil
+	}
+	moves := make([]types.ParentMove, len(items))
+	for i, item := range items {
+		moves[i] = types.ParentMove{From: item.From, To: item.To, FromUnit: item.FromUnit, ToUnit: item.ToUnit, MovedAt: item.MovedAt}
+	}
+	return moves
+}
+
+// ListItems returns the accounts of an org. If driftStatus is set only accounts with that drift status are returned
+func (db *AccountDB) ListItems(userID string, orgName string, driftStatus types.DriftStatus) ([]*types.Account, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(getAccountPk(userID)),
+			},
+			":prefix": {
+				S: aws.String(getAccountSk(orgName, "")),
+			},
+		},
+	}
+	if driftStatus != "" {
+		input.FilterExpression = aws.String("driftStatus = :driftStatus")
+		input.ExpressionAttributeValues[":driftStatus"] = &dynamodb.AttributeValue{S: aws.String(string(driftStatus))}
+	}
+
+	accounts := []*types.Account{}
+	var unmarshalErr error
+	err := db.ddb.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var acc AccountItem
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(item, &acc); unmarshalErr != nil {
+				return false
+			}
+			accounts = append(accounts, acc.ToAccount())
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	return accounts, nil
+}
+
+// ScanByStatus pages through the accounts of all users that have the given status and calls fn for each of them
+// until it returns false
+func (db *AccountDB) ScanByStatus(status types.AccountStatus, fn func(userID string, orgName string, account *types.Account) bool) error {
+	input := &dynamodb.ScanInput{
+		TableName:        aws.String(db.tableName),
+		FilterExpression: aws.String("begins_with(pk, :prefix) AND accountStatus = :status"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":prefix": {
+				S: aws.String(getAccountPk("")),
+			},
+			":status": {
+				N: aws.String(fmt.Sprintf("%d", int(status))),
+			},
+		},
+	}
+
+	var unmarshalErr error
+	err := db.ddb.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var acc AccountItem
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(item, &acc); unmarshalErr != nil {
+				return false
+			}
+			// the PK has the form of "ACC#:userId" and the SK "ORG#:orgName#ACC#:accountName"
+			userID := strings.TrimPrefix(acc.Pk, getAccountPk(""))
+			orgName := strings.Split(acc.Sk, "#")[1]
+			if !fn(userID, orgName, acc.ToAccount()) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return unmarshalErr
+}
+
+// UpdateDrift records the result of a drift check. It doesn't bump the version because the desired state is unchanged,
+// and only applies while the account is still created so it can't clobber a running deployment
+func (db *AccountDB) UpdateDrift(userID string, orgName string, accountName string, driftStatus types.DriftStatus, summary string, checkedAt time.Time) error {
+	checkedAtValue, err := dynamodbattribute.Marshal(checkedAt)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getAccountPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getAccountSk(orgName, accountName)),
+			},
+		},
+		ConditionExpression: aws.String("accountStatus = :created"),
+		UpdateExpression: aws.String("SET driftStatus = :driftStatus, driftSummary = :driftSummary, driftCheckedAt = :checkedAt"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":created": {
+				N: aws.String(fmt.Sprintf("%d", int(types.Created))),
+			},
+			":driftStatus": {
+				S: aws.String(string(driftStatus)),
+			},
+			":driftSummary": {
+				S: aws.String(summary),
+			},
+			":checkedAt": checkedAtValue,
+		},
+	}
+
+	_, err = db.ddb.UpdateItem(input)
+	return err
 }
 
+// DeleteItem removes an account and releases its email
 func (db *AccountDB) DeleteItem(userID string, orgName string, accountName string) error {
 	input := &dynamodb.DeleteItemInput{
 		TableName: aws.String(db.tableName),
@@ -121,13 +371,31 @@ func (db *AccountDB) DeleteItem(userID string, orgName string, accountName strin
 				S: aws.String(getAccountSk(orgName, accountName)),
 			},
 		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
 	}
 
-	_, err := db.ddb.DeleteItem(input)
-	return err
+	result, err := db.ddb.DeleteItem(input)
+	if err != nil {
+		return err
+	}
+
+	var acc AccountItem
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &acc); err != nil || acc.Email == "" {
+		return err
+	}
+	// the email is released after the account is gone, so a failure only keeps it reserved for longer
+	return db.releaseEmail(userID, orgName, accountName, acc.Email)
 }
 
-func (db *AccountDB) UpdateStatus(userID string, orgName string, accountName string, expectedVersion int, status types.AccountStatus) error {
+// UpdateDesiredState stores changes to the desired state of an account and bumps its version, which makes the
+// stream processor apply them. A changed email is reserved in place of the previous one. The parent is changed with
+// MoveParent so that moves are recorded
+func (db *AccountDB) UpdateDesiredState(userID string, orgName string, accountName string, expectedVersion int, previousEmail string, account *types.Account) error {
+	tags, err := dynamodbattribute.Marshal(account.Tags)
+	if err != nil {
+		return err
+	}
+
 	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(db.tableName),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -139,7 +407,7 @@ func (db *AccountDB) UpdateStatus(userID string, orgName string, accountName str
 			},
 		},
 		ConditionExpression: aws.String("accountVersion = :expectedVersion"),
-		UpdateExpression: aws.String("SET accountVersion = accountVersion + :increment, accountStatus = :newStatus"),
+		UpdateExpression: aws.String("SET accountVersion = accountVersion + :increment, email = :email, tags = :tags"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":expectedVersion": {
 				N: aws.String(fmt.Sprintf("%d", expectedVersion)),
@@ -147,10 +415,263 @@ func (db *AccountDB) UpdateStatus(userID string, orgName string, accountName str
 			":increment": {
 				N: aws.String("1"),
 			},
-			":newStatus": {
-				N: aws.String(fmt.Sprintf("%d", int(status))),
+			":email": {
+				S: aws.String(account.Email),
+			},
+			":tags": tags,
+		},
+	}
+
+	if validation.NormalizeEmail(previousEmail) == validation.NormalizeEmail(account.Email) {
+		_, err = db.ddb.UpdateItem(input)
+		return err
+	}
+
+	items := []*dynamodb.TransactWriteItem{{
+		Update: &dynamodb.Update{
+			TableName:                 input.TableName,
+			Key:                       input.Key,
+			ConditionExpression:       input.ConditionExpression,
+			UpdateExpression:          input.UpdateExpression,
+			ExpressionAttributeValues: input.ExpressionAttributeValues,
+		},
+	}}
+	if previousEmail != "" {
+		items = append(items, db.deleteEmail(userID, orgName, accountName, previousEmail))
+	}
+	if account.Email != "" {
+		email, err := db.putEmail(userID, orgName, accountName, account.Email)
+		if err != nil {
+			return err
+		}
+		items = append(items, email)
+	}
+
+	_, err = db.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	return emailTaken(err, len(items)-1)
+}
+
+// MoveParent changes the parent of an account, appends the move to its history and bumps its version, which makes
+// the stream processor move the account
+func (db *AccountDB) MoveParent(userID string, orgName string, accountName string, expectedVersion int, move types.ParentMove) error {
+	moves, err := dynamodbattribute.Marshal([]ParentMoveItem{{
+		From:     move.From,
+		To:       move.To,
+		FromUnit: move.FromUnit,
+		ToUnit:   move.ToUnit,
+		MovedAt:  move.MovedAt,
+	}})
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getAccountPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getAccountSk(orgName, accountName)),
+			},
+		},
+		ConditionExpression: aws.String("accountVersion = :expectedVersion"),
+		UpdateExpression: aws.String("SET accountVersion = accountVersion + :increment, parentID = :parentID, unit = :unit, " +
+			"parentHistory = list_append(if_not_exists(parentHistory, :empty), :moves)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":expectedVersion": {
+				N: aws.String(fmt.Sprintf("%d", expectedVersion)),
+			},
+			":increment": {
+				N: aws.String("1"),
+			},
+			":parentID": {
+				S: aws.String(move.To),
+			},
+			":unit": {
+				S: aws.String(move.ToUnit),
+			},
+			":empty": {
+				L: []*dynamodb.AttributeValue{},
+			},
+			":moves": moves,
+		},
+	}
+
+	_, err = db.ddb.UpdateItem(input)
+	return err
+}
+
+// Suspend records the closure of an account and marks it as suspended. With a move the account is moved to the
+// suspended unit as well, which the stream processor applies like any other move. Without one nothing needs to be
+// applied, so the new version counts as applied right away
+func (db *AccountDB) Suspend(userID string, orgName string, accountName string, expectedVersion int, closure types.AccountClosure, move *types.ParentMove) error {
+	closureValue, err := dynamodbattribute.Marshal(AccountClosureItem{
+		RequestedAt:   closure.RequestedAt,
+		CloseAfter:    closure.CloseAfter,
+		SuspendedUnit: closure.SuspendedUnit,
+		Unit:          closure.Unit,
+		ParentID:      closure.ParentID,
+	})
+	if err != nil {
+		return err
+	}
+	return db.updateClosure(userID, orgName, accountName, expectedVersion, types.Suspended, closureValue, move)
+}
+
+// CancelClosure clears the closure of a suspended account and marks it as created again. With a move the account is
+// moved back to where it was placed before it was suspended
+func (db *AccountDB) CancelClosure(userID string, orgName string, accountName string, expectedVersion int, move *types.ParentMove) error {
+	return db.updateClosure(userID, orgName, accountName, expectedVersion, types.Created, nil, move)
+}
+
+// updateClosure sets the status and the closure of an account and optionally moves it. A nil closure removes it
+func (db *AccountDB) updateClosure(userID string, orgName string, accountName string, expectedVersion int, status types.AccountStatus, closure *dynamodb.AttributeValue, move *types.ParentMove) error {
+	sets := []string{"accountVersion = accountVersion + :increment", "accountStatus = :newStatus"}
+	values := map[string]*dynamodb.AttributeValue{
+		":expectedVersion": {
+			N: aws.String(fmt.Sprintf("%d", expectedVersion)),
+		},
+		":increment": {
+			N: aws.String("1"),
+		},
+		":newStatus": {
+			N: aws.String(fmt.Sprintf("%d", int(status))),
+		},
+	}
+	if closure != nil {
+		sets = append(sets, "closure = :closure")
+		values[":closure"] = closure
+	}
+
+	if move != nil {
+		moves, err := dynamodbattribute.Marshal([]ParentMoveItem{{
+			From:     move.From,
+			To:       move.To,
+			FromUnit: move.FromUnit,
+			ToUnit:   move.ToUnit,
+			MovedAt:  move.MovedAt,
+		}})
+		if err != nil {
+			return err
+		}
+		sets = append(sets, "parentID = :parentID", "unit = :unit", "parentHistory = list_append(if_not_exists(parentHistory, :empty), :moves)")
+		values[":parentID"] = &dynamodb.AttributeValue{S: aws.String(move.To)}
+		values[":unit"] = &dynamodb.AttributeValue{S: aws.String(move.ToUnit)}
+		values[":empty"] = &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{}}
+		values[":moves"] = moves
+	} else {
+		sets = append(sets, "appliedVersion = :appliedVersion")
+		values[":appliedVersion"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", expectedVersion+1))}
+	}
+
+	update := "SET " + strings.Join(sets, ", ")
+	if closure == nil {
+		update += " REMOVE closure"
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getAccountPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getAccountSk(orgName, accountName)),
+			},
+		},
+		ConditionExpression:       aws.String("accountVersion = :expectedVersion"),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: values,
+	}
+
+	_, err := db.ddb.UpdateItem(input)
+	return err
+}
+
+// UpdateStatus moves the account into the given status. The stream processor doesn't act on the change, the version
+// it produces counts as handled
+func (db *AccountDB) UpdateStatus(userID string, orgName string, accountName string, expectedVersion int, status types.AccountStatus) error {
+	return db.updateStatus(userID, orgName, accountName, expectedVersion, status, "appliedVersion", "", nil)
+}
+
+// MarkProvisioned moves the account into the given status after its desired state was applied, records the ID of
+// its AWS account and the version of the Pulumi update that applied it, and ends the deployment
+func (db *AccountDB) MarkProvisioned(userID string, orgName string, accountName string, expectedVersion int, status types.AccountStatus, accountID string, updateVersion int) error {
+	update := ", lastUpdateVersion = :updateVersion"
+	values := map[string]*dynamodb.AttributeValue{
+		":updateVersion": {
+			N: aws.String(fmt.Sprintf("%d", updateVersion)),
+		},
+	}
+	if accountID != "" {
+		update += ", accountID = :accountID"
+		values[":accountID"] = &dynamodb.AttributeValue{
+			S: aws.String(accountID),
+		}
+	}
+	return db.updateStatus(userID, orgName, accountName, expectedVersion, status, "appliedVersion", update, values)
+}
+
+// MarkFailed moves the account into the given status after applying its desired state failed and ends the
+// deployment. A failed apply doesn't count as applied, it's retried once the account changes
+func (db *AccountDB) MarkFailed(userID string, orgName string, accountName string, expectedVersion int, status types.AccountStatus) error {
+	return db.updateStatus(userID, orgName, accountName, expectedVersion, status, "failedVersion", "", nil)
+}
+
+// StartDeployment moves the account into the given status and records the ID of the deployment that is about to run.
+// The version stays unhandled until the deployment marks the account provisioned or failed, so a deployment that
+// didn't finish is resumed after its lease passed. The Pulumi update of the previous deployment is forgotten
+func (db *AccountDB) StartDeployment(userID string, orgName string, accountName string, expectedVersion int, status types.AccountStatus, deploymentID string, leaseUntil time.Time) error {
+	return db.updateStatus(userID, orgName, accountName, expectedVersion, status, "", ", lastDeploymentID = :deploymentID, deploymentLeaseUntil = :leaseUntil REMOVE lastUpdateVersion", map[string]*dynamodb.AttributeValue{
+		":deploymentID": {
+			S: aws.String(deploymentID),
+		},
+		":leaseUntil": {
+			N: aws.String(fmt.Sprintf("%d", leaseUntil.Unix())),
+		},
+	})
+}
+
+// updateStatus moves the account into the given status. If handled names appliedVersion or failedVersion, the
+// version the change produces is recorded there and a running deployment ends. extraUpdate continues the SET clause,
+// updates without a handled version may end it with a REMOVE clause
+func (db *AccountDB) updateStatus(userID string, orgName string, accountName string, expectedVersion int, status types.AccountStatus, handled string, extraUpdate string, extraValues map[string]*dynamodb.AttributeValue) error {
+	update := "SET accountVersion = accountVersion + :increment, accountStatus = :newStatus" + extraUpdate
+	values := map[string]*dynamodb.AttributeValue{
+		":expectedVersion": {
+			N: aws.String(fmt.Sprintf("%d", expectedVersion)),
+		},
+		":increment": {
+			N: aws.String("1"),
+		},
+		":newStatus": {
+			N: aws.String(fmt.Sprintf("%d", int(status))),
+		},
+	}
+	if handled != "" {
+		update = "SET accountVersion = accountVersion + :increment, " + handled + " = :handledVersion, accountStatus = :newStatus" + extraUpdate + " REMOVE deploymentLeaseUntil"
+		values[":handledVersion"] = &dynamodb.AttributeValue{
+			N: aws.String(fmt.Sprintf("%d", expectedVersion+1)),
+		}
+	}
+	for k, v := range extraValues {
+		values[k] = v
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getAccountPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getAccountSk(orgName, accountName)),
 			},
 		},
+		ConditionExpression:       aws.String("accountVersion = :expectedVersion"),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: values,
 	}
 
 	_, err := db.ddb.UpdateItem(input)
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// APIKeyItem is stored twice: in the owner's partition to list the keys of an org, and in a partition of its own to
+// look the key up when it authenticates a request. Only the latter contains the hash of the secret.
+type APIKeyItem struct {
+	Pk         string     `dynamodbav:"pk"`
+	Sk         string     `dynamodbav:"sk"`
+	KeyID      string     `dynamodbav:"keyID"`
+	OwnerID    string     `dynamodbav:"ownerID"`
+	OrgName    string     `dynamodbav:"orgName"`
+	Name       string     `dynamodbav:"keyName"`
+	Hash       string     `dynamodbav:"hash,omitempty"`
+	Scopes     []string   `dynamodbav:"scopes"`
+	ExpiresAt  time.Time  `dynamodbav:"expiresAt"`
+	CreatedBy  string     `dynamodbav:"createdBy,omitempty"`
+	CreatedAt  time.Time  `dynamodbav:"createdAt"`
+	LastUsedAt *time.Time `dynamodbav:"lastUsedAt,omitempty"`
+}
+
+type APIKeyDB struct {
+	tableName string
+	ddb       *dynamodb.DynamoDB
+}
+
+func NewAPIKeyDB(ddb *dynamodb.DynamoDB, tableName string) *APIKeyDB {
+	return &APIKeyDB{ddb: ddb, tableName: tableName}
+}
+
+// PutItem stores a new key of an org together with the hash of its secret
+func (db *APIKeyDB) PutItem(ownerID string, orgName string, key *types.APIKey, hash string) (*types.APIKey, error) {
+	keyItem := APIKeyItem{
+		Pk:        getAPIKeyPk(ownerID),
+		Sk:        getAPIKeySk(orgName, key.ID),
+		KeyID:     key.ID,
+		OwnerID:   ownerID,
+		OrgName:   orgName,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+		CreatedBy: key.CreatedBy,
+		CreatedAt: key.CreatedAt,
+	}
+	lookupItem := keyItem
+	lookupItem.Pk = getAPIKeyPk(key.ID)
+	lookupItem.Sk = apiKeyLookupSk
+	lookupItem.Hash = hash
+
+	var items []*dynamodb.TransactWriteItem
+	for _, i := range []APIKeyItem{keyItem, lookupItem} {
+		item, err := dynamodbattribute.MarshalMap(i)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(db.tableName),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(pk) AND attribute_not_exists(sk)"),
+			},
+		})
+	}
+
+	_, err := db.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		return nil, err
+	}
+
+	return keyItem.ToAPIKey(), nil
+}
+
+// GetByID returns the key with the given ID including the hash of its secret, or nil if it doesn't exist
+func (db *APIKeyDB) GetByID(keyID string) (*APIKeyItem, error) {
+	input := &dynamodb.GetItemInput{
+		TableName:      aws.String(db.tableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getAPIKeyPk(keyID)),
+			},
+			"sk": {
+				S: aws.String(apiKeyLookupSk),
+			},
+		},
+	}
+
+	result, err := db.ddb.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var key APIKeyItem
+	err = dynamodbattribute.UnmarshalMap(result.Item, &key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// ListItems returns the keys of an org without their secrets
+func (db *APIKeyDB) ListItems(ownerID string, orgName string) ([]*types.APIKey, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(getAPIKeyPk(ownerID)),
+			},
+			":prefix": {
+				S: aws.String(getAPIKeySk(orgName, "")),
+			},
+		},
+	}
+
+	keys := []*types.APIKey{}
+	var unmarshalErr error
+	err := db.ddb.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var key APIKeyItem
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(item, &key); unmarshalErr != nil {
+				return false
+			}
+			keys = append(keys, key.ToAPIKey())
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	return keys, nil
+}
+
+// MarkUsed records when a key authenticated a request last. Keys revoked in the meantime fail the condition
+func (db *APIKeyDB) MarkUsed(key *APIKeyItem, usedAt time.Time) error {
+	usedAtValue, err := dynamodbattribute.Marshal(usedAt)
+	if err != nil {
+		return err
+	}
+
+	var items []*dynamodb.TransactWriteItem
+	for _, k := range [][2]string{
+		{getAPIKeyPk(key.OwnerID), getAPIKeySk(key.OrgName, key.KeyID)},
+		{getAPIKeyPk(key.KeyID), apiKeyLookupSk},
+	} {
+		items = append(items, &dynamodb.TransactWriteItem{
+			Update: &dynamodb.Update{
+				TableName: aws.String(db.tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(k[0])},
+					"sk": {S: aws.String(k[1])},
+				},
+				UpdateExpression:          aws.String("SET lastUsedAt = :usedAt"),
+				ConditionExpression:       aws.String("attribute_exists(pk)"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":usedAt": usedAtValue},
+			},
+		})
+	}
+
+	_, err = db.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	return err
+}
+
+// DeleteItem revokes a key. It fails the condition if the org has no key with that ID
+func (db *APIKeyDB) DeleteItem(ownerID string, orgName string, keyID string) error {
+	_, err := db.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Delete: &dynamodb.Delete{
+				TableName: aws.String(db.tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(getAPIKeyPk(ownerID))},
+					"sk": {S: aws.String(getAPIKeySk(orgName, keyID))},
+				},
+				ConditionExpression: aws.String("attribute_exists(pk)"),
+			}},
+			{Delete: &dynamodb.Delete{
+				TableName: aws.String(db.tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(getAPIKeyPk(keyID))},
+					"sk": {S: aws.String(apiKeyLookupSk)},
+				},
+			}},
+		},
+	})
+	return err
+}
+
+// DeleteAll revokes all keys of an org, e.g. after the org was deleted
+func (db *APIKeyDB) DeleteAll(ownerID string, orgName string) error {
+	keys, err := db.ListItems(ownerID, orgName)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := db.DeleteItem(ownerID, orgName, key.ID); err != nil && !IsConditionalCheckFailed(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (key *APIKeyItem) ToAPIKey() *types.APIKey {
+	return &types.APIKey{
+		ID:         key.KeyID,
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		ExpiresAt:  key.ExpiresAt,
+		CreatedBy:  key.CreatedBy,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+// apiKeyLookupSk is the sort key of the item that authenticates a key
+const apiKeyLookupSk = "APIKEY"
+
+func getAPIKeyPk(id string) string {
+	return fmt.Sprintf("APIKEY#%s", id)
+}
+
+func getAPIKeySk(orgName string, keyID string) string {
+	return fmt.Sprintf("ORG#%s#APIKEY#%s", orgName, keyID)
+}
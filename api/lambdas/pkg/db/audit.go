@@ -0,0 +1,209 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/google/uuid"
+)
+
+// auditTimeLayout has a fixed width, so the sort keys of entries sort by their time
+const auditTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// ErrInvalidCursor is returned for cursors that don't point into the audit log of the org
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// AuditItem is an entry of the audit log of an org. The entries of an org are stored in its own partition, sorted by
+// their time. Org names are only unique per owner, so the sort keys start with the owner
+type AuditItem struct {
+	Pk            string    `dynamodbav:"pk"`
+	Sk            string    `dynamodbav:"sk"`
+	ID            string    `dynamodbav:"auditID"`
+	Timestamp     time.Time `dynamodbav:"timestamp"`
+	Actor         string    `dynamodbav:"actor"`
+	RequestID     string    `dynamodbav:"requestID,omitempty"`
+	Action        string    `dynamodbav:"action"`
+	Target        string    `dynamodbav:"target"`
+	BeforeVersion *int      `dynamodbav:"beforeVersion,omitempty"`
+	AfterVersion  *int      `dynamodbav:"afterVersion,omitempty"`
+	Outcome       string    `dynamodbav:"outcome"`
+	Status        string    `dynamodbav:"auditStatus,omitempty"`
+	Message       string    `dynamodbav:"message,omitempty"`
+}
+
+type AuditDB struct {
+	tableName string
+	ddb       *dynamodb.DynamoDB
+}
+
+func NewAuditDB(ddb *dynamodb.DynamoDB, tableName string) *AuditDB {
+	return &AuditDB{ddb: ddb, tableName: tableName}
+}
+
+// PutItem appends an entry to the audit log of an org. The ID and the timestamp are set if they are empty
+func (db *AuditDB) PutItem(ownerID string, orgName string, entry *types.AuditEntry) (*types.AuditEntry, error) {
+	if entry.ID == "" {
+		entry.ID = uuid.NewString()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Timestamp = entry.Timestamp.UTC()
+
+	auditItem := AuditItem{
+		Pk:            getAuditPk(orgName),
+		Sk:            getAuditSk(ownerID, entry.Timestamp, entry.ID),
+		ID:            entry.ID,
+		Timestamp:     entry.Timestamp,
+		Actor:         entry.Actor,
+		RequestID:     entry.RequestID,
+		Action:        entry.Action,
+		Target:        entry.Target,
+		BeforeVersion: entry.BeforeVersion,
+		AfterVersion:  entry.AfterVersion,
+		Outcome:       string(entry.Outcome),
+		Status:        entry.Status,
+		Message:       entry.Message,
+	}
+
+	item, err := dynamodbattribute.MarshalMap(auditItem)
+	if err != nil {
+		return nil, err
+	}
+
+	// entries are append-only, an existing one is never overwritten
+	_, err = db.ddb.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(db.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk)"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return auditItem.ToEntry(), nil
+}
+
+// Record appends an entry made by a background actor to the audit log of an org. Failing to record it is only
+// logged, so it doesn't fail the operation it audits
+func (db *AuditDB) Record(actor string, ownerID string, orgName string, entry *types.AuditEntry) {
+	entry.Actor = actor
+	if _, err := db.PutItem(ownerID, orgName, entry); err != nil {
+		fmt.Printf("failed to record audit entry for '%s' in org '%s': %s\n", entry.Action, orgName, err.Error())
+	}
+}
+
+// RecordTransition records a status transition of an account, which bumped its version
+func (db *AuditDB) RecordTransition(actor string, requestID string, ownerID string, orgName string, accountName string, version int, status types.AccountStatus, outcome types.AuditOutcome, message string) {
+	after := version + 1
+	db.Record(actor, ownerID, orgName, &types.AuditEntry{
+		RequestID:     requestID,
+		Action:        "account.status",
+		Target:        "accounts/" + accountName,
+		BeforeVersion: &version,
+		AfterVersion:  &after,
+		Outcome:       outcome,
+		Status:        status.String(),
+		Message:       message,
+	})
+}
+
+// ListItems returns up to limit entries of an org between from and to, newest first. Zero times leave the range
+// open. After is the cursor of the previous page, the returned cursor is empty on the last page
+func (db *AuditDB) ListItems(ownerID string, orgName string, from time.Time, to time.Time, limit int, after string) ([]*types.AuditEntry, string, error) {
+	prefix := getAuditOwnerPrefix(ownerID)
+	lower := prefix
+	if !from.IsZero() {
+		lower += from.UTC().Format(auditTimeLayout)
+	}
+	// "~" sorts after the digits and separators of the sort keys
+	upper := prefix + "~"
+	if !to.IsZero() {
+		upper = prefix + to.UTC().Format(auditTimeLayout) + "~"
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		KeyConditionExpression: aws.String("pk = :pk AND sk BETWEEN :lower AND :upper"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(getAuditPk(orgName)),
+			},
+			":lower": {
+				S: aws.String(lower),
+			},
+			":upper": {
+				S: aws.String(upper),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	}
+	if after != "" {
+		if !strings.HasPrefix(after, prefix) {
+			return nil, "", ErrInvalidCursor
+		}
+		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getAuditPk(orgName)),
+			},
+			"sk": {
+				S: aws.String(after),
+			},
+		}
+	}
+
+	output, err := db.ddb.Query(input)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entries := []*types.AuditEntry{}
+	for _, item := range output.Items {
+		var auditItem AuditItem
+		if err := dynamodbattribute.UnmarshalMap(item, &auditItem); err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, auditItem.ToEntry())
+	}
+
+	next := ""
+	if sk, ok := output.LastEvaluatedKey["sk"]; ok && sk.S != nil {
+		next = *sk.S
+	}
+	return entries, next, nil
+}
+
+func (item *AuditItem) ToEntry() *types.AuditEntry {
+	return &types.AuditEntry{
+		ID:            item.ID,
+		Timestamp:     item.Timestamp,
+		Actor:         item.Actor,
+		RequestID:     item.RequestID,
+		Action:        item.Action,
+		Target:        item.Target,
+		BeforeVersion: item.BeforeVersion,
+		AfterVersion:  item.AfterVersion,
+		Outcome:       types.AuditOutcome(item.Outcome),
+		Status:        item.Status,
+		Message:       item.Message,
+	}
+}
+
+func getAuditPk(orgName string) string {
+	return fmt.Sprintf("AUDIT#%s", orgName)
+}
+
+func getAuditOwnerPrefix(ownerID string) string {
+	return fmt.Sprintf("OWNER#%s#", ownerID)
+}
+
+func getAuditSk(ownerID string, timestamp time.Time, id string) string {
+	return fmt.Sprintf("%s%s#%s", getAuditOwnerPrefix(ownerID), timestamp.UTC().Format(auditTimeLayout), id)
+}
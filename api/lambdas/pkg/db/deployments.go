@@ -0,0 +1,122 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+type DeploymentEventItem struct {
+	Pk           string `dynamodbav:"pk"`
+	Sk           string `dynamodbav:"sk"`
+	DeploymentID string `dynamodbav:"deploymentID"`
+	Sequence     int    `dynamodbav:"sequence"`
+	Timestamp    int    `dynamodbav:"timestamp"`
+	Status       string `dynamodbav:"eventStatus"`
+	URN          string `dynamodbav:"urn,omitempty"`
+	ResourceType string `dynamodbav:"resourceType,omitempty"`
+	Op           string `dynamodbav:"op,omitempty"`
+	Message      string `dynamodbav:"message,omitempty"`
+}
+
+type DeploymentDB struct {
+	tableName string
+	ddb       *dynamodb.DynamoDB
+}
+
+func NewDeploymentDB(ddb *dynamodb.DynamoDB, tableName string) *DeploymentDB {
+	return &DeploymentDB{ddb: ddb, tableName: tableName}
+}
+
+func (db *DeploymentDB) PutEvent(userID string, orgName string, accountName string, deploymentID string, event types.DeploymentEvent) error {
+	eventItem := DeploymentEventItem{
+		Pk:           getDeploymentPk(userID),
+		Sk:           getDeploymentEventSk(orgName, accountName, deploymentID, event.Sequence),
+		DeploymentID: deploymentID,
+		Sequence:     event.Sequence,
+		Timestamp:    event.Timestamp,
+		Status:       string(event.Status),
+		URN:          event.URN,
+		ResourceType: event.ResourceType,
+		Op:           event.Op,
+		Message:      event.Message,
+	}
+
+	item, err := dynamodbattribute.MarshalMap(eventItem)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ddb.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(db.tableName),
+		Item:      item,
+	})
+	return err
+}
+
+// ListEvents returns the events of a deployment ordered by their sequence number
+func (db *DeploymentDB) ListEvents(userID string, orgName string, accountName string, deploymentID string) ([]types.DeploymentEvent, error) {
+	return db.listEvents(&dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(getDeploymentPk(userID)),
+			},
+			":prefix": {
+				S: aws.String(getDeploymentSk(orgName, accountName, deploymentID)),
+			},
+		},
+	})
+}
+
+func (db *DeploymentDB) listEvents(input *dynamodb.QueryInput) ([]types.DeploymentEvent, error) {
+	events := []types.DeploymentEvent{}
+	var unmarshalErr error
+	err := db.ddb.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var eventItem DeploymentEventItem
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(item, &eventItem); unmarshalErr != nil {
+				return false
+			}
+			events = append(events, eventItem.ToEvent())
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	return events, nil
+}
+
+func (item *DeploymentEventItem) ToEvent() types.DeploymentEvent {
+	return types.DeploymentEvent{
+		Sequence:     item.Sequence,
+		Timestamp:    item.Timestamp,
+		Status:       types.DeploymentEventStatus(item.Status),
+		URN:          item.URN,
+		ResourceType: item.ResourceType,
+		Op:           item.Op,
+		Message:      item.Message,
+	}
+}
+
+func getDeploymentPk(userID string) string {
+	return fmt.Sprintf("DEPLOY#%s", userID)
+}
+
+func getDeploymentSk(orgName string, accountName string, deploymentID string) string {
+	return fmt.Sprintf("ORG#%s#ACC#%s#DEPLOY#%s#", orgName, accountName, deploymentID)
+}
+
+// getDeploymentEventSk pads the sequence number so events sort in the order they were emitted
+func getDeploymentEventSk(orgName string, accountName string, deploymentID string, sequence int) string {
+	return fmt.Sprintf("%sEVENT#%010d", getDeploymentSk(orgName, accountName, deploymentID), sequence)
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/flostadler/festus/api/pkg/validation"
+)
+
+// ErrEmailTaken is returned when an account is written with an email that another account of the org uses
+var ErrEmailTaken = errors.New("email is already used by another account of the organization")
+
+// EmailItem reserves the email of an account within its org. It's written in the same transaction as the account,
+// so no two accounts of an org share an email
+type EmailItem struct {
+	Pk          string `dynamodbav:"pk"`
+	Sk          string `dynamodbav:"sk"`
+	AccountName string `dynamodbav:"accountName"`
+}
+
+// GetEmailOwner returns the name of the account of an org that uses an email, or an empty string if none does
+func (db *AccountDB) GetEmailOwner(userID string, orgName string, email string) (string, error) {
+	result, err := db.ddb.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(db.tableName),
+		ConsistentRead: aws.Bool(true),
+		Key:            emailKey(userID, orgName, email),
+	})
+	if err != nil {
+		return "", err
+	}
+	if result.Item == nil {
+		return "", nil
+	}
+
+	var item EmailItem
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &item); err != nil {
+		return "", err
+	}
+	return item.AccountName, nil
+}
+
+// putEmail reserves an email for an account. It fails if another account of the org reserved it
+func (db *AccountDB) putEmail(userID string, orgName string, accountName string, email string) (*dynamodb.TransactWriteItem, error) {
+	item, err := dynamodbattribute.MarshalMap(EmailItem{
+		Pk:          getEmailPk(userID),
+		Sk:          getEmailSk(orgName, email),
+		AccountName: accountName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName:                 aws.String(db.tableName),
+			Item:                      item,
+			ConditionExpression:       aws.String(unreservedOrReservedBy),
+			ExpressionAttributeValues: reservedByValues(accountName),
+		},
+	}, nil
+}
+
+// deleteEmail releases the email of an account as part of a transaction. Accounts written before emails were
+// reserved have none to release
+func (db *AccountDB) deleteEmail(userID string, orgName string, accountName string, email string) *dynamodb.TransactWriteItem {
+	return &dynamodb.TransactWriteItem{
+		Delete: &dynamodb.Delete{
+			TableName:                 aws.String(db.tableName),
+			Key:                       emailKey(userID, orgName, email),
+			ConditionExpression:       aws.String(unreservedOrReservedBy),
+			ExpressionAttributeValues: reservedByValues(accountName),
+		},
+	}
+}
+
+// releaseEmail releases the email of an account unless another account reserved it in the meantime
+func (db *AccountDB) releaseEmail(userID string, orgName string, accountName string, email string) error {
+	_, err := db.ddb.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:                 aws.String(db.tableName),
+		Key:                       emailKey(userID, orgName, email),
+		ConditionExpression:       aws.String(unreservedOrReservedBy),
+		ExpressionAttributeValues: reservedByValues(accountName),
+	})
+	if IsConditionalCheckFailed(err) {
+		return nil
+	}
+	return err
+}
+
+const unreservedOrReservedBy = "attribute_not_exists(pk) OR accountName = :accountName"
+
+func reservedByValues(accountName string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		":accountName": {
+			S: aws.String(accountName),
+		},
+	}
+}
+
+// emailTaken translates the failed condition of the email reservation at index of a transaction into ErrEmailTaken
+func emailTaken(err error, index int) error {
+	var canceled *dynamodb.TransactionCanceledException
+	if !errors.As(err, &canceled) || len(canceled.CancellationReasons) <= index {
+		return err
+	}
+	for i, reason := range canceled.CancellationReasons {
+		failed := reason.Code != nil && *reason.Code == "ConditionalCheckFailed"
+		if failed && i != index {
+			// the account itself changed, which is reported as it is
+			return err
+		}
+	}
+	if code := canceled.CancellationReasons[index].Code; code != nil && *code == "ConditionalCheckFailed" {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+func emailKey(userID string, orgName string, email string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(getEmailPk(userID)),
+		},
+		"sk": {
+			S: aws.String(getEmailSk(orgName, email)),
+		},
+	}
+}
+
+func getEmailPk(userID string) string {
+	return fmt.Sprintf("EMAIL#%s", userID)
+}
+
+func getEmailSk(orgName string, email string) string {
+	return fmt.Sprintf("ORG#%s#EMAIL#%s", orgName, validation.NormalizeEmail(email))
+}
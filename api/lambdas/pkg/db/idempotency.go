@@ -0,0 +1,179 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// IdempotencyItem stores the response of a request that was sent with an idempotency key, so that retries of the
+// request get the same response. DynamoDB removes expired items through the table's TTL on "ttl"
+type IdempotencyItem struct {
+	Pk string `dynamodbav:"pk"`
+	Sk string `dynamodbav:"sk"`
+	// RequestHash identifies the request the key was used for first
+	RequestHash string `dynamodbav:"requestHash"`
+	// Status is zero while the first request is still being handled
+	Status      int    `dynamodbav:"responseStatus"`
+	ContentType string `dynamodbav:"contentType,omitempty"`
+	Body        []byte `dynamodbav:"responseBody,omitempty"`
+	// BodyOmitted is set for responses that contained a secret, their body isn't stored
+	BodyOmitted bool      `dynamodbav:"bodyOmitted,omitempty"`
+	CreatedAt   time.Time `dynamodbav:"createdAt"`
+	// LeaseUntil is the unix time in seconds until which the first request owns the key. Retries of a request that
+	// didn't complete until then take the key over, e.g. after the request timed out
+	LeaseUntil int64 `dynamodbav:"leaseUntil"`
+	// TTL is the unix time in seconds after which the key can be reused
+	TTL int64 `dynamodbav:"ttl"`
+}
+
+// Completed tells whether the response of the request is stored
+func (item *IdempotencyItem) Completed() bool {
+	return item.Status != 0
+}
+
+type IdempotencyDB struct {
+	tableName string
+	ddb       *dynamodb.DynamoDB
+}
+
+func NewIdempotencyDB(ddb *dynamodb.DynamoDB, tableName string) *IdempotencyDB {
+	return &IdempotencyDB{ddb: ddb, tableName: tableName}
+}
+
+// Claim reserves a key of a user for a request until ttl passed. If the key is already taken, the item that took
+// it is returned instead. Expired keys can be claimed again even if DynamoDB didn't remove them yet, and so can keys
+// of the same request whose lease passed before it completed
+func (db *IdempotencyDB) Claim(userID string, key string, requestHash string, now time.Time, lease time.Duration, ttl time.Duration) (*IdempotencyItem, error) {
+	idempotencyItem := IdempotencyItem{
+		Pk:          getIdempotencyPk(userID),
+		Sk:          getIdempotencySk(key),
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		LeaseUntil:  now.Add(lease).Unix(),
+		TTL:         now.Add(ttl).Unix(),
+	}
+
+	item, err := dynamodbattribute.MarshalMap(idempotencyItem)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.ddb.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(db.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk) OR #ttl < :now OR (responseStatus = :inProgress AND requestHash = :requestHash AND (attribute_not_exists(leaseUntil) OR leaseUntil < :now))"),
+		ExpressionAttributeNames: map[string]*string{
+			"#ttl": aws.String("ttl"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {
+				N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+			},
+			":inProgress": {
+				N: aws.String("0"),
+			},
+			":requestHash": {
+				S: aws.String(requestHash),
+			},
+		},
+	})
+	if IsConditionalCheckFailed(err) {
+		return db.getItem(userID, key)
+	}
+	return nil, err
+}
+
+// Complete stores the response of the request that claimed a key. Responses with omitted bodies are only marked
+// as completed
+func (db *IdempotencyDB) Complete(userID string, key string, status int, contentType string, body []byte, bodyOmitted bool) error {
+	values := map[string]*dynamodb.AttributeValue{
+		":status": {
+			N: aws.String(strconv.Itoa(status)),
+		},
+		":contentType": {
+			S: aws.String(contentType),
+		},
+	}
+	update := "SET responseStatus = :status, contentType = :contentType"
+	if len(body) > 0 {
+		update += ", responseBody = :body"
+		values[":body"] = &dynamodb.AttributeValue{B: body}
+	}
+	if bodyOmitted {
+		update += ", bodyOmitted = :bodyOmitted"
+		values[":bodyOmitted"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+
+	_, err := db.ddb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getIdempotencyPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getIdempotencySk(key)),
+			},
+		},
+		ConditionExpression:       aws.String("attribute_exists(pk)"),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+// Release frees a key, e.g. because its request failed in a way that a retry might fix
+func (db *IdempotencyDB) Release(userID string, key string) error {
+	_, err := db.ddb.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getIdempotencyPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getIdempotencySk(key)),
+			},
+		},
+	})
+	return err
+}
+
+func (db *IdempotencyDB) getItem(userID string, key string) (*IdempotencyItem, error) {
+	result, err := db.ddb.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(db.tableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getIdempotencyPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getIdempotencySk(key)),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, fmt.Errorf("idempotency key was released concurrently")
+	}
+
+	var item IdempotencyItem
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func getIdempotencyPk(userID string) string {
+	return fmt.Sprintf("IDEMPOTENCY#%s", userID)
+}
+
+func getIdempotencySk(key string) string {
+	return fmt.Sprintf("KEY#%s", key)
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// MemberItem grants a user access to an org that lives in the partition of its creator, the owner. Every membership
+// is stored twice: in the owner's partition to list the members of an org, and in the member's partition to resolve
+// the owner of an org the member accesses.
+type MemberItem struct {
+	Pk        string    `dynamodbav:"pk"`
+	Sk        string    `dynamodbav:"sk"`
+	UserID    string    `dynamodbav:"userID"`
+	OwnerID   string    `dynamodbav:"ownerID"`
+	OrgName   string    `dynamodbav:"orgName"`
+	Role      string    `dynamodbav:"role"`
+	InvitedBy string    `dynamodbav:"invitedBy,omitempty"`
+	InvitedAt time.Time `dynamodbav:"invitedAt"`
+}
+
+type MemberDB struct {
+	tableName string
+	ddb       *dynamodb.DynamoDB
+}
+
+func NewMemberDB(ddb *dynamodb.DynamoDB, tableName string) *MemberDB {
+	return &MemberDB{ddb: ddb, tableName: tableName}
+}
+
+// PutItem adds a member to the org of ownerID. It fails the condition if the user already is a member of an org with
+// the same name, because the org name identifies the org in the member's requests
+func (db *MemberDB) PutItem(ownerID string, orgName string, member *types.Member) (*types.Member, error) {
+	memberItem := MemberItem{
+		Pk:        getMemberPk(ownerID),
+		Sk:        getMemberSk(orgName, member.UserID),
+		UserID:    member.UserID,
+		OwnerID:   ownerID,
+		OrgName:   orgName,
+		Role:      string(member.Role),
+		InvitedBy: member.InvitedBy,
+		InvitedAt: time.Now().UTC(),
+	}
+	membershipItem := memberItem
+	membershipItem.Pk = getMemberPk(member.UserID)
+	membershipItem.Sk = getMembershipSk(orgName)
+
+	var items []*dynamodb.TransactWriteItem
+	for _, i := range []MemberItem{memberItem, membershipItem} {
+		item, err := dynamodbattribute.MarshalMap(i)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(db.tableName),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(pk) AND attribute_not_exists(sk)"),
+			},
+		})
+	}
+
+	_, err := db.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		return nil, err
+	}
+
+	return memberItem.ToMember(), nil
+}
+
+// GetMembership returns the membership of a user in an org created by someone else, or nil if there is none
+func (db *MemberDB) GetMembership(userID string, orgName string) (*MemberItem, error) {
+	input := &dynamodb.GetItemInput{
+		TableName:      aws.String(db.tableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getMemberPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getMembershipSk(orgName)),
+			},
+		},
+	}
+
+	result, err := db.ddb.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var membership MemberItem
+	err = dynamodbattribute.UnmarshalMap(result.Item, &membership)
+	if err != nil {
+		return nil, err
+	}
+
+	return &membership, nil
+}
+
+// ListItems returns the members of an org. The owner who created the org isn't part of them
+func (db *MemberDB) ListItems(ownerID string, orgName string) ([]*types.Member, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(getMemberPk(ownerID)),
+			},
+			":prefix": {
+				S: aws.String(getMemberSk(orgName, "")),
+			},
+		},
+	}
+
+	members := []*types.Member{}
+	var unmarshalErr error
+	err := db.ddb.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var member MemberItem
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(item, &member); unmarshalErr != nil {
+				return false
+			}
+			members = append(members, member.ToMember())
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	return members, nil
+}
+
+// DeleteItem removes a member from an org
+func (db *MemberDB) DeleteItem(ownerID string, orgName string, userID string) error {
+	_, err := db.ddb.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{Delete: &dynamodb.Delete{
+				TableName: aws.String(db.tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(getMemberPk(ownerID))},
+					"sk": {S: aws.String(getMemberSk(orgName, userID))},
+				},
+			}},
+			{Delete: &dynamodb.Delete{
+				TableName: aws.String(db.tableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					"pk": {S: aws.String(getMemberPk(userID))},
+					"sk": {S: aws.String(getMembershipSk(orgName))},
+				},
+			}},
+		},
+	})
+	return err
+}
+
+// DeleteAll removes all members of an org, e.g. after the org was deleted
+func (db *MemberDB) DeleteAll(ownerID string, orgName string) error {
+	members, err := db.ListItems(ownerID, orgName)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := db.DeleteItem(ownerID, orgName, member.UserID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (member *MemberItem) ToMember() *types.Member {
+	invitedAt := member.InvitedAt
+	return &types.Member{
+		UserID:    member.UserID,
+		Role:      types.MemberRole(member.Role),
+		InvitedBy: member.InvitedBy,
+		InvitedAt: &invitedAt,
+	}
+}
+
+func getMemberPk(userID string) string {
+	return fmt.Sprintf("MEMBER#%s", userID)
+}
+
+// getMemberSk is the key of a member in the owner's partition
+func getMemberSk(orgName string, userID string) string {
+	return fmt.Sprintf("ORG#%s#MEMBER#%s", orgName, userID)
+}
+
+// getMembershipSk is the key of a membership in the member's partition
+func getMembershipSk(orgName string) string {
+	return fmt.Sprintf("ORG#%s", orgName)
+}
@@ -14,6 +14,33 @@ type OrganizationItem struct {
 	OrgName                    string `dynamodbav:"sk"`
     PulumiAccessToken          string `dynamodbav:"pulumiAccessToken"`
     OrgManagementEnvironment   string `dynamodbav:"orgManagementEnvironment"`
+	PulumiOrg                  string `dynamodbav:"pulumiOrg,omitempty"`
+	BackendType                string `dynamodbav:"backendType,omitempty"`
+	BackendURL                 string `dynamodbav:"backendURL,omitempty"`
+	SecretsProvider            string `dynamodbav:"secretsProvider,omitempty"`
+	SecretsProviderKeyID       string `dynamodbav:"secretsProviderKeyID,omitempty"`
+	SecretsProviderRegion      string `dynamodbav:"secretsProviderRegion,omitempty"`
+	SecretsPassphrase          string `dynamodbav:"secretsPassphrase,omitempty"`
+	AwsAccessKey               string `dynamodbav:"awsAccessKey,omitempty"`
+	AwsSecretKey               string `dynamodbav:"awsSecretKey,omitempty"`
+	AwsSessionToken            string `dynamodbav:"awsSessionToken,omitempty"`
+	Baselines                  []BaselineItem `dynamodbav:"baselines,omitempty"`
+	SuspendedUnit              string `dynamodbav:"suspendedUnit,omitempty"`
+	ClosureGracePeriodDays     int    `dynamodbav:"closureGracePeriodDays,omitempty"`
+	EmailTemplate              string `dynamodbav:"emailTemplate,omitempty"`
+	NamingRules                *NamingRulesItem `dynamodbav:"namingRules,omitempty"`
+}
+
+type NamingRulesItem struct {
+	Pattern            string   `dynamodbav:"pattern,omitempty"`
+	RequiredPrefixes   []string `dynamodbav:"requiredPrefixes,omitempty"`
+	ReservedNames      []string `dynamodbav:"reservedNames,omitempty"`
+	MaxAccountsPerUnit int      `dynamodbav:"maxAccountsPerUnit,omitempty"`
+}
+
+type BaselineItem struct {
+	Name   string            `dynamodbav:"name"`
+	Params map[string]string `dynamodbav:"params,omitempty"`
 }
 
 type OrganizationDB struct {
@@ -32,6 +59,21 @@ func (db *OrganizationDB) PutItem(UserID string, org *types.Organization) (*type
 		OrgName: org.OrgName,
 		PulumiAccessToken: org.PulumiAccessToken,
 		OrgManagementEnvironment: org.OrgManagementEnvironment,
+		PulumiOrg: org.PulumiOrg,
+		BackendType: string(org.Backend.Type),
+		BackendURL: org.Backend.URL,
+		SecretsProvider: string(org.Backend.SecretsProvider.Type),
+		SecretsProviderKeyID: org.Backend.SecretsProvider.KeyID,
+		SecretsProviderRegion: org.Backend.SecretsProvider.Region,
+		SecretsPassphrase: org.Backend.SecretsProvider.Passphrase,
+		AwsAccessKey: org.AwsAccessKey,
+		AwsSecretKey: org.AwsSecretKey,
+		AwsSessionToken: org.AwsSessionToken,
+		Baselines: toBaselineItems(org.Baselines),
+		SuspendedUnit: org.SuspendedUnit,
+		ClosureGracePeriodDays: org.ClosureGracePeriodDays,
+		EmailTemplate: org.EmailTemplate,
+		NamingRules: toNamingRulesItem(org.NamingRules),
 	}
 
 	item, err := dynamodbattribute.MarshalMap(orgItem)
@@ -42,6 +84,7 @@ func (db *OrganizationDB) PutItem(UserID string, org *types.Organization) (*type
     input := &dynamodb.PutItemInput{
         TableName: aws.String(db.tableName),
         Item: item,
+        ConditionExpression: aws.String("attribute_not_exists(pk) AND attribute_not_exists(sk)"),
     }
 
     _, err = db.ddb.PutItem(input)
@@ -85,9 +128,99 @@ func (db *OrganizationDB) GetItem(userID string, orgName string, consistentRead
 		OrgName: org.OrgName,
 		PulumiAccessToken: org.PulumiAccessToken,
 		OrgManagementEnvironment: org.OrgManagementEnvironment,
+		PulumiOrg: org.PulumiOrg,
+		Backend: types.Backend{
+			Type: types.BackendType(org.BackendType),
+			URL: org.BackendURL,
+			SecretsProvider: types.SecretsProvider{
+				Type: types.SecretsProviderType(org.SecretsProvider),
+				KeyID: org.SecretsProviderKeyID,
+				Region: org.SecretsProviderRegion,
+				Passphrase: org.SecretsPassphrase,
+			},
+		},
+		AwsAccessKey: org.AwsAccessKey,
+		AwsSecretKey: org.AwsSecretKey,
+		AwsSessionToken: org.AwsSessionToken,
+		Baselines: toBaselineConfigs(org.Baselines),
+		SuspendedUnit: org.SuspendedUnit,
+		ClosureGracePeriodDays: org.ClosureGracePeriodDays,
+		EmailTemplate: org.EmailTemplate,
+		NamingRules: toNamingRules(org.NamingRules),
 	}, nil
 }
 
+// UpdateBaselines replaces the baselines of an org
+func (db *OrganizationDB) UpdateBaselines(userID string, orgName string, baselines []types.BaselineConfig) error {
+	items, err := dynamodbattribute.Marshal(toBaselineItems(baselines))
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getOrgPk(userID)),
+			},
+			"sk": {
+				S: aws.String(orgName),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		UpdateExpression:    aws.String("SET baselines = :baselines"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":baselines": items,
+		},
+	}
+
+	_, err = db.ddb.UpdateItem(input)
+	return err
+}
+
+func toBaselineItems(baselines []types.BaselineConfig) []BaselineItem {
+	items := []BaselineItem{}
+	for _, b := range baselines {
+		items = append(items, BaselineItem{Name: b.Name, Params: b.Params})
+	}
+	return items
+}
+
+func toBaselineConfigs(items []BaselineItem) []types.BaselineConfig {
+	if len(items) == 0 {
+		return nil
+	}
+	baselines := make([]types.BaselineConfig, len(items))
+	for i, item := range items {
+		baselines[i] = types.BaselineConfig{Name: item.Name, Params: item.Params}
+	}
+	return baselines
+}
+
+func toNamingRulesItem(rules *types.NamingRules) *NamingRulesItem {
+	if rules == nil {
+		return nil
+	}
+	return &NamingRulesItem{
+		Pattern:            rules.Pattern,
+		RequiredPrefixes:   rules.RequiredPrefixes,
+		ReservedNames:      rules.ReservedNames,
+		MaxAccountsPerUnit: rules.MaxAccountsPerUnit,
+	}
+}
+
+func toNamingRules(item *NamingRulesItem) *types.NamingRules {
+	if item == nil {
+		return nil
+	}
+	return &types.NamingRules{
+		Pattern:            item.Pattern,
+		RequiredPrefixes:   item.RequiredPrefixes,
+		ReservedNames:      item.ReservedNames,
+		MaxAccountsPerUnit: item.MaxAccountsPerUnit,
+	}
+}
+
 // Delete item
 func (db *OrganizationDB) DeleteItem(userID string, orgName string) error {
 	input := &dynamodb.DeleteItemInput{
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+type PolicyItem struct {
+	Pk          string   `dynamodbav:"pk"`
+	Sk          string   `dynamodbav:"sk"`
+	Name        string   `dynamodbav:"policyName"`
+	Description string   `dynamodbav:"description,omitempty"`
+	Document    string   `dynamodbav:"document"`
+	Units       []string `dynamodbav:"units,omitempty"`
+	Accounts    []string `dynamodbav:"accounts,omitempty"`
+	PolicyID    string   `dynamodbav:"policyID,omitempty"`
+	// Version is bumped on every change of the desired state, AppliedVersion is the version the org stack applied last
+	Version        int `dynamodbav:"policyVersion"`
+	AppliedVersion int `dynamodbav:"appliedVersion"`
+}
+
+type PolicyDB struct {
+	tableName string
+	ddb       *dynamodb.DynamoDB
+}
+
+func NewPolicyDB(ddb *dynamodb.DynamoDB, tableName string) *PolicyDB {
+	return &PolicyDB{ddb: ddb, tableName: tableName}
+}
+
+// PutItem creates a new policy. Policies start with version 1 so that they are applied by the org stack
+func (db *PolicyDB) PutItem(userID string, orgName string, policy *types.ServiceControlPolicy) (*types.ServiceControlPolicy, error) {
+	policyItem := PolicyItem{
+		Pk:             getPolicyPk(userID),
+		Sk:             getPolicySk(orgName, policy.Name),
+		Name:           policy.Name,
+		Description:    policy.Description,
+		Document:       policy.Document,
+		Units:          policy.Units,
+		Accounts:       policy.Accounts,
+		Version:        1,
+		AppliedVersion: 0,
+	}
+
+	item, err := dynamodbattribute.MarshalMap(policyItem)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String(db.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk) AND attribute_not_exists(sk)"),
+	}
+
+	_, err = db.ddb.PutItem(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return policyItem.ToPolicy(), nil
+}
+
+func (db *PolicyDB) GetItem(userID string, orgName string, policyName string, consistentRead bool) (*types.ServiceControlPolicy, error) {
+	input := &dynamodb.GetItemInput{
+		TableName:      aws.String(db.tableName),
+		ConsistentRead: aws.Bool(consistentRead),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getPolicyPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getPolicySk(orgName, policyName)),
+			},
+		},
+	}
+
+	result, err := db.ddb.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var policy PolicyItem
+	err = dynamodbattribute.UnmarshalMap(result.Item, &policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy.ToPolicy(), nil
+}
+
+// ListItems returns the policies of an org
+func (db *PolicyDB) ListItems(userID string, orgName string) ([]*types.ServiceControlPolicy, error) {
+	items, err := db.ListVersionedItems(userID, orgName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := []*types.ServiceControlPolicy{}
+	for _, item := range items {
+		policies = append(policies, item.ToPolicy())
+	}
+	return policies, nil
+}
+
+// ListVersionedItems returns the policies of an org together with their versions
+func (db *PolicyDB) ListVersionedItems(userID string, orgName string, consistentRead bool) ([]*PolicyItem, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		ConsistentRead:         aws.Bool(consistentRead),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(getPolicyPk(userID)),
+			},
+			":prefix": {
+				S: aws.String(getPolicySk(orgName, "")),
+			},
+		},
+	}
+
+	var policies []*PolicyItem
+	var unmarshalErr error
+	err := db.ddb.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var policy PolicyItem
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(item, &policy); unmarshalErr != nil {
+				return false
+			}
+			policies = append(policies, &policy)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	return policies, nil
+}
+
+// UpdateItem replaces the document and the targets of a policy and bumps its version, which makes the stream
+// processor apply the org stack
+func (db *PolicyDB) UpdateItem(userID string, orgName string, policy *types.ServiceControlPolicy) error {
+	units, err := dynamodbattribute.Marshal(policy.Units)
+	if err != nil {
+		return err
+	}
+	accounts, err := dynamodbattribute.Marshal(policy.Accounts)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getPolicyPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getPolicySk(orgName, policy.Name)),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		UpdateExpression:    aws.String("SET policyVersion = policyVersion + :increment, description = :description, document = :document, units = :units, accounts = :accounts"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":increment": {
+				N: aws.String("1"),
+			},
+			":description": {
+				S: aws.String(policy.Description),
+			},
+			":document": {
+				S: aws.String(policy.Document),
+			},
+			":units":    units,
+			":accounts": accounts,
+		},
+	}
+
+	_, err = db.ddb.UpdateItem(input)
+	return err
+}
+
+// MarkApplied records that the org stack applied the given version of a policy and created it with policyID
+func (db *PolicyDB) MarkApplied(userID string, orgName string, policyName string, version int, policyID string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getPolicyPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getPolicySk(orgName, policyName)),
+			},
+		},
+		ConditionExpression: aws.String("policyVersion = :version"),
+		UpdateExpression:    aws.String("SET appliedVersion = :version, policyID = :policyID"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":version": {
+				N: aws.String(fmt.Sprintf("%d", version)),
+			},
+			":policyID": {
+				S: aws.String(policyID),
+			},
+		},
+	}
+
+	_, err := db.ddb.UpdateItem(input)
+	return err
+}
+
+func (db *PolicyDB) DeleteItem(userID string, orgName string, policyName string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getPolicyPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getPolicySk(orgName, policyName)),
+			},
+		},
+	}
+
+	_, err := db.ddb.DeleteItem(input)
+	return err
+}
+
+func (policy *PolicyItem) ToPolicy() *types.ServiceControlPolicy {
+	return &types.ServiceControlPolicy{
+		Name:        policy.Name,
+		Description: policy.Description,
+		Document:    policy.Document,
+		Units:       policy.Units,
+		Accounts:    policy.Accounts,
+		PolicyID:    policy.PolicyID,
+		Applied:     policy.AppliedVersion >= policy.Version,
+	}
+}
+
+func getPolicyPk(userID string) string {
+	return fmt.Sprintf("SCP#%s", userID)
+}
+
+func getPolicySk(orgName string, policyName string) string {
+	return fmt.Sprintf("ORG#%s#SCP#%s", orgName, policyName)
+}
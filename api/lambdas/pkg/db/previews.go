@@ -0,0 +1,196 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// PreviewItem is a preview of an account's stack. The API stores it as pending, the stream processor runs the
+// preview and stores its result. DynamoDB removes expired items through the table's TTL on "ttl"
+type PreviewItem struct {
+	Pk          string `dynamodbav:"pk"`
+	Sk          string `dynamodbav:"sk"`
+	PreviewID   string `dynamodbav:"previewID"`
+	AccountName string `dynamodbav:"accountName"`
+	Status      string `dynamodbav:"previewStatus"`
+	// RequestedBy is the principal that requested the preview
+	RequestedBy string     `dynamodbav:"requestedBy"`
+	RequestedAt time.Time  `dynamodbav:"requestedAt"`
+	StartedAt   *time.Time `dynamodbav:"startedAt,omitempty"`
+	FinishedAt  *time.Time `dynamodbav:"finishedAt,omitempty"`
+	// LeaseUntil is the unix time in seconds until which the run that started the preview owns it
+	LeaseUntil int64 `dynamodbav:"leaseUntil,omitempty"`
+	// Diff is the JSON encoded types.PreviewDiff of a successful preview
+	Diff  string `dynamodbav:"diff,omitempty"`
+	Error string `dynamodbav:"previewError,omitempty"`
+	TTL   int64  `dynamodbav:"ttl"`
+}
+
+type PreviewDB struct {
+	tableName string
+	ddb       *dynamodb.DynamoDB
+}
+
+func NewPreviewDB(ddb *dynamodb.DynamoDB, tableName string) *PreviewDB {
+	return &PreviewDB{ddb: ddb, tableName: tableName}
+}
+
+// PutItem stores a pending preview that is kept until ttl passed
+func (db *PreviewDB) PutItem(userID string, orgName string, accountName string, previewID string, requestedBy string, now time.Time, ttl time.Duration) (*types.OperationResult, error) {
+	previewItem := PreviewItem{
+		Pk:          getPreviewPk(userID),
+		Sk:          getPreviewSk(orgName, accountName, previewID),
+		PreviewID:   previewID,
+		AccountName: accountName,
+		Status:      string(types.OperationPending),
+		RequestedBy: requestedBy,
+		RequestedAt: now,
+		TTL:         now.Add(ttl).Unix(),
+	}
+
+	item, err := dynamodbattribute.MarshalMap(previewItem)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.ddb.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(db.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk)"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previewItem.ToResult()
+}
+
+func (db *PreviewDB) GetItem(userID string, orgName string, accountName string, previewID string) (*types.OperationResult, error) {
+	result, err := db.ddb.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(db.tableName),
+		ConsistentRead: aws.Bool(true),
+		Key:            previewKey(userID, orgName, accountName, previewID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var item PreviewItem
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &item); err != nil {
+		return nil, err
+	}
+	return item.ToResult()
+}
+
+// Start claims a pending preview for a run until lease passed. Runs that didn't finish within their lease, e.g.
+// because the worker timed out, are given up and the preview can be claimed again. It fails with a failed
+// condition if another run owns the preview or it's finished
+func (db *PreviewDB) Start(userID string, orgName string, accountName string, previewID string, now time.Time, lease time.Duration) error {
+	startedAt, err := dynamodbattribute.Marshal(now)
+	if err != nil {
+		return err
+	}
+	_, err = db.ddb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.tableName),
+		Key:                 previewKey(userID, orgName, accountName, previewID),
+		ConditionExpression: aws.String("previewStatus = :pending AND (attribute_not_exists(leaseUntil) OR leaseUntil < :now)"),
+		UpdateExpression:    aws.String("SET startedAt = :startedAt, leaseUntil = :leaseUntil"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pending": {
+				S: aws.String(string(types.OperationPending)),
+			},
+			":now": {
+				N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+			},
+			":leaseUntil": {
+				N: aws.String(strconv.FormatInt(now.Add(lease).Unix(), 10)),
+			},
+			":startedAt": startedAt,
+		},
+	})
+	return err
+}
+
+// Finish stores the result of a preview. diff is only stored if runErr is nil
+func (db *PreviewDB) Finish(userID string, orgName string, accountName string, previewID string, diff *types.PreviewDiff, runErr error, now time.Time) error {
+	finishedAt, err := dynamodbattribute.Marshal(now)
+	if err != nil {
+		return err
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":finishedAt": finishedAt,
+		":status": {
+			S: aws.String(string(types.OperationSucceeded)),
+		},
+	}
+	update := "SET finishedAt = :finishedAt, previewStatus = :status"
+	if runErr != nil {
+		values[":status"].S = aws.String(string(types.OperationFailed))
+		values[":error"] = &dynamodb.AttributeValue{S: aws.String(runErr.Error())}
+		update += ", previewError = :error"
+	} else {
+		encoded, err := json.Marshal(diff)
+		if err != nil {
+			return err
+		}
+		values[":diff"] = &dynamodb.AttributeValue{S: aws.String(string(encoded))}
+		update += ", diff = :diff"
+	}
+	// the run is done, so nobody owns the preview anymore
+	update += " REMOVE leaseUntil"
+
+	_, err = db.ddb.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(db.tableName),
+		Key:                       previewKey(userID, orgName, accountName, previewID),
+		ConditionExpression:       aws.String("attribute_exists(pk)"),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+func (item *PreviewItem) ToResult() (*types.OperationResult, error) {
+	result := &types.OperationResult{
+		ID:          item.PreviewID,
+		Operation:   "preview",
+		Status:      types.OperationStatus(item.Status),
+		RequestedAt: item.RequestedAt,
+		StartedAt:   item.StartedAt,
+		FinishedAt:  item.FinishedAt,
+		Error:       item.Error,
+	}
+	if item.Diff != "" {
+		result.Diff = &types.PreviewDiff{}
+		if err := json.Unmarshal([]byte(item.Diff), result.Diff); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func previewKey(userID string, orgName string, accountName string, previewID string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"pk": {
+			S: aws.String(getPreviewPk(userID)),
+		},
+		"sk": {
+			S: aws.String(getPreviewSk(orgName, accountName, previewID)),
+		},
+	}
+}
+
+func getPreviewPk(userID string) string {
+	return fmt.Sprintf("PREVIEW#%s", userID)
+}
+
+func getPreviewSk(orgName string, accountName string, previewID string) string {
+	return fmt.Sprintf("ORG#%s#ACC#%s#PREVIEW#%s", orgName, accountName, previewID)
+}
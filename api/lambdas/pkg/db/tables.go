@@ -0,0 +1,54 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Tables bundles the accessors for all entities that live in the festus table
+type Tables struct {
+	Organizations *OrganizationDB
+	Accounts      *AccountDB
+	Deployments   *DeploymentDB
+	Units         *UnitDB
+	Policies      *PolicyDB
+	Members       *MemberDB
+	APIKeys       *APIKeyDB
+	Audit         *AuditDB
+	Idempotency   *IdempotencyDB
+	Previews      *PreviewDB
+}
+
+func NewTables(ddb *dynamodb.DynamoDB, tableName string) *Tables {
+	return &Tables{
+		Organizations: NewOrganizationDB(ddb, tableName),
+		Accounts:      NewAccountDB(ddb, tableName),
+		Deployments:   NewDeploymentDB(ddb, tableName),
+		Units:         NewUnitDB(ddb, tableName),
+		Policies:      NewPolicyDB(ddb, tableName),
+		Members:       NewMemberDB(ddb, tableName),
+		APIKeys:       NewAPIKeyDB(ddb, tableName),
+		Audit:         NewAuditDB(ddb, tableName),
+		Idempotency:   NewIdempotencyDB(ddb, tableName),
+		Previews:      NewPreviewDB(ddb, tableName),
+	}
+}
+
+// IsConditionalCheckFailed reports whether a write was rejected because its condition didn't hold,
+// e.g. because the item was modified concurrently. Transactions count if one of their conditions didn't hold
+func IsConditionalCheckFailed(err error) bool {
+	var canceled *dynamodb.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			if reason.Code != nil && *reason.Code == "ConditionalCheckFailed" {
+				return true
+			}
+		}
+		return false
+	}
+
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
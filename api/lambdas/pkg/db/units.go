@@ -0,0 +1,261 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+type UnitItem struct {
+	Pk     string            `dynamodbav:"pk"`
+	Sk     string            `dynamodbav:"sk"`
+	Name   string            `dynamodbav:"unitName"`
+	Parent string            `dynamodbav:"parent,omitempty"`
+	Tags   map[string]string `dynamodbav:"tags,omitempty"`
+	UnitID string            `dynamodbav:"unitID,omitempty"`
+	// Version is bumped on every change of the desired state, AppliedVersion is the version the org stack applied last
+	Version        int `dynamodbav:"unitVersion"`
+	AppliedVersion int `dynamodbav:"appliedVersion"`
+}
+
+type UnitDB struct {
+	tableName string
+	ddb       *dynamodb.DynamoDB
+}
+
+func NewUnitDB(ddb *dynamodb.DynamoDB, tableName string) *UnitDB {
+	return &UnitDB{ddb: ddb, tableName: tableName}
+}
+
+// PutItem creates a new unit. Units start with version 1 so that they are applied by the org stack
+func (db *UnitDB) PutItem(userID string, orgName string, unit *types.OrganizationalUnit) (*types.OrganizationalUnit, error) {
+	unitItem := UnitItem{
+		Pk:             getUnitPk(userID),
+		Sk:             getUnitSk(orgName, unit.Name),
+		Name:           unit.Name,
+		Parent:         unit.Parent,
+		Tags:           unit.Tags,
+		Version:        1,
+		AppliedVersion: 0,
+	}
+
+	item, err := dynamodbattribute.MarshalMap(unitItem)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName:           aws.String(db.tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(pk) AND attribute_not_exists(sk)"),
+	}
+
+	_, err = db.ddb.PutItem(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return unitItem.ToUnit(), nil
+}
+
+func (db *UnitDB) GetItem(userID string, orgName string, unitName string, consistentRead bool) (*types.OrganizationalUnit, error) {
+	input := &dynamodb.GetItemInput{
+		TableName:      aws.String(db.tableName),
+		ConsistentRead: aws.Bool(consistentRead),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getUnitPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getUnitSk(orgName, unitName)),
+			},
+		},
+	}
+
+	result, err := db.ddb.GetItem(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var unit UnitItem
+	err = dynamodbattribute.UnmarshalMap(result.Item, &unit)
+	if err != nil {
+		return nil, err
+	}
+
+	return unit.ToUnit(), nil
+}
+
+// ListItems returns the units of an org
+func (db *UnitDB) ListItems(userID string, orgName string) ([]*types.OrganizationalUnit, error) {
+	items, err := db.ListVersionedItems(userID, orgName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	units := []*types.OrganizationalUnit{}
+	for _, item := range items {
+		units = append(units, item.ToUnit())
+	}
+	return units, nil
+}
+
+// ListVersionedItems returns the units of an org together with their versions
+func (db *UnitDB) ListVersionedItems(userID string, orgName string, consistentRead bool) ([]*UnitItem, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(db.tableName),
+		ConsistentRead:         aws.Bool(consistentRead),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {
+				S: aws.String(getUnitPk(userID)),
+			},
+			":prefix": {
+				S: aws.String(getUnitSk(orgName, "")),
+			},
+		},
+	}
+
+	var units []*UnitItem
+	var unmarshalErr error
+	err := db.ddb.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var unit UnitItem
+			if unmarshalErr = dynamodbattribute.UnmarshalMap(item, &unit); unmarshalErr != nil {
+				return false
+			}
+			units = append(units, &unit)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	return units, nil
+}
+
+// UpdateTags changes the tags of a unit and bumps its version, which makes the stream processor apply the org stack
+func (db *UnitDB) UpdateTags(userID string, orgName string, unitName string, tags map[string]string) error {
+	tagsValue, err := dynamodbattribute.Marshal(tags)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getUnitPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getUnitSk(orgName, unitName)),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(pk)"),
+		UpdateExpression:    aws.String("SET unitVersion = unitVersion + :increment, tags = :tags"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":increment": {
+				N: aws.String("1"),
+			},
+			":tags": tagsValue,
+		},
+	}
+
+	_, err = db.ddb.UpdateItem(input)
+	return err
+}
+
+// MarkApplied records that the org stack applied the given version of a unit and created it with unitID
+func (db *UnitDB) MarkApplied(userID string, orgName string, unitName string, version int, unitID string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getUnitPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getUnitSk(orgName, unitName)),
+			},
+		},
+		ConditionExpression: aws.String("unitVersion = :version"),
+		UpdateExpression:    aws.String("SET appliedVersion = :version, unitID = :unitID"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":version": {
+				N: aws.String(fmt.Sprintf("%d", version)),
+			},
+			":unitID": {
+				S: aws.String(unitID),
+			},
+		},
+	}
+
+	_, err := db.ddb.UpdateItem(input)
+	return err
+}
+
+func (db *UnitDB) DeleteItem(userID string, orgName string, unitName string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {
+				S: aws.String(getUnitPk(userID)),
+			},
+			"sk": {
+				S: aws.String(getUnitSk(orgName, unitName)),
+			},
+		},
+	}
+
+	_, err := db.ddb.DeleteItem(input)
+	return err
+}
+
+// ResolveParent sets the ParentID of an account that references a unit by name to the ID of that unit
+func (db *UnitDB) ResolveParent(userID string, orgName string, account *types.Account) error {
+	if account.Unit == "" {
+		return nil
+	}
+
+	unit, err := db.GetItem(userID, orgName, account.Unit, true)
+	if err != nil {
+		return err
+	}
+	if unit == nil {
+		return fmt.Errorf("organizational unit '%s' does not exist", account.Unit)
+	}
+	if unit.UnitID == "" {
+		return fmt.Errorf("organizational unit '%s' was not created yet", account.Unit)
+	}
+
+	account.ParentID = unit.UnitID
+	return nil
+}
+
+func (unit *UnitItem) ToUnit() *types.OrganizationalUnit {
+	return &types.OrganizationalUnit{
+		Name:    unit.Name,
+		Parent:  unit.Parent,
+		Tags:    unit.Tags,
+		UnitID:  unit.UnitID,
+		Applied: unit.AppliedVersion >= unit.Version,
+	}
+}
+
+func getUnitPk(userID string) string {
+	return fmt.Sprintf("OU#%s", userID)
+}
+
+func getUnitSk(orgName string, unitName string) string {
+	return fmt.Sprintf("ORG#%s#OU#%s", orgName, unitName)
+}
@@ -0,0 +1,104 @@
+package drift
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// Checker compares the recorded state of an account's stack with the actual cloud resources
+type Checker func(ctx context.Context, account *types.Account, org *types.Organization) (*types.PreviewDiff, error)
+
+type Detector struct {
+	accountsDb  *db.AccountDB
+	orgDb       *db.OrganizationDB
+	unitsDb     *db.UnitDB
+	check       Checker
+	concurrency int
+}
+
+func NewDetector(tables *db.Tables, check Checker, concurrency int) *Detector {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Detector{
+		accountsDb:  tables.Accounts,
+		orgDb:       tables.Organizations,
+		unitsDb:     tables.Units,
+		check:       check,
+		concurrency: concurrency,
+	}
+}
+
+// Run checks all created accounts for drift, at most concurrency at a time, and records the result on each account.
+// A failing check is recorded on the account and doesn't stop the run.
+func (d *Detector) Run(ctx context.Context) error {
+	sem := make(chan struct{}, d.concurrency)
+	var wg sync.WaitGroup
+
+	err := d.accountsDb.ScanByStatus(types.Created, func(userID string, orgName string, account *types.Account) bool {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			d.checkAccount(ctx, userID, orgName, account)
+		}()
+		return true
+	})
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (d *Detector) checkAccount(ctx context.Context, userID string, orgName string, account *types.Account) {
+	org, err := d.orgDb.GetItem(userID, orgName, false)
+	if err != nil {
+		fmt.Printf("failed to retrieve org '%s': %s\n", orgName, err.Error())
+		return
+	}
+	if org == nil {
+		fmt.Printf("org '%s' of account '%s' does not exist\n", orgName, account.AccountName)
+		return
+	}
+
+	status, summary := types.InSync, ""
+	var diff *types.PreviewDiff
+	err = d.unitsDb.ResolveParent(userID, orgName, account)
+	if err == nil {
+		diff, err = d.check(ctx, account, org)
+	}
+	if err != nil {
+		status, summary = types.DriftCheckFailed, err.Error()
+	} else if len(diff.Resources) > 0 {
+		status, summary = types.Drifted, summarize(diff)
+	}
+
+	fmt.Printf("Account '%s' in org '%s' is %s\n", account.AccountName, orgName, status)
+	err = d.accountsDb.UpdateDrift(userID, orgName, account.AccountName, status, summary, time.Now().UTC())
+	if err != nil {
+		fmt.Printf("failed to record drift of account '%s': %s\n", account.AccountName, err.Error())
+	}
+}
+
+// summarize lists the drifted resources and what happened to them, e.g. "aws:s3/bucket:Bucket::logs update"
+func summarize(diff *types.PreviewDiff) string {
+	var parts []string
+	for _, r := range diff.Resources {
+		name := r.URN[strings.LastIndex(r.URN, "::")+2:]
+		parts = append(parts, fmt.Sprintf("%s::%s %s", r.Type, name, r.Op))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,106 @@
+// Package esc manages Pulumi ESC environments through the Pulumi Cloud API
+package esc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultAPIURL is the API of Pulumi Cloud
+const DefaultAPIURL = "https://api.pulumi.com"
+
+// Environments creates and updates ESC environments
+type Environments interface {
+	// UpsertEnvironment creates the environment if it doesn't exist yet and replaces its definition
+	UpsertEnvironment(ctx context.Context, org string, name string, definition []byte) error
+}
+
+// CloudClient implements Environments with the Pulumi Cloud API
+type CloudClient struct {
+	apiURL string
+	token  string
+	client *http.Client
+}
+
+func NewCloudClient(apiURL string, token string) *CloudClient {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	return &CloudClient{apiURL: strings.TrimSuffix(apiURL, "/"), token: token, client: http.DefaultClient}
+}
+
+func (c *CloudClient) UpsertEnvironment(ctx context.Context, org string, name string, definition []byte) error {
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return err
+	}
+
+	// creating an environment that already exists is a conflict, its definition is replaced below either way
+	status, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/preview/environments/%s", org), "application/json", body)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusCreated && status != http.StatusConflict {
+		return fmt.Errorf("failed to create environment '%s/%s': status %d", org, name, status)
+	}
+
+	status, err = c.do(ctx, http.MethodPatch, fmt.Sprintf("/api/preview/environments/%s/%s", org, name), "application/x-yaml", definition)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("failed to update environment '%s/%s': status %d", org, name, status)
+	}
+	return nil
+}
+
+func (c *CloudClient) do(ctx context.Context, method string, path string, contentType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "token "+c.token)
+	req.Header.Set("Accept", "application/vnd.pulumi+8")
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// AWSLoginDefinition is the definition of an environment that vends short-lived AWS credentials by assuming roleArn
+// with the Pulumi OIDC token of the environment. The credentials are exposed as the usual AWS environment variables.
+func AWSLoginDefinition(roleArn string, sessionName string) ([]byte, error) {
+	definition := map[string]interface{}{
+		"values": map[string]interface{}{
+			"aws": map[string]interface{}{
+				"login": map[string]interface{}{
+					"fn::open::aws-login": map[string]interface{}{
+						"oidc": map[string]interface{}{
+							"duration":    "1h",
+							"roleArn":     roleArn,
+							"sessionName": sessionName,
+						},
+					},
+				},
+			},
+			"environmentVariables": map[string]interface{}{
+				"AWS_ACCESS_KEY_ID":     "${aws.login.accessKeyId}",
+				"AWS_SECRET_ACCESS_KEY": "${aws.login.secretAccessKey}",
+				"AWS_SESSION_TOKEN":     "${aws.login.sessionToken}",
+			},
+		},
+	}
+	return yaml.Marshal(definition)
+}
@@ -0,0 +1,61 @@
+package feed
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Bus is an in-process feed. The local server publishes every batch of table changes it processes to it
+type Bus struct {
+	mu          sync.Mutex
+	subscribers map[AccountKey]map[chan Change]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[AccountKey]map[chan Change]struct{}{}}
+}
+
+func (b *Bus) Subscribe(ctx context.Context, key AccountKey) (<-chan Change, error) {
+	ch := make(chan Change, 64)
+
+	b.mu.Lock()
+	if b.subscribers[key] == nil {
+		b.subscribers[key] = map[chan Change]struct{}{}
+	}
+	b.subscribers[key][ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[key], ch)
+		if len(b.subscribers[key]) == 0 {
+			delete(b.subscribers, key)
+		}
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// Publish delivers the changes in records to the subscribers of the accounts they belong to.
+// Slow subscribers miss changes rather than blocking the bus; they catch up from the table when they reconnect
+func (b *Bus) Publish(records []events.DynamoDBEventRecord) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, record := range records {
+		change, ok := FromRecord(record)
+		if !ok {
+			continue
+		}
+		for ch := range b.subscribers[change.Key] {
+			select {
+			case ch <- change:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package feed
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/stream"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// AccountKey identifies the account a change belongs to
+type AccountKey struct {
+	UserID      string
+	OrgName     string
+	AccountName string
+}
+
+// Change is either a new status of an account or a new event in one of its deployment logs
+type Change struct {
+	Key AccountKey
+
+	// Account and Version are set for status changes
+	Account *types.Account
+	Version int
+
+	// DeploymentID and Event are set for deployment log events
+	DeploymentID string
+	Event        *types.DeploymentEvent
+}
+
+// Feed delivers changes of an account as they happen
+type Feed interface {
+	// Subscribe returns a channel with all changes of the account from now on. The channel is closed once ctx is done
+	Subscribe(ctx context.Context, key AccountKey) (<-chan Change, error)
+}
+
+// FromRecord converts a table stream record into a change. Records of other entities are ignored
+func FromRecord(record events.DynamoDBEventRecord) (Change, bool) {
+	if record.EventName != "INSERT" && record.EventName != "MODIFY" {
+		return Change{}, false
+	}
+
+	image := *stream.AttributeValueMapFrom(record.Change.NewImage)
+	if image["pk"] == nil || image["pk"].S == nil || image["sk"] == nil || image["sk"].S == nil {
+		return Change{}, false
+	}
+	pk := strings.Split(*image["pk"].S, "#")
+	// the SK has the form of "ORG#:orgName#ACC#:accountName[#...]"
+	sk := strings.Split(*image["sk"].S, "#")
+	if len(pk) != 2 || len(sk) < 4 {
+		return Change{}, false
+	}
+	key := AccountKey{UserID: pk[1], OrgName: sk[1], AccountName: sk[3]}
+
+	switch pk[0] {
+	case "ACC":
+		var item db.AccountItem
+		if err := dynamodbattribute.UnmarshalMap(image, &item); err != nil {
+			return Change{}, false
+		}
+		return Change{Key: key, Account: item.ToAccount(), Version: item.Version}, true
+	case "DEPLOY":
+		var item db.DeploymentEventItem
+		if err := dynamodbattribute.UnmarshalMap(image, &item); err != nil {
+			return Change{}, false
+		}
+		event := item.ToEvent()
+		return Change{Key: key, DeploymentID: item.DeploymentID, Event: &event}, true
+	default:
+		return Change{}, false
+	}
+}
@@ -0,0 +1,160 @@
+package feed
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// StreamReader reads the records of all shards of a DynamoDB stream as lambda stream events. The local server and
+// the integration tests process the table stream with it, deployments use an event source mapping instead. The API
+// reads the changes it streams to watching clients with it
+type StreamReader struct {
+	streams      *dynamodbstreams.DynamoDBStreams
+	streamArn    string
+	iteratorType string
+
+	opened         bool
+	shardIterators map[string]*string
+}
+
+// NewStreamReader creates a reader that starts the shards that exist when it is opened at iteratorType
+// (TRIM_HORIZON or LATEST). Shards that are created afterwards are read from the beginning.
+func NewStreamReader(streams *dynamodbstreams.DynamoDBStreams, streamArn string, iteratorType string) *StreamReader {
+	return &StreamReader{
+		streams:        streams,
+		streamArn:      streamArn,
+		iteratorType:   iteratorType,
+		shardIterators: map[string]*string{},
+	}
+}
+
+// Open positions the iterators of all current shards. Read opens the reader if that didn't happen yet
+func (r *StreamReader) Open() error {
+	if err := r.discoverShards(r.iteratorType); err != nil {
+		return err
+	}
+	r.opened = true
+	return nil
+}
+
+// Read returns the records that were added to the stream since the last read. If reading a shard fails, the records
+// of the shards read before are returned with the error
+func (r *StreamReader) Read() ([]events.DynamoDBEventRecord, error) {
+	if !r.opened {
+		if err := r.Open(); err != nil {
+			return nil, err
+		}
+	} else if err := r.discoverShards(dynamodbstreams.ShardIteratorTypeTrimHorizon); err != nil {
+		return nil, err
+	}
+
+	var records []events.DynamoDBEventRecord
+	for shardID, iterator := range r.shardIterators {
+		// closed shards don't have a next iterator
+		if iterator == nil {
+			continue
+		}
+
+		res, err := r.streams.GetRecords(&dynamodbstreams.GetRecordsInput{ShardIterator: iterator})
+		if err != nil {
+			return records, err
+		}
+		r.shardIterators[shardID] = res.NextShardIterator
+
+		for _, record := range res.Records {
+			records = append(records, events.DynamoDBEventRecord{
+				AWSRegion:      aws.StringValue(record.AwsRegion),
+				EventID:        aws.StringValue(record.EventID),
+				EventName:      aws.StringValue(record.EventName),
+				EventSource:    aws.StringValue(record.EventSource),
+				EventVersion:   aws.StringValue(record.EventVersion),
+				EventSourceArn: r.streamArn,
+				Change: events.DynamoDBStreamRecord{
+					Keys:           eventAttributeMapFrom(record.Dynamodb.Keys),
+					NewImage:       eventAttributeMapFrom(record.Dynamodb.NewImage),
+					OldImage:       eventAttributeMapFrom(record.Dynamodb.OldImage),
+					SequenceNumber: aws.StringValue(record.Dynamodb.SequenceNumber),
+					StreamViewType: aws.StringValue(record.Dynamodb.StreamViewType),
+				},
+			})
+		}
+	}
+	return records, nil
+}
+
+func (r *StreamReader) discoverShards(iteratorType string) error {
+	var shards []*dynamodbstreams.Shard
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(r.streamArn)}
+	for {
+		desc, err := r.streams.DescribeStream(input)
+		if err != nil {
+			return err
+		}
+		shards = append(shards, desc.StreamDescription.Shards...)
+		// descriptions are paginated by the last shard they contain
+		if desc.StreamDescription.LastEvaluatedShardId == nil {
+			break
+		}
+		input.ExclusiveStartShardId = desc.StreamDescription.LastEvaluatedShardId
+	}
+
+	for _, shard := range shards {
+		if _, ok := r.shardIterators[*shard.ShardId]; ok {
+			continue
+		}
+
+		res, err := r.streams.GetShardIterator(&dynamodbstreams.GetShardIteratorInput{
+			StreamArn:         aws.String(r.streamArn),
+			ShardId:           shard.ShardId,
+			ShardIteratorType: aws.String(iteratorType),
+		})
+		if err != nil {
+			return err
+		}
+		r.shardIterators[*shard.ShardId] = res.ShardIterator
+	}
+	return nil
+}
+
+func eventAttributeMapFrom(m map[string]*dynamodb.AttributeValue) map[string]events.DynamoDBAttributeValue {
+	if m == nil {
+		return nil
+	}
+	result := map[string]events.DynamoDBAttributeValue{}
+	for k, v := range m {
+		result[k] = eventAttributeFrom(v)
+	}
+	return result
+}
+
+// eventAttributeFrom converts from dynamodb.AttributeValue to events.DynamoDBAttributeValue
+func eventAttributeFrom(from *dynamodb.AttributeValue) events.DynamoDBAttributeValue {
+	switch {
+	case from.B != nil:
+		return events.NewBinaryAttribute(from.B)
+	case from.BS != nil:
+		return events.NewBinarySetAttribute(from.BS)
+	case from.BOOL != nil:
+		return events.NewBooleanAttribute(*from.BOOL)
+	case from.L != nil:
+		var vs []events.DynamoDBAttributeValue
+		for _, v := range from.L {
+			vs = append(vs, eventAttributeFrom(v))
+		}
+		return events.NewListAttribute(vs)
+	case from.M != nil:
+		return events.NewMapAttribute(eventAttributeMapFrom(from.M))
+	case from.N != nil:
+		return events.NewNumberAttribute(*from.N)
+	case from.NS != nil:
+		return events.NewNumberSetAttribute(aws.StringValueSlice(from.NS))
+	case from.S != nil:
+		return events.NewStringAttribute(*from.S)
+	case from.SS != nil:
+		return events.NewStringSetAttribute(aws.StringValueSlice(from.SS))
+	default:
+		return events.NewNullAttribute()
+	}
+}
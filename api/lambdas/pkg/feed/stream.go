@@ -0,0 +1,82 @@
+package feed
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// StreamFeed delivers the changes of accounts from the table stream. A process reads the stream once for all of its
+// subscriptions: the reader starts at the latest records with the first subscription and stops with the last one.
+// Every process that reads counts against the readers a stream shard supports, so reads that are throttled are
+// retried with the next poll
+type StreamFeed struct {
+	streams      *dynamodbstreams.DynamoDBStreams
+	streamArn    string
+	pollInterval time.Duration
+	bus          *Bus
+
+	mu            sync.Mutex
+	subscriptions int
+	stop          context.CancelFunc
+}
+
+func NewStreamFeed(streams *dynamodbstreams.DynamoDBStreams, streamArn string) *StreamFeed {
+	return &StreamFeed{streams: streams, streamArn: streamArn, pollInterval: time.Second, bus: NewBus()}
+}
+
+func (f *StreamFeed) Subscribe(ctx context.Context, key AccountKey) (<-chan Change, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.subscriptions == 0 {
+		reader := NewStreamReader(f.streams, f.streamArn, dynamodbstreams.ShardIteratorTypeLatest)
+		// position the iterators before returning so no change after subscribing is missed
+		if err := reader.Open(); err != nil {
+			return nil, err
+		}
+		readCtx, stop := context.WithCancel(context.Background())
+		f.stop = stop
+		go f.read(readCtx, reader)
+	}
+	f.subscriptions++
+
+	ch, err := f.bus.Subscribe(ctx, key)
+	if err != nil {
+		f.unsubscribe()
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.unsubscribe()
+	}()
+	return ch, nil
+}
+
+// unsubscribe stops the reader once the last subscription ended. The caller holds f.mu
+func (f *StreamFeed) unsubscribe() {
+	f.subscriptions--
+	if f.subscriptions == 0 {
+		f.stop()
+	}
+}
+
+func (f *StreamFeed) read(ctx context.Context, reader *StreamReader) {
+	for {
+		records, err := reader.Read()
+		if err != nil {
+			println("failed to read table stream: " + err.Error())
+		}
+		f.bus.Publish(records)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.pollInterval):
+		}
+	}
+}
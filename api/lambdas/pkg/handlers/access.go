@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/gin-gonic/gin"
+)
+
+const OrgOwnerIDKey = "OrgOwnerID"
+const OrgRoleKey = "OrgRole"
+const ScopesKey = "Scopes"
+
+// Permission is an action on an org that only some member roles are allowed to take
+type Permission string
+
+const (
+	// ReadOrg reads the org and everything in it
+	ReadOrg Permission = "org:read"
+	// ManageAccounts creates, changes, imports and closes accounts
+	ManageAccounts Permission = "accounts:write"
+	// ManageOrg changes the org's settings, units and policies
+	ManageOrg Permission = "org:write"
+	// ManageMembers invites and removes members
+	ManageMembers Permission = "members:write"
+	// ManageAPIKeys creates and revokes API keys
+	ManageAPIKeys Permission = "apikeys:write"
+	// DeleteOrg deletes the org
+	DeleteOrg Permission = "org:delete"
+	// ReadAudit reads the audit log of the org
+	ReadAudit Permission = "audit:read"
+)
+
+var rolePermissions = map[types.MemberRole][]Permission{
+	types.OwnerRole:    {ReadOrg, ManageAccounts, ManageOrg, ManageMembers, ManageAPIKeys, DeleteOrg, ReadAudit},
+	types.AdminRole:    {ReadOrg, ManageAccounts, ManageOrg, ManageMembers, ManageAPIKeys, ReadAudit},
+	types.OperatorRole: {ReadOrg, ManageAccounts},
+	types.ViewerRole:   {ReadOrg},
+}
+
+// HasPermission tells whether a role allows a permission
+func HasPermission(role types.MemberRole, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func isPermission(permission Permission) bool {
+	return HasPermission(types.OwnerRole, permission)
+}
+
+// IsAllowed tells whether the request may take an action on its org. Requests authenticated with an API key are
+// limited to the key's scopes, the ones of users to their role and the scopes of their token
+func IsAllowed(c *gin.Context, permission Permission) bool {
+	if key := GetAPIKey(c); key != nil {
+		return hasScope(key.Scopes, permission)
+	}
+	return HasPermission(GetOrgRole(c), permission) && tokenAllows(c, permission)
+}
+
+// GetScopes returns the scopes granted to the token of the request. Tokens without a scope claim aren't limited to
+// scopes, which is reported as false
+func GetScopes(c *gin.Context) ([]string, bool) {
+	scopes, ok := c.Get(ScopesKey)
+	if !ok {
+		return nil, false
+	}
+	s, _ := scopes.([]string)
+	return s, true
+}
+
+// parseScopes reads a scope claim, either an OAuth 2.0 space separated "scope" string or a list like "scp"
+func parseScopes(claim interface{}) ([]string, bool) {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v), true
+	case []interface{}:
+		return stringList(v), true
+	}
+	return nil, false
+}
+
+func tokenAllows(c *gin.Context, permission Permission) bool {
+	scopes, ok := GetScopes(c)
+	return !ok || hasScope(scopes, permission)
+}
+
+func hasScope(scopes []string, permission Permission) bool {
+	for _, scope := range scopes {
+		if Permission(scope) == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// forbidden aborts a request that lacks the scope a route requires
+func forbidden(c *gin.Context, permission Permission, message string) {
+	apierror.Abort(c, apierror.Forbidden(message).With("requiredScope", permission))
+}
+
+// RequireScope aborts requests whose token wasn't granted the scope. It annotates routes outside of orgs, where
+// member roles don't apply
+func RequireScope(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := GetAPIKey(c); key != nil && !hasScope(key.Scopes, permission) {
+			forbidden(c, permission, fmt.Sprintf("API key does not have scope '%s'", permission))
+			return
+		}
+		if !tokenAllows(c, permission) {
+			forbidden(c, permission, fmt.Sprintf("Token does not have scope '%s'", permission))
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetOrgOwnerID returns the ID of the user whose partition holds the org of the request. Outside of org routes it's
+// the authenticated user
+func GetOrgOwnerID(c *gin.Context) string {
+	if ownerID := c.GetString(OrgOwnerIDKey); ownerID != "" {
+		return ownerID
+	}
+	return GetUserID(c)
+}
+
+// GetOrgRole returns the role of the authenticated user in the org of the request
+func GetOrgRole(c *gin.Context) types.MemberRole {
+	return types.MemberRole(c.GetString(OrgRoleKey))
+}
+
+// Access resolves the org of a request through the memberships of the authenticated user
+type Access struct {
+	memberDb *db.MemberDB
+}
+
+func NewAccess(memberDb *db.MemberDB) *Access {
+	return &Access{memberDb: memberDb}
+}
+
+// Require resolves the owner of the org named in the path and aborts the request unless the user's role in the org
+// allows the permission and their token has it as scope. Users without a membership access the orgs they created
+// themselves as their owner. API keys only have access to the org they were created for
+func (a *Access) Require(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := GetUserID(c)
+		orgName := c.Param("organizationName")
+
+		if key := GetAPIKey(c); key != nil {
+			if key.OrgName != orgName {
+				apierror.Abort(c, apierror.Forbidden(fmt.Sprintf("API key has no access to organization '%s'", orgName)))
+				return
+			}
+			// the owner is resolved before checking the scope, so that denied requests are audited in the org
+			c.Set(OrgOwnerIDKey, key.OwnerID)
+			if !IsAllowed(c, permission) {
+				forbidden(c, permission, fmt.Sprintf("API key does not have scope '%s'", permission))
+				return
+			}
+			c.Next()
+			return
+		}
+
+		ownerID := userID
+		role := types.OwnerRole
+		membership, err := a.memberDb.GetMembership(userID, orgName)
+		if err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+		if membership != nil {
+			ownerID = membership.OwnerID
+			role = types.MemberRole(membership.Role)
+		}
+
+		c.Set(OrgOwnerIDKey, ownerID)
+		c.Set(OrgRoleKey, string(role))
+		if !HasPermission(role, permission) {
+			forbidden(c, permission, fmt.Sprintf("Role '%s' does not have permission '%s'", role, permission))
+			return
+		}
+		if !tokenAllows(c, permission) {
+			forbidden(c, permission, fmt.Sprintf("Token does not have scope '%s'", permission))
+			return
+		}
+		c.Next()
+	}
+}
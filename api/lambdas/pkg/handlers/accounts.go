@@ -1,95 +1,488 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/flostadler/festus/api/pkg/apierror"
 	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/iac"
 	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/flostadler/festus/api/pkg/validation"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type AccountsHandler struct {
 	orgDb *db.OrganizationDB
 	accountDb *db.AccountDB
+	unitDb *db.UnitDB
+	previewDb *db.PreviewDB
 }
 
-func NewAccountsHandler(orgDb *db.OrganizationDB, accountDb *db.AccountDB) *AccountsHandler {
-	return &AccountsHandler{orgDb: orgDb, accountDb: accountDb}
+func NewAccountsHandler(orgDb *db.OrganizationDB, accountDb *db.AccountDB, unitDb *db.UnitDB, previewDb *db.PreviewDB) *AccountsHandler {
+	return &AccountsHandler{orgDb: orgDb, accountDb: accountDb, unitDb: unitDb, previewDb: previewDb}
 }
 
+// previewTTL is how long the results of previews can be retrieved
+const previewTTL = 24 * time.Hour
+
+// CreateAccount stores a new account, which the stream processor creates in AWS afterwards. Accounts without an
+// email get one rendered from the email template of their org
 func (h *AccountsHandler) CreateAccount(c *gin.Context) {
 	orgName := c.Param("organizationName")
-	userID := GetUserID(c)
+	userID := GetOrgOwnerID(c)
 
 	var acc types.Account
-	if err := c.BindJSON(&acc); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&acc); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
 		return
 	}
-
-	if err := validateAccount(acc); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if acc.AccountID != "" || acc.Imported || acc.AccessRole != "" {
+		apierror.Abort(c, apierror.Validation("Existing AWS accounts are adopted with accounts:import"))
 		return
 	}
 
 	org, err := h.orgDb.GetItem(userID, orgName, false)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Abort(c, err)
 		return
 	}
 	if org == nil {
-		c.JSON(http.StatusFound, gin.H{"error": "Organization does not exist"})
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return
+	}
+
+	var rendered string
+	if acc.Email == "" && org.EmailTemplate != "" {
+		acc.Email = org.AccountEmail(acc.AccountName)
+		rendered = acc.Email
+	}
+	errs := accountErrors(acc)
+	if err := enforceNamingRules(&errs, h.accountDb, h.unitDb, userID, orgName, org.NamingRules, acc, false); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if err := errs.Err(); err != nil {
+		apierror.Abort(c, renderedEmail(err, rendered))
+		return
+	}
+	if ok := h.unitExists(c, userID, orgName, acc.Unit); !ok {
+		return
+	}
+	if err := h.checkEmail(userID, orgName, acc.AccountName, acc.Email); err != nil {
+		apierror.Abort(c, renderedEmail(err, rendered))
 		return
 	}
 
+	acc.Status = types.Pending
+	setAuditTarget(c, "accounts/"+acc.AccountName)
 	newAcc, err := h.accountDb.PutItem(userID, orgName, &acc)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.AlreadyExists("Account already exists"))
+		return
+	}
+	if errors.Is(err, db.ErrEmailTaken) {
+		apierror.Abort(c, renderedEmail(emailTaken(""), rendered))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Abort(c, err)
 		return
 	}
+	auditCreated(c)
 
-	c.JSON(http.StatusCreated, newAcc)
+	c.JSON(http.StatusCreated, newAcc.Redacted())
 }
 
 func (h *AccountsHandler) GetAccount(c *gin.Context) {
 	orgName := c.Param("organizationName")
 	accountName := c.Param("accountName")
-	userID := GetUserID(c)
+	userID := GetOrgOwnerID(c)
 
 	account, err := h.accountDb.GetItem(userID, orgName, accountName, false)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Abort(c, err)
 		return
 	}
 
 	if account == nil {
-		c.JSON(http.StatusFound, gin.H{"error": "Account does not exist"})
+		apierror.Abort(c, apierror.NotFound("Account does not exist"))
 		return
 	}
 
-	c.JSON(http.StatusOK, account)
+	c.JSON(http.StatusOK, account.Redacted())
 }
 
-func (h *AccountsHandler) DeleteAccount(c *gin.Context) {
+// UpdateAccount changes the desired state of an account. The stream processor applies it to the account afterwards
+func (h *AccountsHandler) UpdateAccount(c *gin.Context) {
 	orgName := c.Param("organizationName")
 	accountName := c.Param("accountName")
-	userID := GetUserID(c)
+	userID := GetOrgOwnerID(c)
+
+	var desired types.Account
+	if err := c.ShouldBindJSON(&desired); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+	if desired.AccountName != "" && desired.AccountName != accountName {
+		apierror.Abort(c, apierror.Validation("Account name cannot be changed"))
+		return
+	}
+	// the email is kept if it's left out, e.g. when only the tags change
+	var errs validation.Errors
+	if desired.Email != "" {
+		validation.Email(&errs, "email", desired.Email)
+	}
+	validation.Tags(&errs, "tags", desired.Tags)
+	if err := errs.Err(); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	version, account, err := h.accountDb.GetItemWithVersion(userID, orgName, accountName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if account == nil {
+		apierror.Abort(c, apierror.NotFound("Account does not exist"))
+		return
+	}
+	if reason := closureConflict(account, types.SpecUpdate); reason != "" {
+		apierror.Abort(c, apierror.Conflict(reason))
+		return
+	}
+
+	if (desired.ParentID != "" && desired.ParentID != account.ParentID) || (desired.Unit != "" && desired.Unit != account.Unit) {
+		apierror.Abort(c, apierror.Validation("The parent of an account is changed by moving it"))
+		return
+	}
 
-	err := h.accountDb.DeleteItem(userID, orgName, accountName)
+	if desired.Email == "" {
+		desired.Email = account.Email
+	}
+	if account.Email == desired.Email && maps.Equal(account.Tags, desired.Tags) {
+		c.JSON(http.StatusOK, account.Redacted())
+		return
+	}
+	if err := h.checkEmail(userID, orgName, accountName, desired.Email); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	err = h.accountDb.UpdateDesiredState(userID, orgName, accountName, version, account.Email, &desired)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.PreconditionFailed("Account was modified concurrently"))
+		return
+	}
+	if errors.Is(err, db.ErrEmailTaken) {
+		apierror.Abort(c, emailTaken(""))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.Abort(c, err)
 		return
 	}
+	auditVersionBump(c, version)
 
-	c.JSON(http.StatusNoContent, nil)
+	account.Email = desired.Email
+	account.Tags = desired.Tags
+	c.JSON(http.StatusOK, account.Redacted())
 }
 
-func validateAccount(account types.Account) error {
-	if strings.Contains(account.AccountName, "#") {
-		return fmt.Errorf("account name contains illegal characters")
+// moveAccountRequest targets either an organizational unit managed by festus or a raw parent ID
+type moveAccountRequest struct {
+	ParentID string `json:"parentID"`
+	Unit     string `json:"unit"`
+}
+
+// MoveAccount moves an account to another organizational unit. The stream processor moves the account in place
+func (h *AccountsHandler) MoveAccount(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	accountName := c.Param("accountName")
+	userID := GetOrgOwnerID(c)
+
+	var req moveAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+	if (req.ParentID == "") == (req.Unit == "") {
+		apierror.Abort(c, apierror.Validation("Either parentID or unit is required"))
+		return
+	}
+	var errs validation.Errors
+	validation.ParentID(&errs, "parentID", req.ParentID)
+	if err := errs.Err(); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if ok := h.unitExists(c, userID, orgName, req.Unit); !ok {
+		return
+	}
+
+	org, err := h.orgDb.GetItem(userID, orgName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if org == nil {
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return
+	}
+
+	version, account, err := h.accountDb.GetItemWithVersion(userID, orgName, accountName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if account == nil {
+		apierror.Abort(c, apierror.NotFound("Account does not exist"))
+		return
+	}
+
+	if reason := closureConflict(account, types.SpecMove); reason != "" {
+		apierror.Abort(c, apierror.Conflict(reason))
+		return
+	}
+
+	if account.ParentID == req.ParentID && account.Unit == req.Unit {
+		c.JSON(http.StatusOK, account.Redacted())
+		return
 	}
 
+	moved := *account
+	moved.ParentID = req.ParentID
+	moved.Unit = req.Unit
+	if err := enforceNamingRules(&errs, h.accountDb, h.unitDb, userID, orgName, org.NamingRules, moved, true); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if err := errs.Err(); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	move := types.ParentMove{
+		From:     account.ParentID,
+		To:       req.ParentID,
+		FromUnit: account.Unit,
+		ToUnit:   req.Unit,
+		MovedAt:  time.Now().UTC(),
+	}
+	err = h.accountDb.MoveParent(userID, orgName, accountName, version, move)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.PreconditionFailed("Account was modified concurrently"))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	auditVersionBump(c, version)
+
+	account.ParentID = req.ParentID
+	account.Unit = req.Unit
+	account.ParentHistory = append(account.ParentHistory, move)
+	c.JSON(http.StatusOK, account.Redacted())
+}
+
+// unitExists checks that an account references an existing unit, if any, and responds with an error otherwise
+func (h *AccountsHandler) unitExists(c *gin.Context, userID string, orgName string, unitName string) bool {
+	if unitName == "" {
+		return true
+	}
+
+	unit, err := h.unitDb.GetItem(userID, orgName, unitName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return false
+	}
+	if unit == nil {
+		apierror.Abort(c, apierror.Validation(fmt.Sprintf("Organizational unit '%s' does not exist", unitName)))
+		return false
+	}
+	return true
+}
+
+// checkEmail returns an error if another account of the org uses an email
+func (h *AccountsHandler) checkEmail(userID string, orgName string, accountName string, email string) error {
+	owner, err := h.accountDb.GetEmailOwner(userID, orgName, email)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != accountName {
+		return emailTaken(owner)
+	}
 	return nil
 }
+
+// emailTaken is the error of an email that the account owner uses already. The owner is unknown if the email was
+// taken concurrently
+func emailTaken(owner string) error {
+	var errs validation.Errors
+	if owner == "" {
+		errs.Add("email", "is already used by another account of the organization")
+	} else {
+		errs.Add("email", "is already used by account '%s'", owner)
+	}
+	return errs
+}
+
+// renderedEmail points out that an invalid email was rendered from the email template of the org, unless the client
+// set the email itself and rendered is empty
+func renderedEmail(err error, rendered string) error {
+	var errs validation.Errors
+	if rendered == "" || !errors.As(err, &errs) {
+		return err
+	}
+	for i := range errs {
+		if errs[i].Field == "email" {
+			errs[i].Message = fmt.Sprintf("%s, '%s' was rendered from the email template of the organization", errs[i].Message, rendered)
+		}
+	}
+	return errs
+}
+
+// ListAccounts returns the accounts of an org, optionally filtered by their drift status
+func (h *AccountsHandler) ListAccounts(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := GetOrgOwnerID(c)
+
+	driftStatus := types.DriftStatus(c.Query("driftStatus"))
+	switch driftStatus {
+	case "", types.InSync, types.Drifted, types.DriftCheckFailed:
+	default:
+		apierror.Abort(c, apierror.BadRequest(fmt.Sprintf("unknown drift status '%s'", driftStatus)))
+		return
+	}
+
+	accounts, err := h.accountDb.ListItems(userID, orgName, driftStatus)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	redacted := make([]*types.Account, len(accounts))
+	for i, account := range accounts {
+		redacted[i] = account.Redacted()
+	}
+	c.JSON(http.StatusOK, gin.H{"accounts": redacted})
+}
+
+// DeleteAccount removes an account from festus without closing the AWS account. Accounts are closed with CloseAccount
+func (h *AccountsHandler) DeleteAccount(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	accountName := c.Param("accountName")
+	userID := GetOrgOwnerID(c)
+
+	account, err := h.accountDb.GetItem(userID, orgName, accountName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if account != nil {
+		if reason := closureConflict(account, types.SpecDelete); reason != "" {
+			apierror.Abort(c, apierror.Conflict(reason))
+			return
+		}
+	}
+
+	err = h.accountDb.DeleteItem(userID, orgName, accountName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// PreviewAccount requests a dry-run of the account's stack. Previews install the Pulumi CLI and its plugins, which
+// takes longer than API Gateway waits for a response, so the stream processor runs them. The response points at
+// the preview, which contains the planned changes once it succeeded. The account status is not changed
+func (h *AccountsHandler) PreviewAccount(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	accountName := c.Param("accountName")
+	userID := GetOrgOwnerID(c)
+
+	account, err := h.accountDb.GetItem(userID, orgName, accountName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if account == nil {
+		apierror.Abort(c, apierror.NotFound("Account does not exist"))
+		return
+	}
+	if account.Status == types.CreatingAccount || account.Status == types.Updating {
+		apierror.Abort(c, apierror.Conflict("Account is being provisioned"))
+		return
+	}
+
+	previewID := uuid.NewString()
+	setAuditTarget(c, fmt.Sprintf("accounts/%s/previews/%s", accountName, previewID))
+	result, err := h.previewDb.PutItem(userID, orgName, accountName, previewID, GetUserID(c), time.Now().UTC(), previewTTL)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/preview")+"/previews/"+previewID)
+	c.JSON(http.StatusAccepted, result)
+}
+
+// GetPreview returns a preview of an account's stack, including the planned changes once it succeeded
+func (h *AccountsHandler) GetPreview(c *gin.Context) {
+	result, err := h.previewDb.GetItem(GetOrgOwnerID(c), c.Param("organizationName"), c.Param("accountName"), c.Param("previewID"))
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if result == nil {
+		apierror.Abort(c, apierror.NotFound("Preview does not exist"))
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// accountErrors lists the invalid fields of an account that clients set
+func accountErrors(account types.Account) validation.Errors {
+	var errs validation.Errors
+	validation.Name(&errs, "accountName", account.AccountName, validation.MaxAccountNameLength)
+	if account.AccountName == iac.OrgStackName {
+		errs.Add("accountName", "'%s' is reserved", iac.OrgStackName)
+	}
+	validation.Email(&errs, "email", account.Email)
+	validation.ParentID(&errs, "parentID", account.ParentID)
+	if strings.Contains(account.Unit, "#") {
+		errs.Add("unit", "contains illegal characters")
+	}
+	validation.Tags(&errs, "tags", account.Tags)
+
+	// the state of an account is owned by the server, new accounts always start pending
+	if account.Status != types.Pending {
+		errs.Add("status", "is set by the server")
+	}
+	if account.LastDeploymentID != "" {
+		errs.Add("lastDeploymentID", "is set by the server")
+	}
+	if account.LastUpdateVersion != 0 {
+		errs.Add("lastUpdateVersion", "is set by the server")
+	}
+	if account.DriftStatus != "" || account.DriftSummary != "" || account.DriftCheckedAt != nil {
+		errs.Add("driftStatus", "is set by the server")
+	}
+	if account.Closure != nil {
+		errs.Add("closure", "is set by the server")
+	}
+	if len(account.ParentHistory) != 0 {
+		errs.Add("parentHistory", "is set by the server")
+	}
+	return errs
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/gin-gonic/gin"
+)
+
+const APIKeyKey = "APIKey"
+
+// apiKeyPrefix marks festus API keys, e.g. for secret scanners
+const apiKeyPrefix = "fst_"
+
+// maxAPIKeyLifetime limits how long keys are valid, so that forgotten keys expire eventually
+const maxAPIKeyLifetime = 365 * 24 * time.Hour
+
+// apiKeyUsageResolution limits how often the last usage of a key is written
+const apiKeyUsageResolution = time.Minute
+
+type APIKeysHandler struct {
+	orgDb *db.OrganizationDB
+	keyDb *db.APIKeyDB
+}
+
+func NewAPIKeysHandler(orgDb *db.OrganizationDB, keyDb *db.APIKeyDB) *APIKeysHandler {
+	return &APIKeysHandler{orgDb: orgDb, keyDb: keyDb}
+}
+
+// CreateAPIKey creates a key for the org. The secret is part of the response and can't be retrieved afterwards.
+// Keys can't have scopes their creator doesn't have
+func (h *APIKeysHandler) CreateAPIKey(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	ownerID := GetOrgOwnerID(c)
+
+	var key types.APIKey
+	if err := c.ShouldBindJSON(&key); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	now := time.Now().UTC()
+	if key.ExpiresAt.IsZero() {
+		key.ExpiresAt = now.Add(types.DefaultAPIKeyLifetime)
+	}
+	if err := validateAPIKey(key, now); err != nil {
+		apierror.Abort(c, apierror.Validation(err.Error()))
+		return
+	}
+	for _, scope := range key.Scopes {
+		if !IsAllowed(c, Permission(scope)) {
+			forbidden(c, Permission(scope), fmt.Sprintf("You don't have permission '%s' yourself", scope))
+			return
+		}
+	}
+
+	org, err := h.orgDb.GetItem(ownerID, orgName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if org == nil {
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return
+	}
+
+	id, secret, err := generateAPIKey()
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	key.ID = id
+	key.CreatedBy = GetUserID(c)
+	key.CreatedAt = now
+	key.LastUsedAt = nil
+
+	setAuditTarget(c, "apikeys/"+key.ID)
+	newKey, err := h.keyDb.PutItem(ownerID, orgName, &key, hashAPIKey(secret))
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	newKey.Key = secret
+	secretResponse(c)
+	c.JSON(http.StatusCreated, newKey)
+}
+
+// ListAPIKeys returns the keys of the org without their secrets
+func (h *APIKeysHandler) ListAPIKeys(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	ownerID := GetOrgOwnerID(c)
+
+	keys, err := h.keyDb.ListItems(ownerID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKeys": keys})
+}
+
+// RevokeAPIKey deletes a key. Requests authenticated with it are rejected afterwards
+func (h *APIKeysHandler) RevokeAPIKey(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	keyID := c.Param("keyID")
+	ownerID := GetOrgOwnerID(c)
+
+	err := h.keyDb.DeleteItem(ownerID, orgName, keyID)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.NotFound("API key does not exist"))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetAPIKey returns the key that authenticated the request, or nil if a user did
+func GetAPIKey(c *gin.Context) *db.APIKeyItem {
+	key, _ := c.Get(APIKeyKey)
+	apiKey, _ := key.(*db.APIKeyItem)
+	return apiKey
+}
+
+// servicePrincipal is the user ID of requests authenticated with an API key
+func servicePrincipal(keyID string) string {
+	return "apikey:" + keyID
+}
+
+// authenticateAPIKey authenticates the request as the service principal of the key and aborts it if the key is
+// unknown, revoked or expired
+func authenticateAPIKey(c *gin.Context, keyDb *db.APIKeyDB, secret string) {
+	key, err := verifyAPIKey(keyDb, secret, time.Now().UTC())
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if key == nil {
+		apierror.Abort(c, apierror.Unauthenticated("Invalid API key"))
+		return
+	}
+
+	c.Set(UserIDKey, servicePrincipal(key.KeyID))
+	c.Set(APIKeyKey, key)
+	c.Next()
+}
+
+// verifyAPIKey returns the key a secret belongs to, or nil if the secret isn't a valid key
+func verifyAPIKey(keyDb *db.APIKeyDB, secret string, now time.Time) (*db.APIKeyItem, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(secret, apiKeyPrefix), "_")
+	if !ok || !strings.HasPrefix(secret, apiKeyPrefix) {
+		return nil, nil
+	}
+
+	key, err := keyDb.GetByID(id)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(secret)), []byte(key.Hash)) != 1 || !now.Before(key.ExpiresAt) {
+		return nil, nil
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyUsageResolution {
+		err := keyDb.MarkUsed(key, now)
+		if err != nil && !db.IsConditionalCheckFailed(err) {
+			fmt.Printf("failed to record usage of API key '%s': %s\n", key.KeyID, err.Error())
+		}
+	}
+	return key, nil
+}
+
+// generateAPIKey returns a new key of the form fst_<id>_<secret> together with its ID
+func generateAPIKey() (string, string, error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	keyID := hex.EncodeToString(id)
+	return keyID, apiKeyPrefix + keyID + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashAPIKey hashes a key for storage. The secrets are random, so they don't need a salt or a slow hash
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func validateAPIKey(key types.APIKey, now time.Time) error {
+	if key.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(key.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range key.Scopes {
+		if !isPermission(Permission(scope)) {
+			return fmt.Errorf("unknown scope '%s'", scope)
+		}
+	}
+	if !key.ExpiresAt.After(now) {
+		return fmt.Errorf("expiresAt must be in the future")
+	}
+	if key.ExpiresAt.After(now.Add(maxAPIKeyLifetime)) {
+		return fmt.Errorf("API keys cannot be valid for more than %d days", int(maxAPIKeyLifetime.Hours()/24))
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/gin-gonic/gin"
+)
+
+const AuditOrgKey = "AuditOrg"
+const AuditTargetKey = "AuditTarget"
+const AuditBeforeVersionKey = "AuditBeforeVersion"
+const AuditAfterVersionKey = "AuditAfterVersion"
+const AuditSkipKey = "AuditSkip"
+
+const defaultAuditPageSize = 50
+const maxAuditPageSize = 100
+
+// Auditor appends the mutations of requests to the audit log of their org
+type Auditor struct {
+	auditDb *db.AuditDB
+}
+
+func NewAuditor(auditDb *db.AuditDB) *Auditor {
+	return &Auditor{auditDb: auditDb}
+}
+
+// Record appends an entry for the action to the audit log once the request was handled, whatever its outcome.
+// It runs before Require, so that denied requests are recorded as well. Requests that can't be attributed to an
+// org, e.g. because the user has no access to it, aren't recorded
+func (a *Auditor) Record(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.GetBool(AuditSkipKey) {
+			return
+		}
+
+		orgName := c.Param("organizationName")
+		if orgName == "" {
+			orgName = c.GetString(AuditOrgKey)
+		}
+		if orgName == "" || (c.Param("organizationName") != "" && c.GetString(OrgOwnerIDKey) == "") {
+			return
+		}
+
+		status := c.Writer.Status()
+		outcome := types.AuditSucceeded
+		if status == http.StatusUnauthorized || status == http.StatusForbidden {
+			outcome = types.AuditDenied
+		} else if status >= http.StatusBadRequest {
+			outcome = types.AuditFailed
+		}
+
+		entry := &types.AuditEntry{
+			Actor:     GetUserID(c),
+			RequestID: GetRequestID(c),
+			Action:    action,
+			Target:    auditTarget(c),
+			Outcome:   outcome,
+			Status:    strconv.Itoa(status),
+		}
+		if version, ok := c.Get(AuditBeforeVersionKey); ok {
+			entry.BeforeVersion = version.(*int)
+		}
+		if version, ok := c.Get(AuditAfterVersionKey); ok {
+			entry.AfterVersion = version.(*int)
+		}
+
+		// the mutation already happened, so failing to record it doesn't fail the request
+		if _, err := a.auditDb.PutItem(GetOrgOwnerID(c), orgName, entry); err != nil {
+			fmt.Printf("failed to record audit entry for '%s' in org '%s': %s\n", action, orgName, err.Error())
+		}
+	}
+}
+
+// auditTarget returns the resource a request changed, relative to its org. Handlers of create requests name the
+// created resource, everything else is named by the path
+func auditTarget(c *gin.Context) string {
+	if target := c.GetString(AuditTargetKey); target != "" {
+		return target
+	}
+	for _, param := range []struct{ name, collection string }{
+		{"accountName", "accounts"},
+		{"unitName", "units"},
+		{"policyName", "policies"},
+		{"userID", "members"},
+		{"keyID", "apikeys"},
+	} {
+		if value := c.Param(param.name); value != "" {
+			return param.collection + "/" + value
+		}
+	}
+	prefix := fmt.Sprintf("/organizations/%s", c.Param("organizationName"))
+	return strings.TrimPrefix(strings.TrimPrefix(c.Request.URL.Path, prefix), "/")
+}
+
+// setAuditTarget names the resource a request created
+func setAuditTarget(c *gin.Context, target string) {
+	c.Set(AuditTargetKey, target)
+}
+
+// setAuditVersions records the versions of the changed resource before and after the request. Nil means that it
+// didn't exist
+func setAuditVersions(c *gin.Context, before *int, after *int) {
+	c.Set(AuditBeforeVersionKey, before)
+	c.Set(AuditAfterVersionKey, after)
+}
+
+// auditCreated records that a request created a versioned resource
+func auditCreated(c *gin.Context) {
+	created := 0
+	setAuditVersions(c, nil, &created)
+}
+
+// auditVersionBump records that a request changed a versioned resource and bumped its version
+func auditVersionBump(c *gin.Context, version int) {
+	after := version + 1
+	setAuditVersions(c, &version, &after)
+}
+
+// skipAudit keeps requests that turned out not to mutate anything out of the audit log
+func skipAudit(c *gin.Context) {
+	c.Set(AuditSkipKey, true)
+}
+
+// ListAuditEntries returns the audit log of an org, newest first. It's filtered with the RFC 3339 times "from" and
+// "to" and paged with "limit" and the "pageToken" of the previous page
+func (a *Auditor) ListAuditEntries(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := GetOrgOwnerID(c)
+
+	var from, to time.Time
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if value := c.Query(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				apierror.Abort(c, apierror.BadRequest(fmt.Sprintf("'%s' must be an RFC 3339 time", param.name)))
+				return
+			}
+			*param.t = t
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		apierror.Abort(c, apierror.BadRequest("'to' must not be before 'from'"))
+		return
+	}
+
+	limit := defaultAuditPageSize
+	if value := c.Query("limit"); value != "" {
+		l, err := strconv.Atoi(value)
+		if err != nil || l < 1 || l > maxAuditPageSize {
+			apierror.Abort(c, apierror.BadRequest(fmt.Sprintf("'limit' must be between 1 and %d", maxAuditPageSize)))
+			return
+		}
+		limit = l
+	}
+
+	after, err := base64.RawURLEncoding.DecodeString(c.Query("pageToken"))
+	if err != nil {
+		apierror.Abort(c, apierror.BadRequest("Invalid page token"))
+		return
+	}
+
+	entries, next, err := a.auditDb.ListItems(userID, orgName, from, to, limit, string(after))
+	if errors.Is(err, db.ErrInvalidCursor) {
+		apierror.Abort(c, apierror.BadRequest("Invalid page token"))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	res := gin.H{"entries": entries}
+	if next != "" {
+		res["nextPageToken"] = base64.RawURLEncoding.EncodeToString([]byte(next))
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"strings"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
 	"github.com/gin-gonic/gin"
 	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/google/uuid"
 )
 
 const UserIDKey = "UserID"
@@ -16,18 +21,35 @@ func GetRequestID(c *gin.Context) string {
 	return c.GetString(RequestIDKey)
 }
 
-func Auth() gin.HandlerFunc {
+// Auth authenticates requests with the principal of the API Gateway authorizer. The scopes of the user's token are
+// passed on by the authorizer as a space separated "scope" in its context. API keys passed as
+// "Authorization: Bearer fst_..." are let through by the authorizer and verified here instead
+func Auth(keys *db.APIKeyDB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if ctx, ok := core.GetAPIGatewayContextFromContext(c.Request.Context()); ok {
 			c.Set(RequestIDKey, ctx.RequestID)
-			if ctx.Authorizer != nil && ctx.Authorizer["principalId"] != nil && ctx.Authorizer["principalId"].(string) != "" {
-				c.Set(UserIDKey, ctx.Authorizer["principalId"])
+			if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && strings.HasPrefix(token, apiKeyPrefix) {
+				authenticateAPIKey(c, keys, token)
+			} else if principalID, _ := ctx.Authorizer["principalId"].(string); principalID != "" {
+				c.Set(UserIDKey, principalID)
+				if scopes, ok := parseScopes(ctx.Authorizer["scope"]); ok {
+					c.Set(ScopesKey, scopes)
+				}
 				c.Next()
 			} else {
-				c.AbortWithError(500, core.NewLoggedError("No principalId found in authorizer"))
+				apierror.Abort(c, apierror.Unauthenticated("Unauthorized"))
 			}
 		} else {
-			c.AbortWithError(500, core.NewLoggedError("No API Gateway context found"))
+			apierror.Abort(c, apierror.Unauthenticated("Unauthorized"))
 		}
 	}
 }
+
+// LocalAuth authenticates every request as userID. It's only meant for the local server
+func LocalAuth(userID string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(RequestIDKey, uuid.NewString())
+		c.Set(UserIDKey, userID)
+		c.Next()
+	}
+}
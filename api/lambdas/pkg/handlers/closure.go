@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/gin-gonic/gin"
+)
+
+// CloseAccount suspends an account and schedules its closure for the end of the org's grace period. If the org has
+// a suspended unit, the account is moved there right away. The closure sweep closes the AWS account afterwards
+func (h *AccountsHandler) CloseAccount(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	accountName := c.Param("accountName")
+	userID := GetOrgOwnerID(c)
+
+	org, err := h.orgDb.GetItem(userID, orgName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if org == nil {
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return
+	}
+
+	version, account, err := h.accountDb.GetItemWithVersion(userID, orgName, accountName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if account == nil {
+		apierror.Abort(c, apierror.NotFound("Account does not exist"))
+		return
+	}
+	if account.Status != types.Created {
+		apierror.Abort(c, apierror.Conflict("Only created accounts can be closed, the account is "+account.Status.String()))
+		return
+	}
+	if ok := h.unitExists(c, userID, orgName, org.SuspendedUnit); !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	closure := types.AccountClosure{
+		RequestedAt:   now,
+		CloseAfter:    now.Add(org.ClosureGracePeriod()),
+		SuspendedUnit: org.SuspendedUnit,
+		Unit:          account.Unit,
+		ParentID:      account.ParentID,
+	}
+	var move *types.ParentMove
+	if org.SuspendedUnit != "" && org.SuspendedUnit != account.Unit {
+		move = &types.ParentMove{
+			From:     account.ParentID,
+			FromUnit: account.Unit,
+			ToUnit:   org.SuspendedUnit,
+			MovedAt:  now,
+		}
+	}
+
+	err = h.accountDb.Suspend(userID, orgName, accountName, version, closure, move)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.PreconditionFailed("Account was modified concurrently"))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	auditVersionBump(c, version)
+
+	account.Status = types.Suspended
+	account.Closure = &closure
+	if move != nil {
+		account.ParentID = move.To
+		account.Unit = move.ToUnit
+		account.ParentHistory = append(account.ParentHistory, *move)
+	}
+	c.JSON(http.StatusAccepted, account.Redacted())
+}
+
+// CancelClosure restores a suspended account during the grace period of its closure. An account that was moved to
+// the suspended unit is moved back to where it was placed before
+func (h *AccountsHandler) CancelClosure(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	accountName := c.Param("accountName")
+	userID := GetOrgOwnerID(c)
+
+	version, account, err := h.accountDb.GetItemWithVersion(userID, orgName, accountName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if account == nil {
+		apierror.Abort(c, apierror.NotFound("Account does not exist"))
+		return
+	}
+	if account.Status != types.Suspended || account.Closure == nil {
+		apierror.Abort(c, apierror.Conflict("Account is not waiting to be closed"))
+		return
+	}
+	now := time.Now().UTC()
+	if !now.Before(account.Closure.CloseAfter) {
+		apierror.Abort(c, apierror.Conflict("The grace period of the closure has ended"))
+		return
+	}
+
+	closure := account.Closure
+	var move *types.ParentMove
+	if closure.SuspendedUnit != "" && closure.SuspendedUnit != closure.Unit {
+		move = &types.ParentMove{
+			From:     account.ParentID,
+			To:       closure.ParentID,
+			FromUnit: account.Unit,
+			ToUnit:   closure.Unit,
+			MovedAt:  now,
+		}
+	}
+
+	err = h.accountDb.CancelClosure(userID, orgName, accountName, version, move)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.PreconditionFailed("Account was modified concurrently"))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	auditVersionBump(c, version)
+
+	account.Status = types.Created
+	account.Closure = nil
+	if move != nil {
+		account.ParentID = move.To
+		account.Unit = move.ToUnit
+		account.ParentHistory = append(account.ParentHistory, *move)
+	}
+	c.JSON(http.StatusOK, account.Redacted())
+}
+
+// closureConflict tells why an account that is being closed can't be changed by the given action. Suspended accounts
+// stay where the closure placed them until it is cancelled, and accounts whose stack is being destroyed can't be
+// changed at all. It's empty if the change is possible
+func closureConflict(account *types.Account, action types.SpecAction) string {
+	if action == types.SpecMove && (account.Status == types.Suspended || account.Status == types.Closing) {
+		return "Accounts that are being closed cannot be moved"
+	}
+	if account.Status == types.Closing {
+		return "Account is being closed"
+	}
+	return ""
+}
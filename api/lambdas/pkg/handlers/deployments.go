@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/gin-gonic/gin"
+)
+
+type DeploymentsHandler struct {
+	accountDb    *db.AccountDB
+	deploymentDb *db.DeploymentDB
+}
+
+func NewDeploymentsHandler(accountDb *db.AccountDB, deploymentDb *db.DeploymentDB) *DeploymentsHandler {
+	return &DeploymentsHandler{accountDb: accountDb, deploymentDb: deploymentDb}
+}
+
+// GetDeploymentEvents returns the condensed engine events of a deployment of an account
+func (h *DeploymentsHandler) GetDeploymentEvents(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	accountName := c.Param("accountName")
+	deploymentID := c.Param("deploymentID")
+	userID := GetOrgOwnerID(c)
+
+	account, err := h.accountDb.GetItem(userID, orgName, accountName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if account == nil {
+		apierror.Abort(c, apierror.NotFound("Account does not exist"))
+		return
+	}
+
+	events, err := h.deploymentDb.ListEvents(userID, orgName, accountName, deploymentID)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deploymentID": deploymentID, "events": events})
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/gin-gonic/gin"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyKeyTTL is how long responses are replayed. Afterwards a key can be used for another request
+const idempotencyKeyTTL = 24 * time.Hour
+
+// idempotencyLease is how long a request owns its key before a retry can take it over. It exceeds the timeout of
+// the API, so only keys of requests that were cut off are taken over
+const idempotencyLease = time.Minute
+
+// SecretResponseKey marks responses that contain a secret. They are only returned once, retries with the same
+// Idempotency-Key get a 409 instead of the stored response
+const SecretResponseKey = "SecretResponse"
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotency makes POST requests with an Idempotency-Key header safe to retry. The response of the first request
+// with a key is stored per user and replayed to retries, which are marked with "Idempotent-Replayed: true". Reusing
+// a key for a different request fails with 422. Server errors aren't stored, so retries run the request again.
+// Responses with secrets aren't stored either, see SecretResponseKey
+func Idempotency(keyDb *db.IdempotencyDB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			apierror.Abort(c, apierror.BadRequest(fmt.Sprintf("%s must not be longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)))
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			apierror.Abort(c, apierror.BadRequest(err.Error()))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := GetUserID(c)
+		hash := requestHash(c.Request, body)
+		existing, err := keyDb.Claim(userID, key, hash, time.Now().UTC(), idempotencyLease, idempotencyKeyTTL)
+		if err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+		if existing != nil {
+			if existing.RequestHash != hash {
+				apierror.Abort(c, apierror.New(http.StatusUnprocessableEntity, apierror.IdempotencyKeyReusedCode, fmt.Sprintf("%s was already used for a different request", idempotencyKeyHeader)))
+				return
+			}
+			if !existing.Completed() {
+				apierror.Abort(c, apierror.Conflict(fmt.Sprintf("A request with this %s is still in progress", idempotencyKeyHeader)))
+				return
+			}
+			if existing.BodyOmitted {
+				apierror.Abort(c, apierror.AlreadyExists(fmt.Sprintf("A request with this %s already succeeded. Its response contained a secret, which is only returned once", idempotencyKeyHeader)))
+				return
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(existing.Status, existing.ContentType, existing.Body)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			if err := keyDb.Release(userID, key); err != nil {
+				fmt.Printf("failed to release idempotency key: %s\n", err.Error())
+			}
+			return
+		}
+		body = recorder.body.Bytes()
+		secret := c.GetBool(SecretResponseKey)
+		if secret {
+			body = nil
+		}
+		if err := keyDb.Complete(userID, key, status, recorder.Header().Get("Content-Type"), body, secret); err != nil {
+			// without the stored response retries would be rejected as in progress until the key expires
+			fmt.Printf("failed to store response for idempotency key: %s\n", err.Error())
+			if err := keyDb.Release(userID, key); err != nil {
+				fmt.Printf("failed to release idempotency key: %s\n", err.Error())
+			}
+		}
+	}
+}
+
+// secretResponse keeps the response of a request from being stored for retries
+func secretResponse(c *gin.Context) {
+	c.Set(SecretResponseKey, true)
+}
+
+// requestHash identifies a request by its method, path, query and body
+func requestHash(req *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder keeps a copy of the response body
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
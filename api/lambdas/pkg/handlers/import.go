@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/flostadler/festus/api/pkg/validation"
+	"github.com/gin-gonic/gin"
+)
+
+var awsAccountID = regexp.MustCompile(`^[0-9]{12}$`)
+
+// iamRoleName matches the names of IAM roles
+var iamRoleName = regexp.MustCompile(`^[\w+=,.@-]{1,64}$`)
+
+// ImportAccount adopts an existing AWS account of the organization, e.g. one created by hand. The stream processor
+// imports it into the account's stack instead of creating a new account. The baselines and ESC roles are only applied
+// inside the account if an accessRole is given that the org's credentials can assume in it.
+// The name and email have to match the AWS account, otherwise the import fails.
+func (h *AccountsHandler) ImportAccount(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := GetOrgOwnerID(c)
+
+	var acc types.Account
+	if err := c.ShouldBindJSON(&acc); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	org, err := h.orgDb.GetItem(userID, orgName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if org == nil {
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return
+	}
+
+	errs := importErrors(acc)
+	if err := enforceNamingRules(&errs, h.accountDb, h.unitDb, userID, orgName, org.NamingRules, acc, false); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if err := errs.Err(); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if ok := h.unitExists(c, userID, orgName, acc.Unit); !ok {
+		return
+	}
+
+	accounts, err := h.accountDb.ListItems(userID, orgName, "")
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	for _, existing := range accounts {
+		if existing.AccountID == acc.AccountID {
+			apierror.Abort(c, apierror.AlreadyExists(fmt.Sprintf("AWS account %s is already managed as '%s'", acc.AccountID, existing.AccountName)))
+			return
+		}
+	}
+
+	if err := h.checkEmail(userID, orgName, acc.AccountName, acc.Email); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	acc.Status = types.Pending
+	acc.Imported = true
+	setAuditTarget(c, "accounts/"+acc.AccountName)
+	newAcc, err := h.accountDb.PutItem(userID, orgName, &acc)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.AlreadyExists("Account already exists"))
+		return
+	}
+	if errors.Is(err, db.ErrEmailTaken) {
+		apierror.Abort(c, emailTaken(""))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	auditCreated(c)
+
+	c.JSON(http.StatusCreated, newAcc.Redacted())
+}
+
+// importErrors lists the invalid fields of an account that is imported
+func importErrors(account types.Account) validation.Errors {
+	errs := accountErrors(account)
+	if !awsAccountID.MatchString(account.AccountID) {
+		errs.Add("accountID", "must be the 12 digit ID of an AWS account")
+	}
+	if account.AccessRole != "" && !iamRoleName.MatchString(account.AccessRole) {
+		errs.Add("accessRole", "must be the name of an IAM role")
+	}
+	return errs
+}
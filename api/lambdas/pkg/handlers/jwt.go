@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/jwks"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const ClaimsKey = "Claims"
+const GroupsKey = "Groups"
+
+// JWTConfig configures the verification of JWTs. It matches the authParams of the API Gateway authorizer
+type JWTConfig struct {
+	// JWKSURI is the http(s) URL of the issuer's key set, or a file:// URL or path to a local one
+	JWKSURI  string
+	Issuer   string
+	Audience string
+	// GroupsClaim is the claim that lists the groups of the user. Defaults to "groups"
+	GroupsClaim string
+}
+
+// JWTConfigFromEnv reads the JWT configuration from JWKS_URI, JWT_ISSUER, JWT_AUDIENCE and JWT_GROUPS_CLAIM.
+// It returns false if no JWKS_URI is set
+func JWTConfigFromEnv() (JWTConfig, bool) {
+	cfg := JWTConfig{
+		JWKSURI:     os.Getenv("JWKS_URI"),
+		Issuer:      os.Getenv("JWT_ISSUER"),
+		Audience:    os.Getenv("JWT_AUDIENCE"),
+		GroupsClaim: os.Getenv("JWT_GROUPS_CLAIM"),
+	}
+	return cfg, cfg.JWKSURI != ""
+}
+
+// Validate checks that tokens can be verified. Without an issuer and an audience, any token signed by the keys
+// of the key set would be accepted, including ones issued for other applications
+func (cfg JWTConfig) Validate() error {
+	var missing []string
+	if cfg.JWKSURI == "" {
+		missing = append(missing, "JWKS URI")
+	}
+	if cfg.Issuer == "" {
+		missing = append(missing, "issuer")
+	}
+	if cfg.Audience == "" {
+		missing = append(missing, "audience")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("JWT verification requires the %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// JWTAuth authenticates requests with an RS256 or ES256 signed JWT in the Authorization header instead of relying on
+// the API Gateway authorizer, e.g. behind an ALB, a function URL or on the local server. The token's subject is
+// the user ID. API keys are accepted as well
+func JWTAuth(cfg JWTConfig, keys *db.APIKeyDB) (gin.HandlerFunc, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cache := jwks.New(cfg.JWKSURI, jwks.DefaultTTL)
+	groupsClaim := cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience),
+	}
+	parser := jwt.NewParser(opts...)
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return cache.Key(kid)
+	}
+
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-Id")
+		if requestID == "" {
+			requestID = uuid.NewString()
+		}
+		c.Set(RequestIDKey, requestID)
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			apierror.Abort(c, apierror.Unauthenticated("Unauthorized"))
+			return
+		}
+		if strings.HasPrefix(token, apiKeyPrefix) {
+			authenticateAPIKey(c, keys, token)
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		if _, err := parser.ParseWithClaims(token, claims, keyFunc); err != nil {
+			fmt.Printf("rejected JWT: %s\n", err.Error())
+			apierror.Abort(c, apierror.Unauthenticated("Unauthorized"))
+			return
+		}
+		subject, err := claims.GetSubject()
+		if err != nil || subject == "" {
+			apierror.Abort(c, apierror.Unauthenticated("Unauthorized"))
+			return
+		}
+
+		c.Set(UserIDKey, subject)
+		c.Set(ClaimsKey, map[string]interface{}(claims))
+		c.Set(GroupsKey, stringList(claims[groupsClaim]))
+		if scopes, ok := parseScopes(claims["scope"]); ok {
+			c.Set(ScopesKey, scopes)
+		} else if scopes, ok := parseScopes(claims["scp"]); ok {
+			c.Set(ScopesKey, scopes)
+		}
+		c.Next()
+	}, nil
+}
+
+// GetClaims returns the claims of the JWT that authenticated the request, or nil if it wasn't authenticated with one
+func GetClaims(c *gin.Context) map[string]interface{} {
+	claims, _ := c.Get(ClaimsKey)
+	m, _ := claims.(map[string]interface{})
+	return m
+}
+
+// GetGroups returns the groups of the user according to the JWT that authenticated the request
+func GetGroups(c *gin.Context) []string {
+	return c.GetStringSlice(GroupsKey)
+}
+
+// stringList accepts a claim that is a single string or a list of strings
+func stringList(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
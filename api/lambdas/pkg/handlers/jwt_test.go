@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJWTConfigFromEnv(t *testing.T) {
+	t.Setenv("JWKS_URI", "")
+	if _, ok := JWTConfigFromEnv(); ok {
+		t.Fatalf("expected JWT verification to be disabled without JWKS_URI")
+	}
+
+	t.Setenv("JWKS_URI", "https://issuer.example.com/.well-known/jwks.json")
+	t.Setenv("JWT_ISSUER", "https://issuer.example.com/")
+	t.Setenv("JWT_AUDIENCE", "festus")
+	t.Setenv("JWT_GROUPS_CLAIM", "roles")
+	cfg, ok := JWTConfigFromEnv()
+	if !ok || cfg.Issuer != "https://issuer.example.com/" || cfg.Audience != "festus" || cfg.GroupsClaim != "roles" {
+		t.Fatalf("expected the configuration to be read from the environment, got %+v", cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected the configuration to be valid, got %s", err.Error())
+	}
+}
+
+func TestJWTConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		cfg     JWTConfig
+		missing string
+	}{
+		{JWTConfig{Issuer: "https://issuer.example.com/", Audience: "festus"}, "JWKS URI"},
+		{JWTConfig{JWKSURI: "file:///jwks.json", Audience: "festus"}, "issuer"},
+		{JWTConfig{JWKSURI: "file:///jwks.json"}, "issuer, audience"},
+	} {
+		err := tc.cfg.Validate()
+		if err == nil || !strings.HasSuffix(err.Error(), "requires the "+tc.missing) {
+			t.Fatalf("expected %+v to be rejected for the missing %s, got %v", tc.cfg, tc.missing, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/gin-gonic/gin"
+)
+
+type MembersHandler struct {
+	orgDb    *db.OrganizationDB
+	memberDb *db.MemberDB
+}
+
+func NewMembersHandler(orgDb *db.OrganizationDB, memberDb *db.MemberDB) *MembersHandler {
+	return &MembersHandler{orgDb: orgDb, memberDb: memberDb}
+}
+
+// InviteMember grants a user access to the org with a role. Only owners can add other owners
+func (h *MembersHandler) InviteMember(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	ownerID := GetOrgOwnerID(c)
+
+	var member types.Member
+	if err := c.ShouldBindJSON(&member); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+	if err := validateMember(member); err != nil {
+		apierror.Abort(c, apierror.Validation(err.Error()))
+		return
+	}
+	if member.Role == types.OwnerRole && GetOrgRole(c) != types.OwnerRole {
+		apierror.Abort(c, apierror.Forbidden("Only owners can add owners"))
+		return
+	}
+
+	org, err := h.orgDb.GetItem(ownerID, orgName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if org == nil {
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return
+	}
+	if member.UserID == ownerID {
+		apierror.Abort(c, apierror.AlreadyExists("User already owns the organization"))
+		return
+	}
+
+	// the org name identifies the org in the member's requests, so it has to be unique among the user's orgs
+	ownOrg, err := h.orgDb.GetItem(member.UserID, orgName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if ownOrg != nil {
+		apierror.Abort(c, apierror.AlreadyExists(fmt.Sprintf("User already has an organization named '%s'", orgName)))
+		return
+	}
+
+	member.InvitedBy = GetUserID(c)
+	setAuditTarget(c, "members/"+member.UserID)
+	newMember, err := h.memberDb.PutItem(ownerID, orgName, &member)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.AlreadyExists(fmt.Sprintf("User is already a member of an organization named '%s'", orgName)))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newMember)
+}
+
+// ListMembers returns the users with access to the org, starting with the owner who created it
+func (h *MembersHandler) ListMembers(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	ownerID := GetOrgOwnerID(c)
+
+	org, err := h.orgDb.GetItem(ownerID, orgName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if org == nil {
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return
+	}
+
+	members, err := h.memberDb.ListItems(ownerID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	members = append([]*types.Member{{UserID: ownerID, Role: types.OwnerRole}}, members...)
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// RemoveMember revokes the access of a member. Only owners can remove other owners, the creator can't be removed
+func (h *MembersHandler) RemoveMember(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := c.Param("userID")
+	ownerID := GetOrgOwnerID(c)
+
+	if userID == ownerID {
+		apierror.Abort(c, apierror.Conflict("The user who created the organization cannot be removed"))
+		return
+	}
+
+	membership, err := h.memberDb.GetMembership(userID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if membership == nil || membership.OwnerID != ownerID {
+		apierror.Abort(c, apierror.NotFound("Member does not exist"))
+		return
+	}
+	if types.MemberRole(membership.Role) == types.OwnerRole && GetOrgRole(c) != types.OwnerRole {
+		apierror.Abort(c, apierror.Forbidden("Only owners can remove owners"))
+		return
+	}
+
+	if err := h.memberDb.DeleteItem(ownerID, orgName, userID); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func validateMember(member types.Member) error {
+	if member.UserID == "" {
+		return fmt.Errorf("userID is required")
+	}
+	if strings.Contains(member.UserID, "#") {
+		return fmt.Errorf("userID contains illegal characters")
+	}
+	if _, ok := rolePermissions[member.Role]; !ok {
+		return fmt.Errorf("unknown role '%s'", member.Role)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/flostadler/festus/api/pkg/validation"
+)
+
+// validateNamingRules checks the naming rules of an org, so that accounts are only checked against valid ones
+func validateNamingRules(errs *validation.Errors, rules *types.NamingRules) {
+	if rules == nil {
+		return
+	}
+	if rules.Pattern != "" {
+		if _, err := namingPattern(rules.Pattern); err != nil {
+			errs.Add("namingRules.pattern", "must be a regular expression: %s", err.Error())
+		}
+	}
+	for i, prefix := range rules.RequiredPrefixes {
+		if prefix == "" {
+			errs.Add(fmt.Sprintf("namingRules.requiredPrefixes[%d]", i), "must not be empty")
+		}
+	}
+	for i, name := range rules.ReservedNames {
+		if name == "" {
+			errs.Add(fmt.Sprintf("namingRules.reservedNames[%d]", i), "must not be empty")
+		}
+	}
+	if rules.MaxAccountsPerUnit < 0 {
+		errs.Add("namingRules.maxAccountsPerUnit", "cannot be negative")
+	}
+}
+
+// namingPattern compiles the pattern of naming rules, which has to match whole account names
+func namingPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// checkNamingRules adds every naming rule of an org that an account breaks to errs. It's the single check of all
+// paths that create or move accounts, units and accounts are the ones of the org before the change. Moved accounts
+// only have to fit into their new unit, their name was checked when they were created
+func checkNamingRules(errs *validation.Errors, rules *types.NamingRules, units []*types.OrganizationalUnit, accounts []*types.Account, account types.Account, moved bool) {
+	if rules == nil {
+		return
+	}
+	if !moved {
+		checkAccountName(errs, rules, account.AccountName)
+	}
+	checkUnitCapacity(errs, rules, units, accounts, account)
+}
+
+func checkAccountName(errs *validation.Errors, rules *types.NamingRules, name string) {
+	if rules.Pattern != "" {
+		// the pattern was validated when the org was created
+		if pattern, err := namingPattern(rules.Pattern); err == nil && !pattern.MatchString(name) {
+			errs.Add("accountName", "must match the pattern '%s' of the organization", rules.Pattern)
+		}
+	}
+	if len(rules.RequiredPrefixes) > 0 && !hasAnyPrefix(name, rules.RequiredPrefixes) {
+		errs.Add("accountName", "must start with one of the prefixes '%s' of the organization", strings.Join(rules.RequiredPrefixes, "', '"))
+	}
+	for _, reserved := range rules.ReservedNames {
+		if strings.EqualFold(name, reserved) {
+			errs.Add("accountName", "'%s' is reserved by the organization", reserved)
+		}
+	}
+}
+
+// checkUnitCapacity checks that the unit of an account has room for it. Accounts that are being closed don't count,
+// they leave the unit once the grace period ends
+func checkUnitCapacity(errs *validation.Errors, rules *types.NamingRules, units []*types.OrganizationalUnit, accounts []*types.Account, account types.Account) {
+	if rules.MaxAccountsPerUnit == 0 || (account.Unit == "" && account.ParentID == "") {
+		return
+	}
+	unitIDs := map[string]string{}
+	for _, unit := range units {
+		unitIDs[unit.Name] = unit.UnitID
+	}
+	parent := parentUnit(unitIDs, account)
+	count := 0
+	for _, existing := range accounts {
+		if existing.AccountName == account.AccountName || existing.Status == types.Suspended || existing.Status == types.Closing {
+			continue
+		}
+		if parentUnit(unitIDs, *existing) == parent {
+			count++
+		}
+	}
+	if count >= rules.MaxAccountsPerUnit {
+		field, parent := "unit", account.Unit
+		if parent == "" {
+			field, parent = "parentID", account.ParentID
+		}
+		errs.Add(field, "organizational unit '%s' already contains %d accounts, the organization allows at most %d", parent, count, rules.MaxAccountsPerUnit)
+	}
+}
+
+// parentUnit identifies the organizational unit an account is placed in, no matter whether it references it by name
+// or by ID. Units that weren't created yet are identified by their name, no account can reference them by ID
+func parentUnit(unitIDs map[string]string, account types.Account) string {
+	if account.Unit == "" {
+		return account.ParentID
+	}
+	if id := unitIDs[account.Unit]; id != "" {
+		return id
+	}
+	return "unit:" + account.Unit
+}
+
+// namingRuleAccounts lists the units and accounts the naming rules of an org need to check an account against.
+// Accounts created concurrently can exceed the limit of a unit
+func namingRuleAccounts(accountDb *db.AccountDB, unitDb *db.UnitDB, userID string, orgName string, rules *types.NamingRules) ([]*types.OrganizationalUnit, []*types.Account, error) {
+	if rules == nil || rules.MaxAccountsPerUnit == 0 {
+		return nil, nil, nil
+	}
+	units, err := unitDb.ListItems(userID, orgName)
+	if err != nil {
+		return nil, nil, err
+	}
+	accounts, err := accountDb.ListItems(userID, orgName, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return units, accounts, nil
+}
+
+// enforceNamingRules checks an account that is created or moved against the naming rules of its org and adds the
+// rules it breaks to errs. Only failures to list the units and accounts of the org are returned
+func enforceNamingRules(errs *validation.Errors, accountDb *db.AccountDB, unitDb *db.UnitDB, userID string, orgName string, rules *types.NamingRules, account types.Account, moved bool) error {
+	units, accounts, err := namingRuleAccounts(accountDb, unitDb, userID, orgName, rules)
+	if err != nil {
+		return err
+	}
+	checkNamingRules(errs, rules, units, accounts, account, moved)
+	return nil
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/flostadler/festus/api/pkg/validation"
+)
+
+func TestCheckNamingRules(t *testing.T) {
+	rules := &types.NamingRules{
+		Pattern:            "[a-z]+-(dev|prod|test)",
+		RequiredPrefixes:   []string{"payments-", "search-"},
+		ReservedNames:      []string{"search-prod"},
+		MaxAccountsPerUnit: 2,
+	}
+	units := []*types.OrganizationalUnit{
+		{Name: "workloads", UnitID: "ou-a1b2-workloads"},
+		{Name: "sandboxes"},
+		{Name: "shared", UnitID: "ou-a1b2-shared"},
+	}
+	accounts := []*types.Account{
+		{AccountName: "payments-dev", Unit: "workloads", Status: types.Created},
+		{AccountName: "payments-prod", Unit: "workloads", Status: types.Pending},
+		{AccountName: "search-dev", Unit: "sandboxes", Status: types.Created},
+		{AccountName: "payments-old", Unit: "sandboxes", Status: types.Suspended},
+		{AccountName: "other-dev", ParentID: "ou-a1b2-external", Status: types.Created},
+		{AccountName: "shared-dev", ParentID: "ou-a1b2-shared", Status: types.Created},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		rules    *types.NamingRules
+		account  types.Account
+		moved    bool
+		expected []string
+	}{
+		{"no rules", nil, types.Account{AccountName: "Anything", Unit: "workloads"}, false, nil},
+		{"valid", rules, types.Account{AccountName: "search-test", Unit: "sandboxes"}, false, nil},
+		{"name not matching the pattern", rules, types.Account{AccountName: "search-dev2"}, false, []string{"accountName"}},
+		{"missing prefix", rules, types.Account{AccountName: "billing-dev"}, false, []string{"accountName"}},
+		{"reserved name", rules, types.Account{AccountName: "search-prod"}, false, []string{"accountName"}},
+		{"full unit", rules, types.Account{AccountName: "search-test", Unit: "workloads"}, false, []string{"unit"}},
+		{"invalid name in a full unit", rules, types.Account{AccountName: "billing-test", Unit: "workloads"}, false, []string{"accountName", "unit"}},
+		{"moved into a full unit", rules, types.Account{AccountName: "search-dev", Unit: "workloads"}, true, []string{"unit"}},
+		{"moved within its unit", rules, types.Account{AccountName: "payments-dev", Unit: "workloads"}, true, nil},
+		{"moved with a legacy name", rules, types.Account{AccountName: "legacy", Unit: "sandboxes"}, true, nil},
+		{"parent ID", &types.NamingRules{MaxAccountsPerUnit: 1}, types.Account{AccountName: "x", ParentID: "ou-a1b2-external"}, false, []string{"parentID"}},
+		{"unit placed by parent ID", &types.NamingRules{MaxAccountsPerUnit: 1}, types.Account{AccountName: "x", Unit: "shared"}, false, []string{"unit"}},
+		{"parent ID of a unit placed by name", rules, types.Account{AccountName: "search-test", ParentID: "ou-a1b2-workloads"}, false, []string{"parentID"}},
+		{"root", &types.NamingRules{MaxAccountsPerUnit: 1}, types.Account{AccountName: "x"}, false, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var errs validation.Errors
+			checkNamingRules(&errs, tc.rules, units, accounts, tc.account, tc.moved)
+			if len(errs) != len(tc.expected) {
+				t.Fatalf("expected invalid fields %v, got %v", tc.expected, errs)
+			}
+			for i, field := range tc.expected {
+				if errs[i].Field != field {
+					t.Fatalf("expected invalid fields %v, got %v", tc.expected, errs)
+				}
+			}
+		})
+	}
+}
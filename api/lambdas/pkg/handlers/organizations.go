@@ -3,81 +3,207 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
 
+	"github.com/flostadler/festus/api/pkg/apierror"
 	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/iac"
+	"github.com/flostadler/festus/api/pkg/iac/baseline"
 	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/flostadler/festus/api/pkg/validation"
 	"github.com/gin-gonic/gin"
 )
 
 type OrganizationHandler struct {
-	db *db.OrganizationDB
+	db       *db.OrganizationDB
+	memberDb *db.MemberDB
+	keyDb    *db.APIKeyDB
 }
 
-func NewOrganizationHandler(db *db.OrganizationDB) *OrganizationHandler {
-	return &OrganizationHandler{db: db}
+func NewOrganizationHandler(orgDb *db.OrganizationDB, memberDb *db.MemberDB, keyDb *db.APIKeyDB) *OrganizationHandler {
+	return &OrganizationHandler{db: orgDb, memberDb: memberDb, keyDb: keyDb}
 }
 
 func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
 	var org types.Organization
-	if err := c.BindJSON(&org); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := c.ShouldBindJSON(&org); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
 		return
 	}
 
 	if err := validateOrg(org); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.Abort(c, err)
 		return
 	}
 
+	if GetAPIKey(c) != nil {
+		apierror.Abort(c, apierror.Forbidden("API keys cannot create organizations"))
+		return
+	}
 	userID := GetUserID(c)
 
+	// requests name orgs without their owner, so users can't create an org named like one they are a member of
+	membership, err := h.memberDb.GetMembership(userID, org.OrgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if membership != nil {
+		apierror.Abort(c, apierror.AlreadyExists(fmt.Sprintf("You are already a member of an organization named '%s'", org.OrgName)))
+		return
+	}
+
+	c.Set(AuditOrgKey, org.OrgName)
 	newOrg, err := h.db.PutItem(userID, &org)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.AlreadyExists("Organization already exists"))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store organization", "details": err.Error()})
+		apierror.Abort(c, fmt.Errorf("failed to store organization: %w", err))
 		return
 	}
 
-	c.JSON(http.StatusCreated, *newOrg)
+	c.JSON(http.StatusCreated, newOrg.Redacted())
 }
 
 func (h *OrganizationHandler) GetOrganization(c *gin.Context) {
 	name := c.Param("organizationName")
-	userID := GetUserID(c)
+	userID := GetOrgOwnerID(c)
 
 	org, err := h.db.GetItem(userID, name, false)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization", "details": err.Error()})
+		apierror.Abort(c, fmt.Errorf("failed to get organization: %w", err))
 		return
 	}
 
 	if org == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		apierror.Abort(c, apierror.NotFound("Organization not found"))
 		return
 	}
 
-	c.JSON(http.StatusOK, *org)
+	c.JSON(http.StatusOK, org.Redacted())
 }
 
 // DeleteHandler deletes an organization
 func (h *OrganizationHandler) DeleteOrganization(c *gin.Context) {
 	name := c.Param("organizationName")
-	userID := GetUserID(c)
+	userID := GetOrgOwnerID(c)
 
 	err := h.db.DeleteItem(userID, name)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete organization", "details": err.Error()})
+		apierror.Abort(c, fmt.Errorf("failed to delete organization: %w", err))
+		return
+	}
+
+	if err := h.memberDb.DeleteAll(userID, name); err != nil {
+		apierror.Abort(c, fmt.Errorf("failed to remove members: %w", err))
+		return
+	}
+	if err := h.keyDb.DeleteAll(userID, name); err != nil {
+		apierror.Abort(c, fmt.Errorf("failed to revoke API keys: %w", err))
 		return
 	}
 
-	c.JSON(http.StatusNoContent, nil)
+	c.Status(http.StatusNoContent)
 }
 
 func (h *OrganizationHandler) UpdateOrganization(c *gin.Context) {}
 
+// validateOrg lists every invalid field of an org
 func validateOrg(org types.Organization) error {
-	if strings.Contains(org.OrgName, "#") {
-		return fmt.Errorf("organization name contains illegal characters")
+	var errs validation.Errors
+	validation.Name(&errs, "orgName", org.OrgName, validation.MaxOrgNameLength)
+	if err := baseline.Validate(org.Baselines); err != nil {
+		errs.Add("baselines", "%s", err.Error())
+	}
+	if org.ClosureGracePeriodDays < 0 {
+		errs.Add("closureGracePeriodDays", "cannot be negative")
+	}
+	if strings.Contains(org.SuspendedUnit, "#") {
+		errs.Add("suspendedUnit", "contains illegal characters")
+	}
+	if org.PulumiOrg != "" && org.PulumiAccessToken == "" {
+		errs.Add("pulumiAccessToken", "is required to create ESC environments in pulumi org '%s'", org.PulumiOrg)
 	}
+	if err := validateBackend(org.Backend); err != nil {
+		errs.Add("backend", "%s", err.Error())
+	}
+	validateEmailTemplate(&errs, org)
+	validateNamingRules(&errs, org.NamingRules)
+	return errs.Err()
+}
+
+var templatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// validateEmailTemplate checks that the email template of an org renders a distinct email for every account. The
+// length of rendered emails depends on the account name, it's checked again when an account is created
+func validateEmailTemplate(errs *validation.Errors, org types.Organization) {
+	if org.EmailTemplate == "" {
+		return
+	}
+	for _, placeholder := range templatePlaceholder.FindAllString(org.EmailTemplate, -1) {
+		if placeholder != types.OrgPlaceholder && placeholder != types.AccountPlaceholder {
+			errs.Add("emailTemplate", "unknown placeholder '%s', only %s and %s are supported", placeholder, types.OrgPlaceholder, types.AccountPlaceholder)
+			return
+		}
+	}
+	if !strings.Contains(org.EmailTemplate, types.AccountPlaceholder) {
+		errs.Add("emailTemplate", "must contain %s, otherwise all accounts get the same email", types.AccountPlaceholder)
+		return
+	}
+	// the shortest email the template renders
+	validation.Email(errs, "emailTemplate", org.AccountEmail("a"))
+}
+
+// ListBaselines returns the baselines orgs can choose from together with their parameters
+func ListBaselines(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"baselines": baseline.List()})
+}
+
+var backendURLSchemes = map[types.BackendType]string{
+	types.PulumiCloudBackend: "https://",
+	types.S3Backend:          "s3://",
+	types.AzureBlobBackend:   "azblob://",
+	types.GCSBackend:         "gs://",
+	types.LocalBackend:       "file://",
+}
+
+func validateBackend(backend types.Backend) error {
+	if backend.Type != "" {
+		scheme, ok := backendURLSchemes[backend.Type]
+		if !ok {
+			return fmt.Errorf("unknown backend type '%s'", backend.Type)
+		}
+		if backend.Type != types.PulumiCloudBackend && backend.URL == "" {
+			return fmt.Errorf("backend type '%s' requires a url", backend.Type)
+		}
+		if backend.URL != "" && !strings.HasPrefix(backend.URL, scheme) {
+			return fmt.Errorf("url of backend type '%s' must start with '%s'", backend.Type, scheme)
+		}
+	}
+
+	selfManaged := backend.Type != "" && backend.Type != types.PulumiCloudBackend
+	switch backend.SecretsProvider.Type {
+	case "", types.DefaultSecretsProvider:
+		if selfManaged {
+			return fmt.Errorf("backend type '%s' requires a secrets provider other than the default", backend.Type)
+		}
+	case types.PassphraseSecretsProvider:
+		if backend.SecretsProvider.Passphrase == "" {
+			return fmt.Errorf("secrets provider 'passphrase' requires a passphrase")
+		}
+	case types.AwsKmsSecretsProvider, types.AzureKeyVaultSecretsProvider, types.GcpKmsSecretsProvider:
+		if backend.SecretsProvider.KeyID == "" {
+			return fmt.Errorf("secrets provider '%s' requires a keyID", backend.SecretsProvider.Type)
+		}
+		if _, err := iac.SecretsProviderURL(backend.SecretsProvider); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown secrets provider '%s'", backend.SecretsProvider.Type)
+	}
+
 	return nil
 }
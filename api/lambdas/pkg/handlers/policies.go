@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/gin-gonic/gin"
+)
+
+// maxPolicySize is the maximum size of a service control policy document in AWS Organizations
+const maxPolicySize = 5120
+
+type PoliciesHandler struct {
+	orgDb      *db.OrganizationDB
+	accountDb  *db.AccountDB
+	unitDb     *db.UnitDB
+	policiesDb *db.PolicyDB
+}
+
+func NewPoliciesHandler(orgDb *db.OrganizationDB, accountDb *db.AccountDB, unitDb *db.UnitDB, policiesDb *db.PolicyDB) *PoliciesHandler {
+	return &PoliciesHandler{orgDb: orgDb, accountDb: accountDb, unitDb: unitDb, policiesDb: policiesDb}
+}
+
+// CreatePolicy stores a new service control policy. The stream processor creates and attaches it with the org stack
+func (h *PoliciesHandler) CreatePolicy(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := GetOrgOwnerID(c)
+
+	var policy types.ServiceControlPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	if err := validatePolicy(policy); err != nil {
+		apierror.Abort(c, apierror.Validation(err.Error()))
+		return
+	}
+
+	org, err := h.orgDb.GetItem(userID, orgName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if org == nil {
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return
+	}
+
+	if !h.targetsExist(c, userID, orgName, policy) {
+		return
+	}
+
+	setAuditTarget(c, "policies/"+policy.Name)
+	newPolicy, err := h.policiesDb.PutItem(userID, orgName, &policy)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.AlreadyExists("Policy already exists"))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newPolicy)
+}
+
+func (h *PoliciesHandler) GetPolicy(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	policyName := c.Param("policyName")
+	userID := GetOrgOwnerID(c)
+
+	policy, err := h.policiesDb.GetItem(userID, orgName, policyName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if policy == nil {
+		apierror.Abort(c, apierror.NotFound("Policy does not exist"))
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+func (h *PoliciesHandler) ListPolicies(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := GetOrgOwnerID(c)
+
+	policies, err := h.policiesDb.ListItems(userID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// UpdatePolicy replaces the description, the document and the targets of a policy
+func (h *PoliciesHandler) UpdatePolicy(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	policyName := c.Param("policyName")
+	userID := GetOrgOwnerID(c)
+
+	var desired types.ServiceControlPolicy
+	if err := c.ShouldBindJSON(&desired); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+	if desired.Name != "" && desired.Name != policyName {
+		apierror.Abort(c, apierror.Validation("Policies cannot be renamed"))
+		return
+	}
+	desired.Name = policyName
+
+	if err := validatePolicy(desired); err != nil {
+		apierror.Abort(c, apierror.Validation(err.Error()))
+		return
+	}
+	if !h.targetsExist(c, userID, orgName, desired) {
+		return
+	}
+
+	err := h.policiesDb.UpdateItem(userID, orgName, &desired)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.NotFound("Policy does not exist"))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	policy, err := h.policiesDb.GetItem(userID, orgName, policyName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// DeletePolicy deletes a policy. The org stack detaches and deletes it afterwards
+func (h *PoliciesHandler) DeletePolicy(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	policyName := c.Param("policyName")
+	userID := GetOrgOwnerID(c)
+
+	err := h.policiesDb.DeleteItem(userID, orgName, policyName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// EffectivePolicies lists the policies that apply to an account, i.e. the ones attached to the account and to the
+// units it's nested in. Accounts placed by parent ID only get the policies attached to them directly.
+func (h *PoliciesHandler) EffectivePolicies(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	accountName := c.Param("accountName")
+	userID := GetOrgOwnerID(c)
+
+	account, err := h.accountDb.GetItem(userID, orgName, accountName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if account == nil {
+		apierror.Abort(c, apierror.NotFound("Account does not exist"))
+		return
+	}
+
+	targets := map[string]bool{"account/" + accountName: true}
+	for unitName := account.Unit; unitName != ""; {
+		targets["unit/"+unitName] = true
+		unit, err := h.unitDb.GetItem(userID, orgName, unitName, false)
+		if err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+		if unit == nil {
+			break
+		}
+		unitName = unit.Parent
+	}
+
+	policies, err := h.policiesDb.ListItems(userID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	effective := []types.EffectivePolicy{}
+	for _, policy := range policies {
+		var attachments []string
+		for _, name := range policy.Accounts {
+			attachments = append(attachments, "account/"+name)
+		}
+		for _, name := range policy.Units {
+			attachments = append(attachments, "unit/"+name)
+		}
+		for _, attachment := range attachments {
+			if targets[attachment] {
+				effective = append(effective, types.EffectivePolicy{
+					Name:        policy.Name,
+					Description: policy.Description,
+					Document:    policy.Document,
+					AttachedTo:  attachment,
+				})
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": effective})
+}
+
+// targetsExist checks that the units and accounts a policy is attached to exist and responds with an error otherwise
+func (h *PoliciesHandler) targetsExist(c *gin.Context, userID string, orgName string, policy types.ServiceControlPolicy) bool {
+	for _, unitName := range policy.Units {
+		unit, err := h.unitDb.GetItem(userID, orgName, unitName, true)
+		if err != nil {
+			apierror.Abort(c, err)
+			return false
+		}
+		if unit == nil {
+			apierror.Abort(c, apierror.Validation(fmt.Sprintf("Organizational unit '%s' does not exist", unitName)))
+			return false
+		}
+	}
+	for _, accountName := range policy.Accounts {
+		account, err := h.accountDb.GetItem(userID, orgName, accountName, true)
+		if err != nil {
+			apierror.Abort(c, err)
+			return false
+		}
+		if account == nil {
+			apierror.Abort(c, apierror.Validation(fmt.Sprintf("Account '%s' does not exist", accountName)))
+			return false
+		}
+	}
+	return true
+}
+
+func validatePolicy(policy types.ServiceControlPolicy) error {
+	if policy.Name == "" {
+		return fmt.Errorf("policy name is required")
+	}
+	if strings.Contains(policy.Name, "#") {
+		return fmt.Errorf("policy name contains illegal characters")
+	}
+	if err := validatePolicyDocument(policy.Document); err != nil {
+		return fmt.Errorf("policy document is invalid: %s", err.Error())
+	}
+	return nil
+}
+
+type policyDocument struct {
+	Version   string          `json:"Version"`
+	ID        string          `json:"Id"`
+	Statement json.RawMessage `json:"Statement"`
+}
+
+type policyStatement struct {
+	Sid          string                 `json:"Sid"`
+	Effect       string                 `json:"Effect"`
+	Action       json.RawMessage        `json:"Action"`
+	NotAction    json.RawMessage        `json:"NotAction"`
+	Resource     json.RawMessage        `json:"Resource"`
+	NotResource  json.RawMessage        `json:"NotResource"`
+	Condition    map[string]interface{} `json:"Condition"`
+	Principal    json.RawMessage        `json:"Principal"`
+	NotPrincipal json.RawMessage        `json:"NotPrincipal"`
+}
+
+// validatePolicyDocument checks the structure of a service control policy. Whether the actions exist is left to AWS
+func validatePolicyDocument(document string) error {
+	if document == "" {
+		return fmt.Errorf("document is required")
+	}
+	if len(document) > maxPolicySize {
+		return fmt.Errorf("document exceeds %d characters", maxPolicySize)
+	}
+
+	var doc policyDocument
+	if err := decodeStrict(document, &doc); err != nil {
+		return err
+	}
+	if doc.Version != "2012-10-17" {
+		return fmt.Errorf("Version must be '2012-10-17'")
+	}
+
+	// Statement is either a single statement or a list of statements
+	var statements []json.RawMessage
+	if trimmed := bytes.TrimSpace(doc.Statement); len(trimmed) > 0 && trimmed[0] == '{' {
+		statements = []json.RawMessage{doc.Statement}
+	} else if err := json.Unmarshal(doc.Statement, &statements); err != nil || len(statements) == 0 {
+		return fmt.Errorf("Statement must be a statement or a non-empty list of statements")
+	}
+
+	for i, raw := range statements {
+		var statement policyStatement
+		if err := decodeStrict(string(raw), &statement); err != nil {
+			return fmt.Errorf("statement %d: %s", i, err.Error())
+		}
+		if statement.Effect != "Allow" && statement.Effect != "Deny" {
+			return fmt.Errorf("statement %d: Effect must be 'Allow' or 'Deny'", i)
+		}
+		if statement.Principal != nil || statement.NotPrincipal != nil {
+			return fmt.Errorf("statement %d: service control policies don't support principals", i)
+		}
+		if (statement.Action == nil) == (statement.NotAction == nil) {
+			return fmt.Errorf("statement %d: exactly one of Action and NotAction is required", i)
+		}
+		if statement.Resource != nil && statement.NotResource != nil {
+			return fmt.Errorf("statement %d: Resource and NotResource are mutually exclusive", i)
+		}
+		for field, value := range map[string]json.RawMessage{
+			"Action":      statement.Action,
+			"NotAction":   statement.NotAction,
+			"Resource":    statement.Resource,
+			"NotResource": statement.NotResource,
+		} {
+			if value != nil && !isStringOrStrings(value) {
+				return fmt.Errorf("statement %d: %s must be a string or a non-empty list of strings", i, field)
+			}
+		}
+	}
+	return nil
+}
+
+// decodeStrict unmarshals a JSON object and rejects unknown fields
+func decodeStrict(document string, v interface{}) error {
+	decoder := json.NewDecoder(strings.NewReader(document))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("unexpected content after the JSON object")
+	}
+	return nil
+}
+
+func isStringOrStrings(value json.RawMessage) bool {
+	var single string
+	if err := json.Unmarshal(value, &single); err == nil {
+		return single != ""
+	}
+	var list []string
+	if err := json.Unmarshal(value, &list); err != nil || len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		if item == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/feed"
+	"github.com/gin-gonic/gin"
+)
+
+type RouterConfig struct {
+	Tables *db.Tables
+	// Feed delivers account changes to watch streams
+	Feed feed.Feed
+	// Auth authenticates requests. Defaults to the API Gateway authorizer
+	Auth gin.HandlerFunc
+	// WatchTimeout limits the duration of watch streams. Zero means no limit
+	WatchTimeout time.Duration
+	// BufferedWatch ends watch streams as soon as they have events, for proxies that buffer whole responses
+	BufferedWatch bool
+}
+
+// NewRouter wires up all routes of the festus API
+func NewRouter(cfg RouterConfig) *gin.Engine {
+	tables := cfg.Tables
+	orgHandler := NewOrganizationHandler(tables.Organizations, tables.Members, tables.APIKeys)
+	accountsHandler := NewAccountsHandler(tables.Organizations, tables.Accounts, tables.Units, tables.Previews)
+	unitsHandler := NewUnitsHandler(tables.Organizations, tables.Accounts, tables.Units, tables.Policies)
+	policiesHandler := NewPoliciesHandler(tables.Organizations, tables.Accounts, tables.Units, tables.Policies)
+	specHandler := NewSpecHandler(tables.Organizations, tables.Accounts, tables.Units, tables.Policies)
+	deploymentsHandler := NewDeploymentsHandler(tables.Accounts, tables.Deployments)
+	membersHandler := NewMembersHandler(tables.Organizations, tables.Members)
+	apiKeysHandler := NewAPIKeysHandler(tables.Organizations, tables.APIKeys)
+	watchHandler := NewWatchHandler(tables.Accounts, tables.Deployments, cfg.Feed, cfg.WatchTimeout, cfg.BufferedWatch)
+
+	auth := cfg.Auth
+	if auth == nil {
+		auth = Auth(tables.APIKeys)
+	}
+
+	r := gin.Default()
+	r.Use(auth, Idempotency(tables.Idempotency))
+	r.NoRoute(func(c *gin.Context) {
+		apierror.Abort(c, apierror.NotFound("Not found"))
+	})
+
+	root := r.Group("/")
+
+	access := NewAccess(tables.Members)
+	audit := NewAuditor(tables.Audit)
+	read := access.Require(ReadOrg)
+	manageAccounts := access.Require(ManageAccounts)
+	manageOrg := access.Require(ManageOrg)
+
+	orgs := root.Group("/organizations")
+	{
+		orgs.POST("", audit.Record("org.create"), RequireScope(ManageOrg), orgHandler.CreateOrganization)
+		orgs.GET("/:organizationName", read, orgHandler.GetOrganization)
+		orgs.DELETE("/:organizationName", audit.Record("org.delete"), access.Require(DeleteOrg), orgHandler.DeleteOrganization)
+		orgs.GET("/:organizationName/spec", read, specHandler.GetSpec)
+		orgs.PUT("/:organizationName/spec", audit.Record("spec.apply"), manageOrg, specHandler.PutSpec)
+		orgs.GET("/:organizationName/audit", access.Require(ReadAudit), audit.ListAuditEntries)
+		// the dispatcher runs the chains of custom methods itself, so the audit entry is recorded around it
+		orgs.POST("/:organizationName/:method", audit.Record("account.import"), customMethods(map[string]gin.HandlersChain{
+			"accounts:import": {manageAccounts, accountsHandler.ImportAccount},
+		}))
+		accounts := orgs.Group("/:organizationName/accounts")
+		{
+			accounts.POST("", audit.Record("account.create"), manageAccounts, accountsHandler.CreateAccount)
+			accounts.GET("", read, accountsHandler.ListAccounts)
+			accounts.GET("/:accountName", read, accountsHandler.GetAccount)
+			accounts.PUT("/:accountName", audit.Record("account.update"), manageAccounts, accountsHandler.UpdateAccount)
+			accounts.PUT("/:accountName/parent", audit.Record("account.move"), manageAccounts, accountsHandler.MoveAccount)
+			accounts.DELETE("/:accountName", audit.Record("account.delete"), manageAccounts, accountsHandler.DeleteAccount)
+			accounts.POST("/:accountName/close", audit.Record("account.close"), manageAccounts, accountsHandler.CloseAccount)
+			accounts.DELETE("/:accountName/close", audit.Record("account.cancel_closure"), manageAccounts, accountsHandler.CancelClosure)
+			accounts.POST("/:accountName/preview", audit.Record("account.preview"), manageAccounts, accountsHandler.PreviewAccount)
+			accounts.GET("/:accountName/previews/:previewID", read, accountsHandler.GetPreview)
+			accounts.GET("/:accountName/policies", read, policiesHandler.EffectivePolicies)
+			accounts.GET("/:accountName/watch", read, watchHandler.WatchAccount)
+			accounts.GET("/:accountName/deployments/:deploymentID/events", read, deploymentsHandler.GetDeploymentEvents)
+		}
+		units := orgs.Group("/:organizationName/units")
+		{
+			units.POST("", audit.Record("unit.create"), manageOrg, unitsHandler.CreateUnit)
+			units.GET("", read, unitsHandler.ListUnits)
+			units.GET("/:unitName", read, unitsHandler.GetUnit)
+			units.PUT("/:unitName", audit.Record("unit.update"), manageOrg, unitsHandler.UpdateUnit)
+			units.DELETE("/:unitName", audit.Record("unit.delete"), manageOrg, unitsHandler.DeleteUnit)
+		}
+		policies := orgs.Group("/:organizationName/policies")
+		{
+			policies.POST("", audit.Record("policy.create"), manageOrg, policiesHandler.CreatePolicy)
+			policies.GET("", read, policiesHandler.ListPolicies)
+			policies.GET("/:policyName", read, policiesHandler.GetPolicy)
+			policies.PUT("/:policyName", audit.Record("policy.update"), manageOrg, policiesHandler.UpdatePolicy)
+			policies.DELETE("/:policyName", audit.Record("policy.delete"), manageOrg, policiesHandler.DeletePolicy)
+		}
+		members := orgs.Group("/:organizationName/members")
+		{
+			members.POST("", audit.Record("member.invite"), access.Require(ManageMembers), membersHandler.InviteMember)
+			members.GET("", read, membersHandler.ListMembers)
+			members.DELETE("/:userID", audit.Record("member.remove"), access.Require(ManageMembers), membersHandler.RemoveMember)
+		}
+		apiKeys := orgs.Group("/:organizationName/apikeys")
+		{
+			apiKeys.POST("", audit.Record("apikey.create"), access.Require(ManageAPIKeys), apiKeysHandler.CreateAPIKey)
+			apiKeys.GET("", access.Require(ManageAPIKeys), apiKeysHandler.ListAPIKeys)
+			apiKeys.DELETE("/:keyID", audit.Record("apikey.revoke"), access.Require(ManageAPIKeys), apiKeysHandler.RevokeAPIKey)
+		}
+	}
+
+	root.GET("/baselines", ListBaselines)
+
+	root.GET("/ping", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"message":   "pong",
+			"userID":    GetUserID(c),
+			"requestID": GetRequestID(c),
+		})
+	})
+
+	return r
+}
+
+// customMethods dispatches custom methods of the form "collection:verb". Gin treats colons as the start of a path
+// parameter, so they are routed by the whole path segment instead
+func customMethods(methods map[string]gin.HandlersChain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chain, ok := methods[c.Param("method")]
+		if !ok {
+			apierror.Abort(c, apierror.NotFound("Not found"))
+			return
+		}
+		for _, handler := range chain {
+			if c.IsAborted() {
+				return
+			}
+			handler(c)
+		}
+	}
+}
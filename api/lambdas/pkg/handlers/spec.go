@@ -0,0 +1,394 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/iac/baseline"
+	"github.com/flostadler/festus/api/pkg/spec"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/flostadler/festus/api/pkg/validation"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+type SpecHandler struct {
+	orgDb      *db.OrganizationDB
+	accountDb  *db.AccountDB
+	unitDb     *db.UnitDB
+	policiesDb *db.PolicyDB
+}
+
+func NewSpecHandler(orgDb *db.OrganizationDB, accountDb *db.AccountDB, unitDb *db.UnitDB, policiesDb *db.PolicyDB) *SpecHandler {
+	return &SpecHandler{orgDb: orgDb, accountDb: accountDb, unitDb: unitDb, policiesDb: policiesDb}
+}
+
+// GetSpec exports the units and accounts of an org as a spec. YAML is returned if the client accepts it
+func (h *SpecHandler) GetSpec(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := GetOrgOwnerID(c)
+
+	state, ok := h.loadState(c, userID, orgName)
+	if !ok {
+		return
+	}
+
+	respondSpec(c, http.StatusOK, state.spec())
+}
+
+// PutSpec returns the plan that converges an org to the given spec. With ?apply=true the plan is applied to the
+// items right away and the stream processor converges the AWS resources afterwards. Changed baselines are applied
+// to existing accounts the next time they are reconciled. New accounts without an email get one rendered from the
+// email template of the org. Accounts missing from the spec are rejected, they are removed by closing them.
+// Every change is checked before anything is applied, but applying isn't transactional. If a change fails anyway,
+// e.g. because an item was modified concurrently, the changes before it stay applied. The error lists the plan with
+// the applied changes marked and the spec can simply be put again.
+func (h *SpecHandler) PutSpec(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := GetOrgOwnerID(c)
+
+	var desired types.OrgSpec
+	var b binding.BindingBody = binding.JSON
+	if isYAML(c.ContentType()) {
+		b = binding.YAML
+	}
+	if err := c.ShouldBindWith(&desired, b); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	state, ok := h.loadState(c, userID, orgName)
+	if !ok {
+		return
+	}
+
+	rendered := state.renderEmails(&desired)
+	if err := validateSpec(&desired, rendered); err != nil {
+		var fieldErrs validation.Errors
+		if !errors.As(err, &fieldErrs) {
+			err = apierror.Validation(err.Error())
+		}
+		apierror.Abort(c, err)
+		return
+	}
+
+	plan, err := spec.Plan(state.spec(), &desired)
+	if err != nil {
+		apierror.Abort(c, apierror.Validation(err.Error()))
+		return
+	}
+	// the changes are checked like the requests that make them one by one, so a plan is only applied if all of
+	// them are valid
+	if err := state.checkPlan(plan, &desired, rendered); err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	if c.Query("apply") != "true" {
+		// plans don't change anything
+		skipAudit(c)
+		respondSpec(c, http.StatusOK, plan)
+		return
+	}
+
+	desiredUnits := map[string]types.UnitSpec{}
+	for _, unit := range desired.Units {
+		desiredUnits[unit.Name] = unit
+	}
+	desiredAccounts := map[string]types.AccountSpec{}
+	for _, account := range desired.Accounts {
+		desiredAccounts[account.Name] = account
+	}
+
+	for i, change := range plan.Changes {
+		if err := h.applyChange(userID, orgName, change, &desired, desiredUnits, desiredAccounts); err != nil {
+			apierror.Abort(c, applyError(change, err).With("plan", plan))
+			return
+		}
+		plan.Changes[i].Applied = true
+	}
+
+	plan.Applied = true
+	respondSpec(c, http.StatusOK, plan)
+}
+
+// applyError is the error of a change that failed to apply
+func applyError(change types.SpecChange, err error) *apierror.Error {
+	if db.IsConditionalCheckFailed(err) {
+		return apierror.PreconditionFailed(fmt.Sprintf("%s '%s' was modified concurrently", change.Kind, change.Name))
+	}
+	if errors.Is(err, db.ErrEmailTaken) {
+		// e.g. when accounts swap their emails, the second email is still taken when the first account changes
+		return apierror.Conflict(fmt.Sprintf("The email of %s '%s' is used by another account", change.Kind, change.Name))
+	}
+	return apierror.From(fmt.Errorf("failed to %s %s '%s': %w", change.Action, change.Kind, change.Name, err))
+}
+
+func (h *SpecHandler) applyChange(userID string, orgName string, change types.SpecChange, desired *types.OrgSpec, units map[string]types.UnitSpec, accounts map[string]types.AccountSpec) error {
+	switch change.Kind {
+	case types.SpecBaseline:
+		// the baselines of an org are stored together, so every baseline change stores all desired baselines
+		return h.orgDb.UpdateBaselines(userID, orgName, desired.Baselines)
+	case types.SpecUnit:
+		unit := units[change.Name]
+		switch change.Action {
+		case types.SpecCreate:
+			_, err := h.unitDb.PutItem(userID, orgName, &types.OrganizationalUnit{Name: unit.Name, Parent: unit.Parent, Tags: unit.Tags})
+			return err
+		case types.SpecUpdate:
+			return h.unitDb.UpdateTags(userID, orgName, unit.Name, unit.Tags)
+		case types.SpecDelete:
+			return h.unitDb.DeleteItem(userID, orgName, change.Name)
+		}
+	case types.SpecAccount:
+		account := accounts[change.Name]
+		switch change.Action {
+		case types.SpecCreate:
+			_, err := h.accountDb.PutItem(userID, orgName, &types.Account{
+				AccountName: account.Name,
+				Email:       account.Email,
+				Unit:        account.Unit,
+				ParentID:    account.ParentID,
+				Tags:        account.Tags,
+				Status:      types.Pending,
+			})
+			return err
+		case types.SpecUpdate:
+			version, existing, err := h.accountDb.GetItemWithVersion(userID, orgName, account.Name, true)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				return fmt.Errorf("account does not exist anymore")
+			}
+			if reason := closureConflict(existing, change.Action); reason != "" {
+				return apierror.Conflict(reason)
+			}
+			return h.accountDb.UpdateDesiredState(userID, orgName, account.Name, version, existing.Email, &types.Account{Email: account.Email, Tags: account.Tags})
+		case types.SpecMove:
+			version, existing, err := h.accountDb.GetItemWithVersion(userID, orgName, account.Name, true)
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				return fmt.Errorf("account does not exist anymore")
+			}
+			if reason := closureConflict(existing, change.Action); reason != "" {
+				return apierror.Conflict(reason)
+			}
+			return h.accountDb.MoveParent(userID, orgName, account.Name, version, types.ParentMove{
+				From:     existing.ParentID,
+				To:       account.ParentID,
+				FromUnit: existing.Unit,
+				ToUnit:   account.Unit,
+				MovedAt:  time.Now().UTC(),
+			})
+		}
+	}
+	return fmt.Errorf("unsupported change")
+}
+
+// orgState is the current state of an org that specs are planned against. Policies aren't part of specs, but they
+// keep the units they are attached to from being deleted
+type orgState struct {
+	org      *types.Organization
+	units    []*types.OrganizationalUnit
+	accounts []*types.Account
+	policies []*types.ServiceControlPolicy
+}
+
+// loadState reads the current state of an org and responds with an error if that's not possible
+func (h *SpecHandler) loadState(c *gin.Context, userID string, orgName string) (*orgState, bool) {
+	org, err := h.orgDb.GetItem(userID, orgName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return nil, false
+	}
+	if org == nil {
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return nil, false
+	}
+
+	units, err := h.unitDb.ListItems(userID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return nil, false
+	}
+	accounts, err := h.accountDb.ListItems(userID, orgName, "")
+	if err != nil {
+		apierror.Abort(c, err)
+		return nil, false
+	}
+	policies, err := h.policiesDb.ListItems(userID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return nil, false
+	}
+
+	return &orgState{org: org, units: units, accounts: accounts, policies: policies}, true
+}
+
+// renderEmails renders the emails of new accounts without one from the email template of the org, like accounts
+// created one by one get them. It returns the rendered emails by account name
+func (s *orgState) renderEmails(desired *types.OrgSpec) map[string]string {
+	rendered := map[string]string{}
+	if s.org.EmailTemplate == "" {
+		return rendered
+	}
+	for i, account := range desired.Accounts {
+		exists := slices.ContainsFunc(s.accounts, func(existing *types.Account) bool { return existing.AccountName == account.Name })
+		if account.Email == "" && !exists {
+			desired.Accounts[i].Email = s.org.AccountEmail(account.Name)
+			rendered[account.Name] = desired.Accounts[i].Email
+		}
+	}
+	return rendered
+}
+
+// spec exports the current state as a spec
+func (s *orgState) spec() *types.OrgSpec {
+	return spec.Export(s.org, s.units, s.accounts)
+}
+
+// checkPlan checks the changes of a plan like the requests that make them one by one: accounts against the naming
+// rules of the org, the emails of other accounts and their closure, and deleted units against everything that
+// references them. The changes are checked in the order they are applied, each against the units and accounts the
+// changes before it leave. Emails in rendered were rendered from the email template of the org
+func (s *orgState) checkPlan(plan *types.SpecPlan, desired *types.OrgSpec, rendered map[string]string) error {
+	fields := map[string]string{}
+	specs := map[string]types.AccountSpec{}
+	for i, account := range desired.Accounts {
+		fields[account.Name] = fmt.Sprintf("accounts[%d]", i)
+		specs[account.Name] = account
+	}
+	units := slices.Clone(s.units)
+	accounts := slices.Clone(s.accounts)
+	find := func(name string) int {
+		return slices.IndexFunc(accounts, func(account *types.Account) bool { return account.AccountName == name })
+	}
+	// like the email items of accounts, which are taken by creates and updates
+	checkEmail := func(errs *validation.Errors, account *types.Account) {
+		email := validation.NormalizeEmail(account.Email)
+		for _, other := range accounts {
+			if other.AccountName != account.AccountName && validation.NormalizeEmail(other.Email) == email {
+				errs.Add("email", "is already used by account '%s'", other.AccountName)
+				return
+			}
+		}
+	}
+
+	// the first change that conflicts with the state it's applied to is reported
+	var errs validation.Errors
+	var conflict error
+	conflicts := func(reason string, change types.SpecChange) {
+		if reason != "" && conflict == nil {
+			conflict = apierror.Conflict(fmt.Sprintf("Cannot %s %s '%s': %s", change.Action, change.Kind, change.Name, reason))
+		}
+	}
+	for _, change := range plan.Changes {
+		if change.Kind == types.SpecUnit && change.Action == types.SpecDelete {
+			units = slices.DeleteFunc(units, func(unit *types.OrganizationalUnit) bool { return unit.Name == change.Name })
+			conflicts(unitReference(change.Name, units, accounts, s.policies), change)
+		}
+		if change.Kind != types.SpecAccount {
+			continue
+		}
+		if change.Action == types.SpecDelete {
+			// deleting the item would leave the AWS account and its stack behind, they are removed by the closure
+			return apierror.Validation(fmt.Sprintf("Account '%s' is missing from the spec. Accounts are removed by closing them with POST /organizations/%s/accounts/%s/close", change.Name, s.org.OrgName, change.Name))
+		}
+		account := specs[change.Name]
+		if change.Action != types.SpecCreate {
+			conflicts(closureConflict(accounts[find(change.Name)], change.Action), change)
+		}
+		var changeErrs validation.Errors
+		switch change.Action {
+		case types.SpecCreate:
+			created := &types.Account{
+				AccountName: account.Name,
+				Email:       account.Email,
+				Unit:        account.Unit,
+				ParentID:    account.ParentID,
+				Tags:        account.Tags,
+				Status:      types.Pending,
+			}
+			checkEmail(&changeErrs, created)
+			checkNamingRules(&changeErrs, s.org.NamingRules, units, accounts, *created, false)
+			accounts = append(accounts, created)
+		case types.SpecUpdate:
+			i := find(change.Name)
+			updated := *accounts[i]
+			updated.Email = account.Email
+			updated.Tags = account.Tags
+			checkEmail(&changeErrs, &updated)
+			accounts[i] = &updated
+		case types.SpecMove:
+			i := find(change.Name)
+			moved := *accounts[i]
+			moved.Unit = account.Unit
+			moved.ParentID = account.ParentID
+			checkNamingRules(&changeErrs, s.org.NamingRules, units, accounts, moved, true)
+			accounts[i] = &moved
+		}
+		errs.Merge(fields[change.Name], renderedEmail(changeErrs, rendered[change.Name]).(validation.Errors))
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+	return conflict
+}
+
+// validateSpec checks the desired state of an org. Emails in rendered were rendered from the email template of the org
+func validateSpec(desired *types.OrgSpec, rendered map[string]string) error {
+	if err := baseline.Validate(desired.Baselines); err != nil {
+		return err
+	}
+	for _, unit := range desired.Units {
+		if err := validateUnit(types.OrganizationalUnit{Name: unit.Name, Parent: unit.Parent}); err != nil {
+			return err
+		}
+	}
+	var errs validation.Errors
+	emails := map[string]string{}
+	for i, account := range desired.Accounts {
+		field := fmt.Sprintf("accounts[%d]", i)
+		accountErrs := accountErrors(types.Account{
+			AccountName: account.Name,
+			Email:       account.Email,
+			Unit:        account.Unit,
+			ParentID:    account.ParentID,
+			Tags:        account.Tags,
+		})
+		email := validation.NormalizeEmail(account.Email)
+		if other, ok := emails[email]; ok && email != "" {
+			accountErrs.Add("email", "is already used by account '%s'", other)
+		}
+		emails[email] = account.Name
+		accountErrs = renderedEmail(accountErrs, rendered[account.Name]).(validation.Errors)
+		errs.Merge(field, accountErrs)
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+	return spec.Validate(desired)
+}
+
+// respondSpec renders specs and plans as YAML for clients that accept it and as JSON otherwise
+func respondSpec(c *gin.Context, status int, obj interface{}) {
+	if isYAML(c.GetHeader("Accept")) {
+		c.YAML(status, obj)
+		return
+	}
+	c.JSON(status, obj)
+}
+
+// isYAML matches both application/yaml and the older application/x-yaml
+func isYAML(mimeType string) bool {
+	return strings.Contains(mimeType, "yaml")
+}
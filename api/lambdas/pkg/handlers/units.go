@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/spec"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/gin-gonic/gin"
+)
+
+type UnitsHandler struct {
+	orgDb      *db.OrganizationDB
+	accountDb  *db.AccountDB
+	unitDb     *db.UnitDB
+	policiesDb *db.PolicyDB
+}
+
+func NewUnitsHandler(orgDb *db.OrganizationDB, accountDb *db.AccountDB, unitDb *db.UnitDB, policiesDb *db.PolicyDB) *UnitsHandler {
+	return &UnitsHandler{orgDb: orgDb, accountDb: accountDb, unitDb: unitDb, policiesDb: policiesDb}
+}
+
+// CreateUnit stores a new organizational unit. The stream processor creates it with the org stack afterwards
+func (h *UnitsHandler) CreateUnit(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := GetOrgOwnerID(c)
+
+	var unit types.OrganizationalUnit
+	if err := c.ShouldBindJSON(&unit); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+
+	if err := validateUnit(unit); err != nil {
+		apierror.Abort(c, apierror.Validation(err.Error()))
+		return
+	}
+
+	org, err := h.orgDb.GetItem(userID, orgName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if org == nil {
+		apierror.Abort(c, apierror.NotFound("Organization does not exist"))
+		return
+	}
+
+	// units can't be re-parented, so checking the depth of the parent chain on creation prevents cycles as well
+	depth := 1
+	for parentName := unit.Parent; parentName != ""; depth++ {
+		parent, err := h.unitDb.GetItem(userID, orgName, parentName, true)
+		if err != nil {
+			apierror.Abort(c, err)
+			return
+		}
+		if parent == nil {
+			apierror.Abort(c, apierror.Validation(fmt.Sprintf("Parent unit '%s' does not exist", parentName)))
+			return
+		}
+		if depth >= spec.MaxUnitDepth {
+			apierror.Abort(c, apierror.Validation(fmt.Sprintf("Organizational units can be nested at most %d levels deep", spec.MaxUnitDepth)))
+			return
+		}
+		parentName = parent.Parent
+	}
+
+	setAuditTarget(c, "units/"+unit.Name)
+	newUnit, err := h.unitDb.PutItem(userID, orgName, &unit)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.AlreadyExists("Organizational unit already exists"))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newUnit)
+}
+
+func (h *UnitsHandler) GetUnit(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	unitName := c.Param("unitName")
+	userID := GetOrgOwnerID(c)
+
+	unit, err := h.unitDb.GetItem(userID, orgName, unitName, false)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if unit == nil {
+		apierror.Abort(c, apierror.NotFound("Organizational unit does not exist"))
+		return
+	}
+
+	c.JSON(http.StatusOK, unit)
+}
+
+func (h *UnitsHandler) ListUnits(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	userID := GetOrgOwnerID(c)
+
+	units, err := h.unitDb.ListItems(userID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"units": units})
+}
+
+// UpdateUnit changes the tags of a unit. Units can't be renamed or moved because accounts reference them by name
+func (h *UnitsHandler) UpdateUnit(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	unitName := c.Param("unitName")
+	userID := GetOrgOwnerID(c)
+
+	var desired types.OrganizationalUnit
+	if err := c.ShouldBindJSON(&desired); err != nil {
+		apierror.Abort(c, apierror.BadRequest(err.Error()))
+		return
+	}
+	if desired.Name != "" && desired.Name != unitName {
+		apierror.Abort(c, apierror.Validation("Organizational units cannot be renamed"))
+		return
+	}
+
+	unit, err := h.unitDb.GetItem(userID, orgName, unitName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if unit == nil {
+		apierror.Abort(c, apierror.NotFound("Organizational unit does not exist"))
+		return
+	}
+	if desired.Parent != unit.Parent {
+		apierror.Abort(c, apierror.Validation("Organizational units cannot be moved"))
+		return
+	}
+
+	err = h.unitDb.UpdateTags(userID, orgName, unitName, desired.Tags)
+	if db.IsConditionalCheckFailed(err) {
+		apierror.Abort(c, apierror.NotFound("Organizational unit does not exist"))
+		return
+	}
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	unit.Tags = desired.Tags
+	unit.Applied = false
+	c.JSON(http.StatusOK, unit)
+}
+
+// DeleteUnit deletes a unit that has neither child units nor accounts and that no policy is attached to
+func (h *UnitsHandler) DeleteUnit(c *gin.Context) {
+	orgName := c.Param("organizationName")
+	unitName := c.Param("unitName")
+	userID := GetOrgOwnerID(c)
+
+	units, err := h.unitDb.ListItems(userID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	accounts, err := h.accountDb.ListItems(userID, orgName, "")
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	policies, err := h.policiesDb.ListItems(userID, orgName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if reason := unitReference(unitName, units, accounts, policies); reason != "" {
+		apierror.Abort(c, apierror.Conflict(reason))
+		return
+	}
+
+	err = h.unitDb.DeleteItem(userID, orgName, unitName)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// unitReference tells why a unit can't be deleted: it has child units, contains accounts, suspended accounts are
+// restored to it or policies are attached to it. It's empty if nothing references the unit
+func unitReference(unitName string, units []*types.OrganizationalUnit, accounts []*types.Account, policies []*types.ServiceControlPolicy) string {
+	for _, unit := range units {
+		if unit.Parent == unitName {
+			return fmt.Sprintf("Organizational unit has child unit '%s'", unit.Name)
+		}
+	}
+	for _, account := range accounts {
+		if account.Unit == unitName {
+			return fmt.Sprintf("Organizational unit contains account '%s'", account.AccountName)
+		}
+		if account.Closure != nil && account.Closure.Unit == unitName {
+			return fmt.Sprintf("Organizational unit is where account '%s' is restored to if its closure is cancelled", account.AccountName)
+		}
+	}
+	for _, policy := range policies {
+		for _, name := range policy.Units {
+			if name == unitName {
+				return fmt.Sprintf("Policy '%s' is attached to the organizational unit", policy.Name)
+			}
+		}
+	}
+	return ""
+}
+
+func validateUnit(unit types.OrganizationalUnit) error {
+	if unit.Name == "" {
+		return fmt.Errorf("organizational unit name is required")
+	}
+	if strings.Contains(unit.Name, "#") || strings.Contains(unit.Parent, "#") {
+		return fmt.Errorf("organizational unit name contains illegal characters")
+	}
+	if unit.Parent == unit.Name {
+		return fmt.Errorf("organizational unit cannot be its own parent")
+	}
+
+	return nil
+}
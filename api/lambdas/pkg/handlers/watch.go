@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/feed"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+type WatchHandler struct {
+	accountDb    *db.AccountDB
+	deploymentDb *db.DeploymentDB
+	feed         feed.Feed
+	// maxDuration ends streams after the given time so they fit into the API Gateway timeout. Zero means no limit
+	maxDuration time.Duration
+	// buffered ends streams as soon as they contain events, because the response only reaches the client once it
+	// is complete
+	buffered bool
+}
+
+func NewWatchHandler(accountDb *db.AccountDB, deploymentDb *db.DeploymentDB, changes feed.Feed, maxDuration time.Duration, buffered bool) *WatchHandler {
+	return &WatchHandler{accountDb: accountDb, deploymentDb: deploymentDb, feed: changes, maxDuration: maxDuration, buffered: buffered}
+}
+
+// WatchAccount streams status changes and deployment log events of an account as Server-Sent Events.
+// Every stream starts with the current status and the log of the latest deployment, minus what the client
+// already received according to its Last-Event-ID.
+//
+// The API Gateway REST proxy buffers the whole response of the lambda, so behind it a watch is a long poll: the
+// stream ends once it has events for the client, or when maxDuration is reached, and clients reconnect with their
+// Last-Event-ID. Only servers that write responses directly, like the local one, stream changes as they happen.
+func (h *WatchHandler) WatchAccount(c *gin.Context) {
+	key := feed.AccountKey{
+		UserID:      GetOrgOwnerID(c),
+		OrgName:     c.Param("organizationName"),
+		AccountName: c.Param("accountName"),
+	}
+
+	ctx := c.Request.Context()
+	if h.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.maxDuration)
+		defer cancel()
+	}
+
+	// subscribe before reading the table so no change falls in between
+	changes, err := h.feed.Subscribe(ctx, key)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+
+	version, account, err := h.accountDb.GetItemWithVersion(key.UserID, key.OrgName, key.AccountName, true)
+	if err != nil {
+		apierror.Abort(c, err)
+		return
+	}
+	if account == nil {
+		apierror.Abort(c, apierror.NotFound("Account does not exist"))
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	cursor := parseLastEventID(c.GetHeader("Last-Event-ID"))
+	sent := 0
+	send := func(change feed.Change) {
+		if cursor.seen(change) {
+			return
+		}
+		cursor.advance(change)
+		sent++
+
+		var event sse.Event
+		if change.Event != nil {
+			event.Event = "deployment-event"
+			event.Data = gin.H{"deploymentID": change.DeploymentID, "event": change.Event}
+		} else {
+			event.Event = "status"
+			event.Data = change.Account.Redacted()
+		}
+		event.Id = cursor.id()
+		c.Render(-1, event)
+		c.Writer.Flush()
+	}
+
+	send(feed.Change{Key: key, Account: account, Version: version})
+	if account.LastDeploymentID != "" {
+		events, err := h.deploymentDb.ListEvents(key.UserID, key.OrgName, key.AccountName, account.LastDeploymentID)
+		if err != nil {
+			// the stream has started, so the error can only be sent as an event
+			apiErr := apierror.From(err)
+			fmt.Printf("watch of account %s failed: %s\n", key.AccountName, err.Error())
+			c.SSEvent("error", gin.H{"code": apiErr.Code, "detail": apiErr.Detail})
+			return
+		}
+		for i := range events {
+			send(feed.Change{Key: key, DeploymentID: account.LastDeploymentID, Event: &events[i]})
+		}
+	}
+	if h.buffered && sent > 0 {
+		return
+	}
+
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			send(change)
+			if h.buffered {
+				drain(changes, send)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain sends the changes that are ready already, e.g. the rest of a batch of deployment events
+func drain(changes <-chan feed.Change, send func(feed.Change)) {
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			send(change)
+		default:
+			return
+		}
+	}
+}
+
+// watchCursor tracks what a client has already received: the version of the last status and the position in the
+// log of the last deployment
+type watchCursor struct {
+	statusVersion int
+	deploymentID  string
+	sequence      int
+}
+
+// id is the SSE event ID of the cursor, e.g. "status:3/<deploymentID>:12". Clients pass it back as Last-Event-ID
+// to resume, so it contains both positions
+func (w *watchCursor) id() string {
+	status := fmt.Sprintf("status:%d", w.statusVersion)
+	if w.deploymentID == "" {
+		return status
+	}
+	return fmt.Sprintf("%s/%s:%d", status, w.deploymentID, w.sequence)
+}
+
+// parseLastEventID restores the cursor from the ID of the last event a client received
+func parseLastEventID(id string) *watchCursor {
+	cursor := &watchCursor{statusVersion: -1}
+	status, deployment := id, ""
+	if sep := strings.Index(id, "/"); sep >= 0 {
+		status, deployment = id[:sep], id[sep+1:]
+	}
+
+	if version, ok := strings.CutPrefix(status, "status:"); ok {
+		if n, err := strconv.Atoi(version); err == nil {
+			cursor.statusVersion = n
+		}
+	}
+	if sep := strings.LastIndex(deployment, ":"); sep > 0 {
+		if n, err := strconv.Atoi(deployment[sep+1:]); err == nil {
+			cursor.deploymentID, cursor.sequence = deployment[:sep], n
+		}
+	}
+	return cursor
+}
+
+func (w *watchCursor) seen(change feed.Change) bool {
+	if change.Event == nil {
+		return change.Version <= w.statusVersion
+	}
+	return change.DeploymentID == w.deploymentID && change.Event.Sequence <= w.sequence
+}
+
+func (w *watchCursor) advance(change feed.Change) {
+	if change.Event == nil {
+		w.statusVersion = change.Version
+	} else {
+		w.deploymentID, w.sequence = change.DeploymentID, change.Event.Sequence
+	}
+}
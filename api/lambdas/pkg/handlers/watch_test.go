@@ -0,0 +1,24 @@
+package handlers
+
+import "testing"
+
+func TestParseLastEventID(t *testing.T) {
+	for _, tc := range []struct {
+		id       string
+		expected watchCursor
+	}{
+		{"", watchCursor{statusVersion: -1}},
+		{"status:3", watchCursor{statusVersion: 3}},
+		{"status:3/8b0f4f9e:12", watchCursor{statusVersion: 3, deploymentID: "8b0f4f9e", sequence: 12}},
+		{"status:x/8b0f4f9e:y", watchCursor{statusVersion: -1}},
+		{"garbage", watchCursor{statusVersion: -1}},
+	} {
+		cursor := parseLastEventID(tc.id)
+		if *cursor != tc.expected {
+			t.Fatalf("expected %q to be parsed as %+v, got %+v", tc.id, tc.expected, *cursor)
+		}
+		if tc.expected.statusVersion >= 0 && cursor.id() != tc.id {
+			t.Fatalf("expected the cursor of %q to have the same ID, got %q", tc.id, cursor.id())
+		}
+	}
+}
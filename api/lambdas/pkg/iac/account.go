@@ -2,77 +2,347 @@ package iac
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sync"
 
+	"github.com/flostadler/festus/api/pkg/esc"
+	"github.com/flostadler/festus/api/pkg/iac/baseline"
 	"github.com/flostadler/festus/api/pkg/types"
-	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/s3"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/organizations"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
 var pulumiCommand auto.PulumiCommand = nil
 
+// pulumiCommandMu guards the installation of the pulumi CLI when stacks are processed concurrently
+var pulumiCommandMu sync.Mutex
+
 const pulumiURL = "https://github.com/pulumi/pulumi/releases/download/v3.113.3/pulumi-v3.113.3-linux-x64.tar.gz"
 
-func CreateAccount(ctx context.Context, account *types.Account, org *types.Organization) (string, error) {
-	// Create a new Pulumi stack
-	if pulumiCommand == nil {
-		if err := installPulumiCLI(ctx); err != nil {
-			println("Failed to install pulumi CLI: %s", err.Error())
-			return "", err
-		}
-		println("Successfully installed pulumi CLI")
-	} else {
-		println("Reusing pre-initialized pulumi CLI installation")
+// Provisioned describes a successful run of the account program
+type Provisioned struct {
+	// AccountID is the ID of the AWS account
+	AccountID string
+	// UpdateVersion is the version of the Pulumi update that ran the program, as listed in the stack's history
+	UpdateVersion int
+}
+
+// CreateAccount runs the account program and streams the condensed engine events into onEvent
+func CreateAccount(ctx context.Context, account *types.Account, org *types.Organization, onEvent EventSink) (*Provisioned, error) {
+	trust, err := oidcTrust(org)
+	if err != nil {
+		return nil, err
+	}
+	s, err := upsertAccountStack(ctx, account, org, trust)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Workspace().InstallPlugin(ctx, "aws", "v6.32.0")
+	if err != nil {
+		return nil, err
 	}
 
-	// TODO: his is just a stand-in for the stack that should create an account. But this is good enough to prove the automation API works in a lambda
-	deployFunc := func(ctx *pulumi.Context) error {
-		// create private S3 bucket
-		_, err := s3.NewBucket(ctx, "s3-website-bucket", &s3.BucketArgs{})
+	return ProvisionAccount(ctx, automationStack{s}, environments(org), account, org, trust, onEvent)
+}
+
+// AccountStack is the stack of an account as far as provisioning is concerned. The automation API implements it,
+// tests run the program against Pulumi mocks instead
+type AccountStack interface {
+	// HasAccount tells whether the stack's state contains the AWS account with the given ID
+	HasAccount(ctx context.Context, accountID string) (bool, error)
+	// Up runs the program and streams the condensed engine events into onEvent
+	Up(ctx context.Context, program pulumi.RunFunc, onEvent EventSink) (*Provisioned, error)
+}
+
+// ProvisionAccount applies the account program to the stack of an account and bootstraps its ESC environments
+// afterwards
+func ProvisionAccount(ctx context.Context, stack AccountStack, envs esc.Environments, account *types.Account, org *types.Organization, trust *OIDCTrust, onEvent EventSink) (*Provisioned, error) {
+	program, err := accountStackProgram(ctx, stack, account, org, trust)
+	if err != nil {
+		return nil, err
+	}
+	provisioned, err := stack.Up(ctx, program, onEvent)
+	if err != nil {
+		return nil, err
+	}
+	if err := BootstrapEnvironments(ctx, envs, org, account, provisioned.AccountID); err != nil {
+		return nil, err
+	}
+	return provisioned, nil
+}
+
+// accountStackProgram returns the account program for the stack. Imported accounts keep the import option until the
+// account is part of the stack. Pulumi requires it to be removed afterwards, and a failed first run has to import the
+// account again instead of creating a new one
+func accountStackProgram(ctx context.Context, stack AccountStack, account *types.Account, org *types.Organization, trust *OIDCTrust) (pulumi.RunFunc, error) {
+	if !account.Imported {
+		return AccountProgram(account, org, trust, ""), nil
+	}
+	imported, err := stack.HasAccount(ctx, account.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if imported {
+		return AccountProgram(account, org, trust, ""), nil
+	}
+	return AccountProgram(account, org, trust, account.AccountID), nil
+}
+
+// automationStack is the AccountStack of the automation API
+type automationStack struct {
+	stack auto.Stack
+}
+
+func (s automationStack) HasAccount(ctx context.Context, accountID string) (bool, error) {
+	return hasAccount(ctx, s.stack, accountID)
+}
+
+func (s automationStack) Up(ctx context.Context, program pulumi.RunFunc, onEvent EventSink) (*Provisioned, error) {
+	s.stack.Workspace().SetProgram(program)
+
+	forwarder := forwardEvents(onEvent)
+	res, err := s.stack.Up(ctx, optup.SuppressProgress(), optup.ProgressStreams(os.Stdout), optup.EventStreams(forwarder.events))
+	forwarder.wait(err)
+	if err != nil {
+		return nil, err
+	}
+
+	accountID, _ := res.Outputs["accountId"].Value.(string)
+	return &Provisioned{AccountID: accountID, UpdateVersion: res.Summary.Version}, nil
+}
+
+// orgManagementRole is the role AWS Organizations creates in new accounts for the management account to assume
+const orgManagementRole = "OrganizationalAccountAccessRole"
+
+// AccountProgram is the inline Pulumi program that manages the resources of an account. Running it again after the
+// desired state of the account changed updates the account in place. The org's baselines are applied inside the
+// account, and with an OIDC trust the roles ESC environments assume are created in it as well. Both are skipped for
+// imported accounts without an access role.
+// With an importID the existing AWS account is adopted instead of creating a new one.
+func AccountProgram(account *types.Account, org *types.Organization, trust *OIDCTrust, importID string) pulumi.RunFunc {
+	return func(ctx *pulumi.Context) error {
+		args := &organizations.AccountArgs{
+			Name:            pulumi.String(account.AccountName),
+			Email:           pulumi.String(account.Email),
+			CloseOnDeletion: pulumi.Bool(true),
+			Tags:            pulumi.ToStringMap(account.Tags),
+		}
+		// the role name can't be read from AWS, so imported accounts would always show a difference
+		if !account.Imported {
+			args.RoleName = pulumi.String(orgManagementRole)
+		}
+		if account.ParentID != "" {
+			args.ParentId = pulumi.String(account.ParentID)
+		}
+
+		var opts []pulumi.ResourceOption
+		if importID != "" {
+			// closeOnDeletion isn't read from AWS either. It's set by the first update after the import
+			opts = append(opts, pulumi.Import(pulumi.ID(importID)), pulumi.IgnoreChanges([]string{"closeOnDeletion"}))
+		}
+
+		acc, err := organizations.NewAccount(ctx, account.AccountName, args, opts...)
 		if err != nil {
 			return err
 		}
+		ctx.Export("accountId", acc.ID())
+
+		role := accessRole(account)
+		if role == "" || (len(org.Baselines) == 0 && trust == nil) {
+			return nil
+		}
 
+		provider, err := accountProvider(ctx, account.AccountName, acc, role)
+		if err != nil {
+			return err
+		}
+		if trust != nil {
+			if err := escBootstrap(ctx, account, org, trust, pulumi.Provider(provider)); err != nil {
+				return err
+			}
+		}
+		return baseline.Run(ctx, org.Baselines, account.AccountName, acc.ID().ToStringOutput(), pulumi.Provider(provider))
+	}
+}
+
+// accessRole returns the role that is assumed to manage resources inside the account. Imported accounts only have one
+// if it was given on import, otherwise their in-account resources are skipped
+func accessRole(account *types.Account) string {
+	if account.Imported {
+		return account.AccessRole
+	}
+	return orgManagementRole
+}
+
+// accountProvider creates an AWS provider that manages resources inside the account by assuming the given role. It
+// uses the credentials and region the stack is configured with.
+func accountProvider(ctx *pulumi.Context, name string, acc *organizations.Account, role string) (*aws.Provider, error) {
+	cfg := config.New(ctx, "aws")
+	return aws.NewProvider(ctx, name, &aws.ProviderArgs{
+		Region:            optionalString(cfg.Get("region")),
+		AccessKey:         optionalString(cfg.Get("accessKey")),
+		SecretKey:         optionalString(cfg.Get("secretKey")),
+		Token:             optionalString(cfg.Get("token")),
+		AllowedAccountIds: pulumi.StringArray{acc.ID().ToStringOutput()},
+		AssumeRole: &aws.ProviderAssumeRoleArgs{
+			RoleArn: pulumi.Sprintf("arn:aws:iam::%s:role/%s", acc.ID(), role),
+		},
+	})
+}
+
+// optionalString leaves empty config values unset so that the provider falls back to its defaults
+func optionalString(value string) pulumi.StringPtrInput {
+	if value == "" {
 		return nil
 	}
+	return pulumi.String(value)
+}
+
+// UpsertAccountStack creates or selects the stack of an account in the org's backend and applies its configuration.
+// Accounts without credentials of their own, e.g. accounts created from an org spec, use the org's credentials.
+func UpsertAccountStack(ctx context.Context, account *types.Account, org *types.Organization) (auto.Stack, error) {
+	trust, err := oidcTrust(org)
+	if err != nil {
+		return auto.Stack{}, err
+	}
+	s, err := upsertAccountStack(ctx, account, org, trust)
+	if err != nil {
+		return auto.Stack{}, err
+	}
+	program, err := accountStackProgram(ctx, automationStack{s}, account, org, trust)
+	if err != nil {
+		return auto.Stack{}, err
+	}
+	s.Workspace().SetProgram(program)
+	return s, nil
+}
+
+// upsertAccountStack creates or selects the stack of an account without choosing between importing and creating the
+// AWS account yet
+func upsertAccountStack(ctx context.Context, account *types.Account, org *types.Organization, trust *OIDCTrust) (auto.Stack, error) {
+	creds := awsCredentials{
+		accessKey:    account.AwsAccessKey,
+		secretKey:    account.AwsSecretKey,
+		sessionToken: account.AwsSessionToken,
+	}
+	if creds.accessKey == "" {
+		creds = awsCredentials{
+			accessKey:    org.AwsAccessKey,
+			secretKey:    org.AwsSecretKey,
+			sessionToken: org.AwsSessionToken,
+		}
+	}
+	return upsertStack(ctx, org, account.AccountName, AccountProgram(account, org, trust, ""), creds)
+}
+
+// hasAccount tells whether the stack's state contains the AWS account with the given ID
+func hasAccount(ctx context.Context, s auto.Stack, accountID string) (bool, error) {
+	state, err := s.Export(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(state.Deployment) == 0 {
+		return false, nil
+	}
+
+	var deployment apitype.DeploymentV3
+	if err := json.Unmarshal(state.Deployment, &deployment); err != nil {
+		return false, err
+	}
+	for _, r := range deployment.Resources {
+		if r.Type == "aws:organizations/account:Account" && string(r.ID) == accountID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// upsertStack creates or selects a stack of the org's project in the org's backend and configures the AWS provider
+func upsertStack(ctx context.Context, org *types.Organization, stackName string, program pulumi.RunFunc, creds awsCredentials) (auto.Stack, error) {
+	if err := ensurePulumiCLI(ctx); err != nil {
+		return auto.Stack{}, err
+	}
 
 	workdir, err := os.MkdirTemp("", "pulumi")
 	if err != nil {
-		return "", err
+		return auto.Stack{}, err
 	}
 	println("Created temporary directory: " + workdir)
 
-	s, err := auto.UpsertStackInlineSource(ctx, account.AccountName, org.OrgName, deployFunc, auto.EnvVars(map[string]string{
-		"PULUMI_ACCESS_TOKEN": org.PulumiAccessToken,
-		// "PULUMI_BACKEND_URL": "https://app.pulumi.com/flostadler",
-	}), auto.Pulumi(pulumiCommand), auto.WorkDir(workdir), auto.PulumiHome("/tmp/.pulumi"))
-	s.SetConfig(ctx, "aws:region", auto.ConfigValue{Value: "us-west-2"})
-	s.SetConfig(ctx, "aws:accessKey", auto.ConfigValue{Value: account.AwsAccessKey})
-	s.SetConfig(ctx, "aws:secretKey", auto.ConfigValue{Value: account.AwsSecretKey})
-	s.SetConfig(ctx, "aws:token", auto.ConfigValue{Value: account.AwsSessionToken})
+	opts, err := backendOptions(org)
 	if err != nil {
-		return "", err
+		return auto.Stack{}, err
 	}
+	opts = append(opts, auto.Pulumi(pulumiCommand), auto.WorkDir(workdir), auto.PulumiHome(pulumiHome()))
 
-	w := s.Workspace()
-
-	err = w.InstallPlugin(ctx, "aws", "v6.32.0")
+	s, err := auto.UpsertStackInlineSource(ctx, stackName, org.OrgName, program, opts...)
 	if err != nil {
-		return "", err
+		return auto.Stack{}, err
 	}
 
-	res, err := s.Up(ctx, optup.SuppressProgress(), optup.ProgressStreams(os.Stdout))
+	err = s.SetAllConfig(ctx, auto.ConfigMap{
+		"aws:region":    auto.ConfigValue{Value: "us-west-2"},
+		"aws:accessKey": auto.ConfigValue{Value: creds.accessKey},
+		"aws:secretKey": auto.ConfigValue{Value: creds.secretKey},
+		"aws:token":     auto.ConfigValue{Value: creds.sessionToken},
+	})
 	if err != nil {
-		return "", err
+		return auto.Stack{}, err
+	}
+
+	return s, nil
+}
+
+// ensurePulumiCLI uses a pulumi CLI from the PATH if there is one and otherwise downloads it (e.g. inside a lambda)
+func ensurePulumiCLI(ctx context.Context) error {
+	pulumiCommandMu.Lock()
+	defer pulumiCommandMu.Unlock()
+
+	if pulumiCommand != nil {
+		println("Reusing pre-initialized pulumi CLI installation")
+		return nil
+	}
+
+	if _, err := exec.LookPath("pulumi"); err == nil {
+		cmd, err := auto.NewPulumiCommand(nil)
+		if err == nil {
+			pulumiCommand = cmd
+			println("Using pulumi CLI from PATH")
+			return nil
+		}
 	}
 
-	return res.StdOut, nil
+	if err := installPulumiCLI(ctx); err != nil {
+		println("Failed to install pulumi CLI: %s", err.Error())
+		return err
+	}
+	println("Successfully installed pulumi CLI")
+	return nil
+}
+
+func pulumiHome() string {
+	if home := os.Getenv("PULUMI_HOME"); home != "" {
+		return home
+	}
+	return "/tmp/.pulumi"
 }
 
 func downloadFile(filepath string, url string) (err error) {
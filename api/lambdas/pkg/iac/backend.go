@@ -0,0 +1,94 @@
+package iac
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+)
+
+// backendOptions translates the backend configuration of an org into workspace options.
+// Orgs without an explicit backend use Pulumi Cloud, unless PULUMI_BACKEND_URL overrides it (e.g. file:// for local runs).
+func backendOptions(org *types.Organization) ([]auto.LocalWorkspaceOption, error) {
+	envVars := map[string]string{}
+	var opts []auto.LocalWorkspaceOption
+
+	switch org.Backend.Type {
+	case "":
+		if backendURL := os.Getenv("PULUMI_BACKEND_URL"); backendURL != "" {
+			envVars["PULUMI_BACKEND_URL"] = backendURL
+		} else {
+			envVars["PULUMI_ACCESS_TOKEN"] = org.PulumiAccessToken
+		}
+	case types.PulumiCloudBackend:
+		envVars["PULUMI_ACCESS_TOKEN"] = org.PulumiAccessToken
+		if org.Backend.URL != "" {
+			envVars["PULUMI_BACKEND_URL"] = org.Backend.URL
+		}
+	case types.S3Backend, types.AzureBlobBackend, types.GCSBackend, types.LocalBackend:
+		envVars["PULUMI_BACKEND_URL"] = org.Backend.URL
+	default:
+		return nil, fmt.Errorf("unknown backend type: %s", org.Backend.Type)
+	}
+
+	secrets := org.Backend.SecretsProvider
+	switch secrets.Type {
+	case "", types.DefaultSecretsProvider:
+	case types.PassphraseSecretsProvider:
+		envVars["PULUMI_CONFIG_PASSPHRASE"] = secrets.Passphrase
+		opts = append(opts, auto.SecretsProvider("passphrase"))
+	case types.AwsKmsSecretsProvider, types.AzureKeyVaultSecretsProvider, types.GcpKmsSecretsProvider:
+		url, err := SecretsProviderURL(secrets)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, auto.SecretsProvider(url))
+	default:
+		return nil, fmt.Errorf("unknown secrets provider: %s", secrets.Type)
+	}
+
+	return append(opts, auto.EnvVars(envVars)), nil
+}
+
+var (
+	awsKmsARN        = regexp.MustCompile(`^arn:aws[a-z-]*:kms:([a-z0-9-]+):[0-9]{12}:(key|alias)/.+$`)
+	awsRegion        = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]$`)
+	azureKeyVaultKey = regexp.MustCompile(`^[a-z0-9-]+\.vault\.[a-z0-9.]+/keys/[A-Za-z0-9-]+(/[a-f0-9]+)?$`)
+	gcpKmsKey        = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+)
+
+// SecretsProviderURL builds the URL of a KMS secrets provider from its key, e.g.
+// awskms:///arn:aws:kms:us-west-2:111122223333:key/1234?region=us-west-2 for the ARN of an AWS key.
+// Keys that don't have the form the provider expects are rejected
+func SecretsProviderURL(secrets types.SecretsProvider) (string, error) {
+	switch secrets.Type {
+	case types.AwsKmsSecretsProvider:
+		if match := awsKmsARN.FindStringSubmatch(secrets.KeyID); match != nil {
+			// ARNs are part of the path, the region is the one of the key
+			return fmt.Sprintf("awskms:///%s?region=%s", secrets.KeyID, match[1]), nil
+		}
+		if strings.HasPrefix(secrets.KeyID, "arn:") || strings.Contains(secrets.KeyID, "?") || secrets.KeyID == "" {
+			return "", fmt.Errorf("keyID of secrets provider 'awskms' must be a key ID, an alias/ name or the ARN of a key or alias")
+		}
+		if !awsRegion.MatchString(secrets.Region) {
+			return "", fmt.Errorf("secrets provider 'awskms' requires the region of the key unless keyID is an ARN")
+		}
+		return fmt.Sprintf("awskms://%s?region=%s", secrets.KeyID, secrets.Region), nil
+	case types.AzureKeyVaultSecretsProvider:
+		key := strings.TrimPrefix(secrets.KeyID, "https://")
+		if !azureKeyVaultKey.MatchString(key) {
+			return "", fmt.Errorf("keyID of secrets provider 'azurekeyvault' must be a key URL like https://<vault>.vault.azure.net/keys/<key>")
+		}
+		return "azurekeyvault://" + key, nil
+	case types.GcpKmsSecretsProvider:
+		if !gcpKmsKey.MatchString(secrets.KeyID) {
+			return "", fmt.Errorf("keyID of secrets provider 'gcpkms' must be a key name like projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>")
+		}
+		return "gcpkms://" + secrets.KeyID, nil
+	default:
+		return "", fmt.Errorf("secrets provider '%s' has no URL", secrets.Type)
+	}
+}
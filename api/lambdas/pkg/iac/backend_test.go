@@ -0,0 +1,58 @@
+package iac
+
+import (
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestSecretsProviderURL(t *testing.T) {
+	for _, tc := range []struct {
+		secrets types.SecretsProvider
+		url     string
+	}{
+		{
+			types.SecretsProvider{Type: types.AwsKmsSecretsProvider, KeyID: "arn:aws:kms:us-west-2:111122223333:key/1234"},
+			"awskms:///arn:aws:kms:us-west-2:111122223333:key/1234?region=us-west-2",
+		},
+		{
+			types.SecretsProvider{Type: types.AwsKmsSecretsProvider, KeyID: "arn:aws-us-gov:kms:us-gov-west-1:111122223333:alias/festus"},
+			"awskms:///arn:aws-us-gov:kms:us-gov-west-1:111122223333:alias/festus?region=us-gov-west-1",
+		},
+		{
+			types.SecretsProvider{Type: types.AwsKmsSecretsProvider, KeyID: "alias/festus", Region: "eu-central-1"},
+			"awskms://alias/festus?region=eu-central-1",
+		},
+		{
+			types.SecretsProvider{Type: types.AzureKeyVaultSecretsProvider, KeyID: "https://festus.vault.azure.net/keys/state"},
+			"azurekeyvault://festus.vault.azure.net/keys/state",
+		},
+		{
+			types.SecretsProvider{Type: types.GcpKmsSecretsProvider, KeyID: "projects/acme/locations/global/keyRings/festus/cryptoKeys/state"},
+			"gcpkms://projects/acme/locations/global/keyRings/festus/cryptoKeys/state",
+		},
+	} {
+		url, err := SecretsProviderURL(tc.secrets)
+		if err != nil {
+			t.Fatalf("expected %+v to be valid: %s", tc.secrets, err.Error())
+		}
+		if url != tc.url {
+			t.Fatalf("expected url %s for %+v, got %s", tc.url, tc.secrets, url)
+		}
+	}
+
+	for _, secrets := range []types.SecretsProvider{
+		{Type: types.AwsKmsSecretsProvider},
+		{Type: types.AwsKmsSecretsProvider, KeyID: "alias/festus"},
+		{Type: types.AwsKmsSecretsProvider, KeyID: "alias/festus", Region: "europe"},
+		{Type: types.AwsKmsSecretsProvider, KeyID: "arn:aws:kms:key/1234"},
+		{Type: types.AwsKmsSecretsProvider, KeyID: "alias/festus?region=us-east-1", Region: "eu-central-1"},
+		{Type: types.AzureKeyVaultSecretsProvider, KeyID: "state"},
+		{Type: types.GcpKmsSecretsProvider, KeyID: "cryptoKeys/state"},
+		{Type: types.PassphraseSecretsProvider},
+	} {
+		if url, err := SecretsProviderURL(secrets); err == nil {
+			t.Fatalf("expected %+v to be rejected, got %s", secrets, url)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package baseline is the registry of components that are applied inside every account of an org, e.g. to meet
+// security requirements. Orgs choose the baselines they want and set their parameters.
+package baseline
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Args are passed to a baseline when it's applied to an account
+type Args struct {
+	// Name prefixes the names of the baseline's resources
+	Name      string
+	AccountID pulumi.StringInput
+	// Params contains all parameters of the baseline with defaults applied
+	Params map[string]string
+}
+
+// Apply creates the resources of a baseline. opts contain the provider for the target account
+type Apply func(ctx *pulumi.Context, args Args, opts ...pulumi.ResourceOption) error
+
+type Param struct {
+	Description string `json:"description"`
+	Default     string `json:"default,omitempty"`
+	Required    bool   `json:"required"`
+	// Validate checks the value of the parameter, if set
+	Validate func(value string) error `json:"-"`
+}
+
+type Definition struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Params      map[string]Param `json:"params,omitempty"`
+	Apply       Apply            `json:"-"`
+}
+
+var registry = map[string]Definition{}
+
+// register adds a baseline to the registry. It's called by the init functions of the baselines
+func register(def Definition) {
+	if _, ok := registry[def.Name]; ok {
+		panic(fmt.Sprintf("baseline %s is registered twice", def.Name))
+	}
+	registry[def.Name] = def
+}
+
+func Lookup(name string) (Definition, bool) {
+	def, ok := registry[name]
+	return def, ok
+}
+
+// List returns all registered baselines ordered by name
+func List() []Definition {
+	defs := make([]Definition, 0, len(registry))
+	for _, def := range registry {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// Validate checks that every selected baseline exists at most once and that its parameters are known and valid
+func Validate(configs []types.BaselineConfig) error {
+	seen := map[string]bool{}
+	for _, config := range configs {
+		def, ok := registry[config.Name]
+		if !ok {
+			return fmt.Errorf("unknown baseline '%s'", config.Name)
+		}
+		if seen[config.Name] {
+			return fmt.Errorf("baseline '%s' is selected more than once", config.Name)
+		}
+		seen[config.Name] = true
+
+		for name, value := range config.Params {
+			param, ok := def.Params[name]
+			if !ok {
+				return fmt.Errorf("baseline '%s' has no parameter '%s'", config.Name, name)
+			}
+			if param.Validate != nil {
+				if err := param.Validate(value); err != nil {
+					return fmt.Errorf("parameter '%s' of baseline '%s' is invalid: %s", name, config.Name, err.Error())
+				}
+			}
+		}
+		for name, param := range def.Params {
+			if param.Required && config.Params[name] == "" {
+				return fmt.Errorf("baseline '%s' requires parameter '%s'", config.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Run applies the selected baselines in order. The configs have to be validated before
+func Run(ctx *pulumi.Context, configs []types.BaselineConfig, name string, accountID pulumi.StringInput, opts ...pulumi.ResourceOption) error {
+	for _, config := range configs {
+		def, ok := registry[config.Name]
+		if !ok {
+			return fmt.Errorf("unknown baseline '%s'", config.Name)
+		}
+
+		params := map[string]string{}
+		for paramName, param := range def.Params {
+			params[paramName] = param.Default
+		}
+		for paramName, value := range config.Params {
+			params[paramName] = value
+		}
+
+		args := Args{Name: fmt.Sprintf("%s-%s", name, def.Name), AccountID: accountID, Params: params}
+		if err := def.Apply(ctx, args, opts...); err != nil {
+			return fmt.Errorf("failed to apply baseline '%s': %w", def.Name, err)
+		}
+	}
+	return nil
+}
+
+func positiveInt(value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		return fmt.Errorf("'%s' is not a positive integer", value)
+	}
+	return nil
+}
+
+func positiveFloat(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 {
+		return fmt.Errorf("'%s' is not a positive number", value)
+	}
+	return nil
+}
+
+func boolean(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("'%s' is not a boolean", value)
+	}
+	return nil
+}
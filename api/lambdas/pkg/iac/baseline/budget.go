@@ -0,0 +1,42 @@
+package baseline
+
+import (
+	"strconv"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/budgets"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func init() {
+	register(Definition{
+		Name:        "budget",
+		Description: "Notifies by email when the monthly cost of the account exceeds a share of its budget",
+		Params: map[string]Param{
+			"limit":     {Description: "Monthly budget in USD", Required: true, Validate: positiveFloat},
+			"email":     {Description: "Address that receives the notifications", Required: true},
+			"threshold": {Description: "Percentage of the budget that triggers a notification", Default: "80", Validate: positiveFloat},
+		},
+		Apply: applyBudget,
+	})
+}
+
+func applyBudget(ctx *pulumi.Context, args Args, opts ...pulumi.ResourceOption) error {
+	threshold, _ := strconv.ParseFloat(args.Params["threshold"], 64)
+
+	_, err := budgets.NewBudget(ctx, args.Name, &budgets.BudgetArgs{
+		BudgetType:  pulumi.String("COST"),
+		LimitAmount: pulumi.String(args.Params["limit"]),
+		LimitUnit:   pulumi.String("USD"),
+		TimeUnit:    pulumi.String("MONTHLY"),
+		Notifications: budgets.BudgetNotificationArray{
+			&budgets.BudgetNotificationArgs{
+				ComparisonOperator:       pulumi.String("GREATER_THAN"),
+				NotificationType:         pulumi.String("ACTUAL"),
+				Threshold:                pulumi.Float64(threshold),
+				ThresholdType:            pulumi.String("PERCENTAGE"),
+				SubscriberEmailAddresses: pulumi.StringArray{pulumi.String(args.Params["email"])},
+			},
+		},
+	}, opts...)
+	return err
+}
@@ -0,0 +1,72 @@
+package baseline
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/cloudtrail"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func init() {
+	register(Definition{
+		Name:        "cloudtrail",
+		Description: "Records the API activity of the account in a CloudTrail trail that logs to an S3 bucket in the account",
+		Params: map[string]Param{
+			"multiRegion": {Description: "Whether the trail records all regions", Default: "true", Validate: boolean},
+		},
+		Apply: applyCloudTrail,
+	})
+}
+
+func applyCloudTrail(ctx *pulumi.Context, args Args, opts ...pulumi.ResourceOption) error {
+	bucket, err := s3.NewBucketV2(ctx, args.Name, &s3.BucketV2Args{}, opts...)
+	if err != nil {
+		return err
+	}
+
+	// CloudTrail needs to read the ACL of the bucket and write the logs of the account
+	policy, err := s3.NewBucketPolicy(ctx, args.Name, &s3.BucketPolicyArgs{
+		Bucket: bucket.ID(),
+		Policy: pulumi.All(bucket.Arn, args.AccountID).ApplyT(func(values []interface{}) (string, error) {
+			bucketArn, accountID := values[0].(string), values[1].(string)
+			principal := map[string]string{"Service": "cloudtrail.amazonaws.com"}
+			doc, err := json.Marshal(map[string]interface{}{
+				"Version": "2012-10-17",
+				"Statement": []map[string]interface{}{
+					{
+						"Sid":       "AWSCloudTrailAclCheck",
+						"Effect":    "Allow",
+						"Principal": principal,
+						"Action":    "s3:GetBucketAcl",
+						"Resource":  bucketArn,
+					},
+					{
+						"Sid":       "AWSCloudTrailWrite",
+						"Effect":    "Allow",
+						"Principal": principal,
+						"Action":    "s3:PutObject",
+						"Resource":  bucketArn + "/AWSLogs/" + accountID + "/*",
+						"Condition": map[string]interface{}{
+							"StringEquals": map[string]string{"s3:x-amz-acl": "bucket-owner-full-control"},
+						},
+					},
+				},
+			})
+			return string(doc), err
+		}).(pulumi.StringOutput),
+	}, opts...)
+	if err != nil {
+		return err
+	}
+
+	multiRegion, _ := strconv.ParseBool(args.Params["multiRegion"])
+	_, err = cloudtrail.NewTrail(ctx, args.Name, &cloudtrail.TrailArgs{
+		S3BucketName:               bucket.ID(),
+		IsMultiRegionTrail:         pulumi.Bool(multiRegion),
+		IncludeGlobalServiceEvents: pulumi.Bool(true),
+		EnableLogFileValidation:    pulumi.Bool(true),
+	}, append(opts, pulumi.DependsOn([]pulumi.Resource{policy}))...)
+	return err
+}
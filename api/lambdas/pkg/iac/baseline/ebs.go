@@ -0,0 +1,21 @@
+package baseline
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/ebs"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func init() {
+	register(Definition{
+		Name:        "ebs-encryption",
+		Description: "Encrypts new EBS volumes of the account by default",
+		Apply:       applyEBSEncryption,
+	})
+}
+
+func applyEBSEncryption(ctx *pulumi.Context, args Args, opts ...pulumi.ResourceOption) error {
+	_, err := ebs.NewEncryptionByDefault(ctx, args.Name, &ebs.EncryptionByDefaultArgs{
+		Enabled: pulumi.Bool(true),
+	}, opts...)
+	return err
+}
@@ -0,0 +1,39 @@
+package baseline
+
+import (
+	"strconv"
+
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func init() {
+	register(Definition{
+		Name:        "iam-password-policy",
+		Description: "Enforces a strong password policy for the IAM users of the account",
+		Params: map[string]Param{
+			"minimumLength":   {Description: "Minimum length of passwords", Default: "14", Validate: positiveInt},
+			"maxAge":          {Description: "Days after which passwords expire", Default: "90", Validate: positiveInt},
+			"reusePrevention": {Description: "Number of previous passwords that can't be reused", Default: "24", Validate: positiveInt},
+		},
+		Apply: applyIAMPasswordPolicy,
+	})
+}
+
+func applyIAMPasswordPolicy(ctx *pulumi.Context, args Args, opts ...pulumi.ResourceOption) error {
+	minimumLength, _ := strconv.Atoi(args.Params["minimumLength"])
+	maxAge, _ := strconv.Atoi(args.Params["maxAge"])
+	reusePrevention, _ := strconv.Atoi(args.Params["reusePrevention"])
+
+	_, err := iam.NewAccountPasswordPolicy(ctx, args.Name, &iam.AccountPasswordPolicyArgs{
+		MinimumPasswordLength:      pulumi.Int(minimumLength),
+		MaxPasswordAge:             pulumi.Int(maxAge),
+		PasswordReusePrevention:    pulumi.Int(reusePrevention),
+		RequireLowercaseCharacters: pulumi.Bool(true),
+		RequireUppercaseCharacters: pulumi.Bool(true),
+		RequireNumbers:             pulumi.Bool(true),
+		RequireSymbols:             pulumi.Bool(true),
+		AllowUsersToChangePassword: pulumi.Bool(true),
+	}, opts...)
+	return err
+}
@@ -0,0 +1,25 @@
+package baseline
+
+import (
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+func init() {
+	register(Definition{
+		Name:        "s3-public-access-block",
+		Description: "Blocks public access to all S3 buckets of the account",
+		Apply:       applyS3PublicAccessBlock,
+	})
+}
+
+func applyS3PublicAccessBlock(ctx *pulumi.Context, args Args, opts ...pulumi.ResourceOption) error {
+	_, err := s3.NewAccountPublicAccessBlock(ctx, args.Name, &s3.AccountPublicAccessBlockArgs{
+		AccountId:             args.AccountID,
+		BlockPublicAcls:       pulumi.Bool(true),
+		BlockPublicPolicy:     pulumi.Bool(true),
+		IgnorePublicAcls:      pulumi.Bool(true),
+		RestrictPublicBuckets: pulumi.Bool(true),
+	}, opts...)
+	return err
+}
@@ -0,0 +1,30 @@
+package iac
+
+import (
+	"context"
+	"os"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+)
+
+// CloseAccount destroys the stack of an account and removes it. The account resource is created with
+// close-on-deletion, so destroying it closes the AWS account
+func CloseAccount(ctx context.Context, account *types.Account, org *types.Organization) error {
+	s, err := UpsertAccountStack(ctx, account, org)
+	if err != nil {
+		return err
+	}
+
+	err = s.Workspace().InstallPlugin(ctx, "aws", "v6.32.0")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Destroy(ctx, optdestroy.SuppressProgress(), optdestroy.ProgressStreams(os.Stdout))
+	if err != nil {
+		return err
+	}
+
+	return s.Workspace().RemoveStack(ctx, s.Name())
+}
@@ -0,0 +1,42 @@
+package iac
+
+import (
+	"context"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+// DetectDrift runs a preview-only refresh of the account's stack. The returned diff lists the resources whose
+// actual state differs from the recorded state
+func DetectDrift(ctx context.Context, account *types.Account, org *types.Organization) (*types.PreviewDiff, error) {
+	s, err := UpsertAccountStack(ctx, account, org)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Workspace().InstallPlugin(ctx, "aws", "v6.32.0")
+	if err != nil {
+		return nil, err
+	}
+
+	engineEvents, diff := collectDiff(refreshedStep)
+
+	_, err = s.PreviewRefresh(ctx, optrefresh.SuppressProgress(), optrefresh.EventStreams(engineEvents))
+	if err != nil {
+		return nil, err
+	}
+
+	return <-diff, nil
+}
+
+// refreshedStep picks the outcome of refreshing a resource. Refreshes announce every resource with the op
+// "refresh", only the outputs of the step carry what the refresh found: same, update or delete
+func refreshedStep(e events.EngineEvent) *apitype.StepEventMetadata {
+	if e.ResOutputsEvent == nil || e.ResOutputsEvent.Metadata.Op == apitype.OpRefresh {
+		return nil
+	}
+	return &e.ResOutputsEvent.Metadata
+}
@@ -0,0 +1,59 @@
+package iac
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+// refreshEvents is the event log of a preview-only refresh in which the tags of the bucket were changed and the
+// key was deleted outside of Pulumi. The role and the provider are unchanged
+const refreshEvents = `
+{"sequence":0,"timestamp":1,"preludeEvent":{"config":{}}}
+{"sequence":1,"timestamp":1,"resourcePreEvent":{"metadata":{"op":"refresh","urn":"urn:pulumi:dev::acme::pulumi:providers:aws::default","type":"pulumi:providers:aws","provider":""},"planning":true}}
+{"sequence":2,"timestamp":1,"resOutputsEvent":{"metadata":{"op":"same","urn":"urn:pulumi:dev::acme::pulumi:providers:aws::default","type":"pulumi:providers:aws","provider":""},"planning":true}}
+{"sequence":3,"timestamp":1,"resourcePreEvent":{"metadata":{"op":"refresh","urn":"urn:pulumi:dev::acme::aws:s3/bucketV2:BucketV2::logs","type":"aws:s3/bucketV2:BucketV2","provider":"urn:pulumi:dev::acme::pulumi:providers:aws::default::1"},"planning":true}}
+{"sequence":4,"timestamp":1,"resourcePreEvent":{"metadata":{"op":"refresh","urn":"urn:pulumi:dev::acme::aws:iam/role:Role::access","type":"aws:iam/role:Role","provider":"urn:pulumi:dev::acme::pulumi:providers:aws::default::1"},"planning":true}}
+{"sequence":5,"timestamp":1,"resourcePreEvent":{"metadata":{"op":"refresh","urn":"urn:pulumi:dev::acme::aws:kms/key:Key::state","type":"aws:kms/key:Key","provider":"urn:pulumi:dev::acme::pulumi:providers:aws::default::1"},"planning":true}}
+{"sequence":6,"timestamp":1,"resOutputsEvent":{"metadata":{"op":"update","urn":"urn:pulumi:dev::acme::aws:s3/bucketV2:BucketV2::logs","type":"aws:s3/bucketV2:BucketV2","diffs":["tags","tagsAll"],"provider":"urn:pulumi:dev::acme::pulumi:providers:aws::default::1"},"planning":true}}
+{"sequence":7,"timestamp":1,"resOutputsEvent":{"metadata":{"op":"same","urn":"urn:pulumi:dev::acme::aws:iam/role:Role::access","type":"aws:iam/role:Role","provider":"urn:pulumi:dev::acme::pulumi:providers:aws::default::1"},"planning":true}}
+{"sequence":8,"timestamp":1,"resOutputsEvent":{"metadata":{"op":"delete","urn":"urn:pulumi:dev::acme::aws:kms/key:Key::state","type":"aws:kms/key:Key","provider":"urn:pulumi:dev::acme::pulumi:providers:aws::default::1"},"planning":true}}
+{"sequence":9,"timestamp":1,"summaryEvent":{"maybeCorrupt":false,"durationSeconds":2,"resourceChanges":{"delete":1,"same":2,"update":1},"PolicyPacks":{}}}
+`
+
+func replay(t *testing.T, log string, step func(e events.EngineEvent) *apitype.StepEventMetadata) *types.PreviewDiff {
+	engineEvents, diff := collectDiff(step)
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		var e events.EngineEvent
+		if err := json.Unmarshal([]byte(line), &e.EngineEvent); err != nil {
+			t.Fatalf("failed to decode engine event %s: %s", line, err.Error())
+		}
+		engineEvents <- e
+	}
+	close(engineEvents)
+	return <-diff
+}
+
+func TestDriftFromRefreshEvents(t *testing.T) {
+	diff := replay(t, refreshEvents, refreshedStep)
+
+	expected := types.ResourceChanges{Updates: 1, Deletes: 1}
+	if diff.Totals != expected {
+		t.Fatalf("expected totals %+v, got %+v", expected, diff.Totals)
+	}
+	if len(diff.Resources) != 2 || diff.Resources[0].Op != "update" || diff.Resources[1].Op != "delete" {
+		t.Fatalf("expected the bucket to be updated and the key to be deleted, got %+v", diff.Resources)
+	}
+	if strings.Join(diff.Resources[0].Diffs, ",") != "tags,tagsAll" {
+		t.Fatalf("expected the drifted properties of the bucket, got %v", diff.Resources[0].Diffs)
+	}
+
+	// the announced refresh steps don't tell whether anything drifted
+	if diff := replay(t, refreshEvents, plannedStep); len(diff.Resources) != 0 {
+		t.Fatalf("expected the refresh steps to be ignored by previews, got %+v", diff.Resources)
+	}
+}
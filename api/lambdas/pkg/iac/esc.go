@@ -0,0 +1,231 @@
+package iac
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/flostadler/festus/api/pkg/esc"
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// pulumiOIDCURL is the issuer of the OIDC tokens Pulumi Cloud hands to ESC environments and deployments
+const pulumiOIDCURL = "https://api.pulumi.com/oidc"
+
+// OIDCTrust describes the Pulumi OIDC issuer accounts trust. Without it no ESC roles are created
+type OIDCTrust struct {
+	// PulumiOrg is the audience of the tokens
+	PulumiOrg string
+	// Thumbprint is the thumbprint of the issuer's CA certificate
+	Thumbprint string
+}
+
+// escRole is a role inside every account that ESC environments can assume
+type escRole struct {
+	name      string
+	roleName  string
+	policyArn string
+}
+
+var escRoles = []escRole{
+	{name: "admin", roleName: "Admin", policyArn: "arn:aws:iam::aws:policy/AdministratorAccess"},
+	{name: "developer", roleName: "Developer", policyArn: "arn:aws:iam::aws:policy/PowerUserAccess"},
+}
+
+// EnvironmentName is the name of the ESC environment that vends credentials for a role of an account
+func EnvironmentName(org *types.Organization, account *types.Account, role string) string {
+	return fmt.Sprintf("%s-%s-%s", org.OrgName, account.AccountName, role)
+}
+
+// oidcTrust returns the OIDC trust of an org's accounts, or nil if the org doesn't use ESC
+func oidcTrust(org *types.Organization) (*OIDCTrust, error) {
+	if org.PulumiOrg == "" {
+		return nil, nil
+	}
+	thumbprint, err := pulumiOIDCThumbprint()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the thumbprint of %s: %w", pulumiOIDCURL, err)
+	}
+	return &OIDCTrust{PulumiOrg: org.PulumiOrg, Thumbprint: thumbprint}, nil
+}
+
+var (
+	thumbprintMu     sync.Mutex
+	cachedThumbprint string
+)
+
+// pulumiOIDCThumbprint returns the SHA-1 thumbprint of the top CA certificate the issuer presents, as IAM expects it
+func pulumiOIDCThumbprint() (string, error) {
+	thumbprintMu.Lock()
+	defer thumbprintMu.Unlock()
+	if cachedThumbprint != "" {
+		return cachedThumbprint, nil
+	}
+
+	issuer, err := url.Parse(pulumiOIDCURL)
+	if err != nil {
+		return "", err
+	}
+	conn, err := tls.Dial("tcp", issuer.Host+":443", &tls.Config{ServerName: issuer.Hostname()})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("no certificates presented")
+	}
+	sum := sha1.Sum(certs[len(certs)-1].Raw)
+	cachedThumbprint = hex.EncodeToString(sum[:])
+	return cachedThumbprint, nil
+}
+
+// escBootstrap creates the Pulumi OIDC provider inside the account and a role per entry of escRoles that only the
+// account's ESC environments and Pulumi deployments of the org's project can assume
+func escBootstrap(ctx *pulumi.Context, account *types.Account, org *types.Organization, trust *OIDCTrust, opts ...pulumi.ResourceOption) error {
+	provider, err := iam.NewOpenIdConnectProvider(ctx, account.AccountName+"-pulumi-oidc", &iam.OpenIdConnectProviderArgs{
+		Url:             pulumi.String(pulumiOIDCURL),
+		ClientIdLists:   pulumi.StringArray{pulumi.String(trust.PulumiOrg)},
+		ThumbprintLists: pulumi.StringArray{pulumi.String(trust.Thumbprint)},
+	}, append(opts, pulumi.IgnoreChanges([]string{"thumbprintLists"}))...)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range escRoles {
+		_, err := NewEscAssumableIamRole(ctx, fmt.Sprintf("%s-%s", account.AccountName, role.name), &EscAssumableIamRoleArgs{
+			RoleName:        role.roleName,
+			PolicyArn:       role.policyArn,
+			ProviderArn:     provider.Arn,
+			PulumiOrg:       trust.PulumiOrg,
+			EnvironmentName: EnvironmentName(org, account, role.name),
+			ProjectName:     org.OrgName,
+		}, opts...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type EscAssumableIamRoleArgs struct {
+	RoleName  string
+	PolicyArn string
+	// ProviderArn is the ARN of the Pulumi OIDC provider in the account
+	ProviderArn     pulumi.StringInput
+	PulumiOrg       string
+	EnvironmentName string
+	// ProjectName is the Pulumi project whose deployments can assume the role as well
+	ProjectName string
+}
+
+// EscAssumableIamRole is a role with a managed policy that trusts the Pulumi OIDC issuer for one ESC environment
+type EscAssumableIamRole struct {
+	pulumi.ResourceState
+
+	Arn pulumi.StringOutput `pulumi:"arn"`
+}
+
+func NewEscAssumableIamRole(ctx *pulumi.Context, name string, args *EscAssumableIamRoleArgs, opts ...pulumi.ResourceOption) (*EscAssumableIamRole, error) {
+	component := &EscAssumableIamRole{}
+	if err := ctx.RegisterComponentResource("festus:esc:EscAssumableIamRole", name, component, opts...); err != nil {
+		return nil, err
+	}
+
+	trustPolicy := args.ProviderArn.ToStringOutput().ApplyT(func(providerArn string) (string, error) {
+		policy, err := json.Marshal(escTrustPolicy(providerArn, args))
+		return string(policy), err
+	}).(pulumi.StringOutput)
+
+	role, err := iam.NewRole(ctx, name, &iam.RoleArgs{
+		Name:             pulumi.String(args.RoleName),
+		AssumeRolePolicy: trustPolicy,
+	}, pulumi.Parent(component))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = iam.NewRolePolicyAttachment(ctx, name, &iam.RolePolicyAttachmentArgs{
+		Role:      role.Name,
+		PolicyArn: pulumi.String(args.PolicyArn),
+	}, pulumi.Parent(component))
+	if err != nil {
+		return nil, err
+	}
+
+	component.Arn = role.Arn
+	if err := ctx.RegisterResourceOutputs(component, pulumi.Map{"arn": role.Arn}); err != nil {
+		return nil, err
+	}
+	return component, nil
+}
+
+func escTrustPolicy(providerArn string, args *EscAssumableIamRoleArgs) map[string]interface{} {
+	audience := "api.pulumi.com/oidc:aud"
+	subject := "api.pulumi.com/oidc:sub"
+	return map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Effect":    "Allow",
+				"Principal": map[string]string{"Federated": providerArn},
+				"Action":    "sts:AssumeRoleWithWebIdentity",
+				"Condition": map[string]interface{}{
+					"StringEquals": map[string]string{
+						audience: args.PulumiOrg,
+						subject:  fmt.Sprintf("pulumi:environments:org:%s:env:%s", args.PulumiOrg, args.EnvironmentName),
+					},
+				},
+			},
+			{
+				"Effect":    "Allow",
+				"Principal": map[string]string{"Federated": providerArn},
+				"Action":    "sts:AssumeRoleWithWebIdentity",
+				"Condition": map[string]interface{}{
+					"StringEquals": map[string]string{audience: args.PulumiOrg},
+					"StringLike": map[string]string{
+						subject: fmt.Sprintf("pulumi:deploy:org:%s:project:%s:*", args.PulumiOrg, args.ProjectName),
+					},
+				},
+			},
+		},
+	}
+}
+
+// BootstrapEnvironments creates an ESC environment per ESC role of an account after the account program ran, so teams
+// get short-lived credentials for the account right away. It's a no-op for orgs that don't use ESC and for accounts
+// the account program didn't create the ESC roles in
+func BootstrapEnvironments(ctx context.Context, envs esc.Environments, org *types.Organization, account *types.Account, accountID string) error {
+	if org.PulumiOrg == "" || accessRole(account) == "" {
+		return nil
+	}
+	for _, role := range escRoles {
+		roleArn := fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, role.roleName)
+		definition, err := esc.AWSLoginDefinition(roleArn, fmt.Sprintf("festus-%s", account.AccountName))
+		if err != nil {
+			return err
+		}
+		name := EnvironmentName(org, account, role.name)
+		if err := envs.UpsertEnvironment(ctx, org.PulumiOrg, name, definition); err != nil {
+			return fmt.Errorf("failed to create ESC environment '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// environments returns the ESC client for an org. Orgs on a self-hosted Pulumi Cloud use its API
+func environments(org *types.Organization) esc.Environments {
+	apiURL := esc.DefaultAPIURL
+	if org.Backend.Type == types.PulumiCloudBackend && org.Backend.URL != "" {
+		apiURL = org.Backend.URL
+	}
+	return esc.NewCloudClient(apiURL, org.PulumiAccessToken)
+}
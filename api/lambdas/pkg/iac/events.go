@@ -0,0 +1,134 @@
+package iac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+// EventSink receives the condensed engine events of a deployment
+type EventSink func(event types.DeploymentEvent)
+
+// eventsGracePeriod is how long a failed operation waits for the rest of its events
+var eventsGracePeriod = 5 * time.Second
+
+// eventForwarder condenses the engine events an operation sends to its events channel and passes them to a sink
+type eventForwarder struct {
+	events chan events.EngineEvent
+	// done is closed once the forwarding ended
+	done chan struct{}
+	// stop cancels the forwarding of an operation whose engine never closes the events channel
+	stop chan struct{}
+}
+
+// forwardEvents starts forwarding the engine events received on the events channel of the returned forwarder to sink
+// until the engine closes the channel.
+func forwardEvents(sink EventSink) *eventForwarder {
+	f := &eventForwarder{
+		events: make(chan events.EngineEvent),
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	go func() {
+		defer close(f.done)
+		for {
+			var e events.EngineEvent
+			var ok bool
+			select {
+			case e, ok = <-f.events:
+			case <-f.stop:
+				return
+			}
+			if !ok {
+				return
+			}
+			if sink == nil {
+				continue
+			}
+			if event, ok := condenseEvent(e); ok {
+				sink(event)
+			}
+		}
+	}()
+	return f
+}
+
+// wait waits until all events of an operation were forwarded. If the operation failed before the engine started it
+// never closes the events channel, so for failed operations the forwarding is cancelled after a grace period.
+func (f *eventForwarder) wait(err error) {
+	if err == nil {
+		<-f.done
+		return
+	}
+
+	select {
+	case <-f.done:
+	case <-time.After(eventsGracePeriod):
+		close(f.stop)
+		<-f.done
+	}
+}
+
+// condenseEvent reduces an engine event to the per-resource progress users care about.
+// Unchanged resources, debug output and engine bookkeeping events are dropped.
+func condenseEvent(e events.EngineEvent) (types.DeploymentEvent, bool) {
+	event := types.DeploymentEvent{
+		Sequence:  e.Sequence,
+		Timestamp: e.Timestamp,
+	}
+
+	switch {
+	case e.ResourcePreEvent != nil:
+		if !withMetadata(&event, e.ResourcePreEvent.Metadata) {
+			return event, false
+		}
+		event.Status = types.DeploymentEventStarted
+	case e.ResOutputsEvent != nil:
+		if !withMetadata(&event, e.ResOutputsEvent.Metadata) {
+			return event, false
+		}
+		event.Status = types.DeploymentEventSucceeded
+	case e.ResOpFailedEvent != nil:
+		withMetadata(&event, e.ResOpFailedEvent.Metadata)
+		event.Status = types.DeploymentEventFailed
+	case e.DiagnosticEvent != nil:
+		switch e.DiagnosticEvent.Severity {
+		case "warning":
+			event.Status = types.DeploymentEventWarning
+		case "error":
+			event.Status = types.DeploymentEventError
+		default:
+			return event, false
+		}
+		event.URN = e.DiagnosticEvent.URN
+		event.Message = strings.TrimSpace(e.DiagnosticEvent.Message)
+	case e.SummaryEvent != nil:
+		event.Status = types.DeploymentEventSummary
+		event.Message = summarizeChanges(e.SummaryEvent.ResourceChanges)
+	default:
+		return event, false
+	}
+
+	return event, true
+}
+
+func withMetadata(event *types.DeploymentEvent, metadata apitype.StepEventMetadata) bool {
+	event.URN = metadata.URN
+	event.ResourceType = metadata.Type
+	event.Op = string(metadata.Op)
+	return metadata.Op != apitype.OpSame && metadata.Op != apitype.OpRead
+}
+
+func summarizeChanges(changes map[apitype.OpType]int) string {
+	var parts []string
+	for op, count := range changes {
+		parts = append(parts, fmt.Sprintf("%d %s", count, op))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
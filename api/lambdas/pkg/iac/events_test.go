@@ -0,0 +1,50 @@
+package iac
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+func TestEventForwarder(t *testing.T) {
+	var forwarded []types.DeploymentEvent
+	forwarder := forwardEvents(func(event types.DeploymentEvent) {
+		forwarded = append(forwarded, event)
+	})
+
+	forwarder.events <- events.EngineEvent{EngineEvent: apitype.EngineEvent{
+		Sequence:         1,
+		ResourcePreEvent: &apitype.ResourcePreEvent{Metadata: apitype.StepEventMetadata{Op: apitype.OpCreate, URN: "urn:bucket"}},
+	}}
+	// unchanged resources are dropped
+	forwarder.events <- events.EngineEvent{EngineEvent: apitype.EngineEvent{
+		Sequence:         2,
+		ResourcePreEvent: &apitype.ResourcePreEvent{Metadata: apitype.StepEventMetadata{Op: apitype.OpSame, URN: "urn:role"}},
+	}}
+	close(forwarder.events)
+	forwarder.wait(nil)
+
+	if len(forwarded) != 1 || forwarded[0].URN != "urn:bucket" || forwarded[0].Status != types.DeploymentEventStarted {
+		t.Fatalf("expected the create of the bucket to be forwarded, got %+v", forwarded)
+	}
+}
+
+func TestEventForwarderOfFailedOperation(t *testing.T) {
+	gracePeriod := eventsGracePeriod
+	eventsGracePeriod = 10 * time.Millisecond
+	defer func() { eventsGracePeriod = gracePeriod }()
+
+	// the engine never started, so it doesn't close the events channel
+	forwarder := forwardEvents(nil)
+	forwarder.wait(fmt.Errorf("failed to start"))
+
+	select {
+	case <-forwarder.done:
+	default:
+		t.Fatalf("expected the forwarding to be cancelled")
+	}
+}
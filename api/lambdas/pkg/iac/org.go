@@ -0,0 +1,168 @@
+package iac
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi-aws/sdk/v6/go/aws/organizations"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// OrgStackName is the name of the stack that manages the resources of an org itself. It lives next to the account
+// stacks in the org's project, so no account can have this name.
+const OrgStackName = "organization"
+
+// ApplyOrg runs the org stack so that exactly the given units and policies exist and returns their AWS IDs by name
+func ApplyOrg(ctx context.Context, org *types.Organization, units []*types.OrganizationalUnit, policies []*types.ServiceControlPolicy, accountIDs map[string]string) (*types.OrgResources, error) {
+	s, err := upsertStack(ctx, org, OrgStackName, OrgProgram(units, policies, accountIDs), awsCredentials{
+		accessKey:    org.AwsAccessKey,
+		secretKey:    org.AwsSecretKey,
+		sessionToken: org.AwsSessionToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Workspace().InstallPlugin(ctx, "aws", "v6.32.0")
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.Up(ctx, optup.SuppressProgress(), optup.ProgressStreams(os.Stdout))
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.OrgResources{
+		Units:    outputIDs(res.Outputs["units"]),
+		Policies: outputIDs(res.Outputs["policies"]),
+	}, nil
+}
+
+func outputIDs(output auto.OutputValue) map[string]string {
+	ids := map[string]string{}
+	if values, ok := output.Value.(map[string]interface{}); ok {
+		for name, id := range values {
+			if id, ok := id.(string); ok {
+				ids[name] = id
+			}
+		}
+	}
+	return ids
+}
+
+// OrgProgram is the inline Pulumi program of the org stack. It creates the units below the root of the AWS
+// organization, parents before their children, and the service control policies with their attachments.
+// Policies are attached to accounts by the AWS account IDs in accountIDs, keyed by account name.
+// The IDs are exported by name as "units" and "policies".
+func OrgProgram(units []*types.OrganizationalUnit, policies []*types.ServiceControlPolicy, accountIDs map[string]string) pulumi.RunFunc {
+	return func(ctx *pulumi.Context) error {
+		org, err := organizations.LookupOrganization(ctx)
+		if err != nil {
+			return err
+		}
+		if len(org.Roots) == 0 {
+			return fmt.Errorf("the AWS organization has no root")
+		}
+		rootID := pulumi.String(org.Roots[0].Id).ToStringOutput()
+
+		byName := map[string]*types.OrganizationalUnit{}
+		for _, unit := range units {
+			byName[unit.Name] = unit
+		}
+
+		created := map[string]*organizations.OrganizationalUnit{}
+		var create func(unit *types.OrganizationalUnit, depth int) (*organizations.OrganizationalUnit, error)
+		create = func(unit *types.OrganizationalUnit, depth int) (*organizations.OrganizationalUnit, error) {
+			if ou, ok := created[unit.Name]; ok {
+				return ou, nil
+			}
+			if depth > len(units) {
+				return nil, fmt.Errorf("organizational unit '%s' is part of a cycle", unit.Name)
+			}
+
+			parentID := rootID
+			if unit.Parent != "" {
+				parent, ok := byName[unit.Parent]
+				if !ok {
+					return nil, fmt.Errorf("parent '%s' of organizational unit '%s' does not exist", unit.Parent, unit.Name)
+				}
+				parentOU, err := create(parent, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				parentID = parentOU.ID().ToStringOutput()
+			}
+
+			ou, err := organizations.NewOrganizationalUnit(ctx, unit.Name, &organizations.OrganizationalUnitArgs{
+				Name:     pulumi.String(unit.Name),
+				ParentId: parentID,
+				Tags:     pulumi.ToStringMap(unit.Tags),
+			})
+			if err != nil {
+				return nil, err
+			}
+			created[unit.Name] = ou
+			return ou, nil
+		}
+
+		ids := pulumi.StringMap{}
+		for _, unit := range units {
+			ou, err := create(unit, 0)
+			if err != nil {
+				return err
+			}
+			ids[unit.Name] = ou.ID().ToStringOutput()
+		}
+		ctx.Export("units", ids)
+
+		// accounts that don't have an AWS account yet are attached once they were created, because the stream
+		// processor applies the org stack again afterwards
+		policyIDs := pulumi.StringMap{}
+		for _, policy := range policies {
+			scp, err := organizations.NewPolicy(ctx, policy.Name, &organizations.PolicyArgs{
+				Name:        pulumi.String(policy.Name),
+				Description: optionalString(policy.Description),
+				Content:     pulumi.String(policy.Document),
+				Type:        pulumi.String("SERVICE_CONTROL_POLICY"),
+			})
+			if err != nil {
+				return err
+			}
+			policyIDs[policy.Name] = scp.ID().ToStringOutput()
+
+			for _, unitName := range policy.Units {
+				ou, ok := created[unitName]
+				if !ok {
+					return fmt.Errorf("organizational unit '%s' of policy '%s' does not exist", unitName, policy.Name)
+				}
+				_, err := organizations.NewPolicyAttachment(ctx, fmt.Sprintf("%s-unit-%s", policy.Name, unitName), &organizations.PolicyAttachmentArgs{
+					PolicyId: scp.ID(),
+					TargetId: ou.ID(),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			for _, accountName := range policy.Accounts {
+				accountID, ok := accountIDs[accountName]
+				if !ok {
+					continue
+				}
+				_, err := organizations.NewPolicyAttachment(ctx, fmt.Sprintf("%s-account-%s", policy.Name, accountName), &organizations.PolicyAttachmentArgs{
+					PolicyId: scp.ID(),
+					TargetId: pulumi.String(accountID),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		ctx.Export("policies", policyIDs)
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package iac
+
+import (
+	"context"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+// PreviewAccount runs a dry-run of the account program with the same stack configuration as CreateAccount
+func PreviewAccount(ctx context.Context, account *types.Account, org *types.Organization) (*types.PreviewDiff, error) {
+	s, err := UpsertAccountStack(ctx, account, org)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Workspace().InstallPlugin(ctx, "aws", "v6.32.0")
+	if err != nil {
+		return nil, err
+	}
+
+	engineEvents, diff := collectDiff(plannedStep)
+
+	_, err = s.Preview(ctx, optpreview.SuppressProgress(), optpreview.EventStreams(engineEvents))
+	if err != nil {
+		return nil, err
+	}
+
+	return <-diff, nil
+}
+
+// collectDiff aggregates the steps that step picks from the events received on the returned channel into a diff
+// once the engine closes it
+func collectDiff(step func(e events.EngineEvent) *apitype.StepEventMetadata) (chan events.EngineEvent, chan *types.PreviewDiff) {
+	engineEvents := make(chan events.EngineEvent)
+	diff := make(chan *types.PreviewDiff, 1)
+	go func() {
+		var steps []apitype.StepEventMetadata
+		for e := range engineEvents {
+			if metadata := step(e); metadata != nil {
+				steps = append(steps, *metadata)
+			}
+		}
+		diff <- NewPreviewDiff(steps)
+	}()
+	return engineEvents, diff
+}
+
+// plannedStep picks the steps a preview plans, which are announced before they would run
+func plannedStep(e events.EngineEvent) *apitype.StepEventMetadata {
+	if e.ResourcePreEvent == nil {
+		return nil
+	}
+	return &e.ResourcePreEvent.Metadata
+}
+
+// NewPreviewDiff aggregates the planned steps of a preview per resource type.
+// Unchanged resources are left out and replacements are counted once.
+func NewPreviewDiff(steps []apitype.StepEventMetadata) *types.PreviewDiff {
+	diff := &types.PreviewDiff{
+		ResourceTypes: map[string]*types.ResourceChanges{},
+		Resources:     []types.ResourceChange{},
+	}
+
+	for _, step := range steps {
+		if !countChange(&diff.Totals, step.Op) {
+			continue
+		}
+
+		if diff.ResourceTypes[step.Type] == nil {
+			diff.ResourceTypes[step.Type] = &types.ResourceChanges{}
+		}
+		countChange(diff.ResourceTypes[step.Type], step.Op)
+		diff.Resources = append(diff.Resources, types.ResourceChange{
+			URN:   step.URN,
+			Type:  step.Type,
+			Op:    string(step.Op),
+			Diffs: step.Diffs,
+		})
+	}
+
+	return diff
+}
+
+// countChange counts op in changes and reports whether op changes the resource
+func countChange(changes *types.ResourceChanges, op apitype.OpType) bool {
+	switch op {
+	case apitype.OpCreate, apitype.OpImport:
+		changes.Creates++
+	case apitype.OpUpdate:
+		changes.Updates++
+	case apitype.OpReplace:
+		changes.Replaces++
+	case apitype.OpDelete:
+		changes.Deletes++
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package iac
+
+import (
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+func TestNewPreviewDiff(t *testing.T) {
+	const bucket = "aws:s3/bucketV2:BucketV2"
+	const role = "aws:iam/role:Role"
+	diff := NewPreviewDiff([]apitype.StepEventMetadata{
+		{Op: apitype.OpCreate, URN: "urn:logs", Type: bucket},
+		{Op: apitype.OpImport, URN: "urn:state", Type: bucket},
+		{Op: apitype.OpSame, URN: "urn:access", Type: role},
+		{Op: apitype.OpUpdate, URN: "urn:admin", Type: role, Diffs: []string{"tags"}},
+		// a replacement is announced as create-replacement, replace and delete-replaced
+		{Op: apitype.OpCreateReplacement, URN: "urn:audit", Type: role},
+		{Op: apitype.OpReplace, URN: "urn:audit", Type: role},
+		{Op: apitype.OpDeleteReplaced, URN: "urn:audit", Type: role},
+		{Op: apitype.OpDelete, URN: "urn:old", Type: bucket},
+	})
+
+	expected := types.ResourceChanges{Creates: 2, Updates: 1, Replaces: 1, Deletes: 1}
+	if diff.Totals != expected {
+		t.Fatalf("expected totals %+v, got %+v", expected, diff.Totals)
+	}
+	if changes := diff.ResourceTypes[bucket]; changes == nil || *changes != (types.ResourceChanges{Creates: 2, Deletes: 1}) {
+		t.Fatalf("expected the buckets to be created and deleted, got %+v", changes)
+	}
+	if changes := diff.ResourceTypes[role]; changes == nil || *changes != (types.ResourceChanges{Updates: 1, Replaces: 1}) {
+		t.Fatalf("expected the roles to be updated and replaced, got %+v", changes)
+	}
+	if len(diff.Resources) != 5 || diff.Resources[2].URN != "urn:admin" || diff.Resources[2].Diffs[0] != "tags" {
+		t.Fatalf("expected the changed resources in order, got %+v", diff.Resources)
+	}
+
+	empty := NewPreviewDiff(nil)
+	if empty.Totals != (types.ResourceChanges{}) || len(empty.Resources) != 0 || empty.ResourceTypes == nil {
+		t.Fatalf("expected an empty diff without steps, got %+v", empty)
+	}
+}
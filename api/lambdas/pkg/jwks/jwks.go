@@ -0,0 +1,241 @@
+// Package jwks fetches and caches the public keys of a JSON Web Key Set that JWTs are verified with
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long keys are cached before the key set is fetched again
+const DefaultTTL = time.Hour
+
+// minRefreshInterval limits how often tokens with unknown key IDs can make the cache fetch the key set
+const minRefreshInterval = time.Minute
+
+// Cache resolves key IDs to public keys. The key set is fetched again once the TTL passed or when a token is signed
+// with a key that isn't known yet, e.g. after the issuer rotated its keys. Fetching doesn't block the lookups of
+// keys that are cached
+type Cache struct {
+	uri    string
+	ttl    time.Duration
+	client *http.Client
+	now    func() time.Time
+
+	// fetching is held while the key set is fetched, so only one request fetches it at a time
+	fetching sync.Mutex
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// New creates a cache for the key set at uri. Besides http(s) URLs, uri can be a file:// URL or a path, e.g. for
+// tests and local servers. A ttl of zero means DefaultTTL
+func New(uri string, ttl time.Duration) *Cache {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	return &Cache{
+		uri:    uri,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// Key returns the public key with the given key ID
+func (c *Cache) Key(kid string) (crypto.PublicKey, error) {
+	now := c.now()
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	fetchedAt := c.fetchedAt
+	c.mu.Unlock()
+
+	stale := now.Sub(fetchedAt) >= c.ttl
+	if ok && !stale {
+		return key, nil
+	}
+	if !ok && !stale && now.Sub(fetchedAt) < minRefreshInterval {
+		return nil, fmt.Errorf("unknown key ID '%s'", kid)
+	}
+
+	if ok {
+		// a stale key is still used while another request refreshes the key set
+		if !c.fetching.TryLock() {
+			return key, nil
+		}
+	} else {
+		c.fetching.Lock()
+	}
+	defer c.fetching.Unlock()
+
+	// the key set might have been fetched while waiting for the other request
+	c.mu.Lock()
+	if c.fetchedAt != fetchedAt {
+		key, ok = c.keys[kid]
+		c.mu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID '%s'", kid)
+		}
+		return key, nil
+	}
+	c.mu.Unlock()
+
+	keys, err := c.fetch()
+	if err != nil {
+		// keep verifying with the cached keys if the key set can't be fetched temporarily
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	c.keys = keys
+	c.fetchedAt = now
+	c.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID '%s'", kid)
+	}
+	return key, nil
+}
+
+func (c *Cache) fetch() (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(c.uri, "http://") || strings.HasPrefix(c.uri, "https://") {
+		data, err = c.download()
+	} else {
+		data, err = os.ReadFile(strings.TrimPrefix(c.uri, "file://"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	return Parse(data)
+}
+
+func (c *Cache) download() ([]byte, error) {
+	resp, err := c.client.Get(c.uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	// RSA keys
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC keys
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Parse returns the RSA and EC signing keys of a key set by their key ID. Other keys are skipped
+func Parse(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			key, err = rsaKey(jwk)
+		case "EC":
+			key, err = ecKey(jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %w", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func rsaKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := decodeInt(jwk.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeInt(jwk.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("exponent is too large")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+func ecKey(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	curve, ok := curves[jwk.Crv]
+	if !ok {
+		return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+	}
+	x, err := decodeInt(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeInt(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	// the conversion validates that the point is on the curve
+	if _, err := key.ECDH(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// decodeInt decodes the base64url encoded big-endian integers of JWKs
+func decodeInt(value string) (*big.Int, error) {
+	if value == "" {
+		return nil, fmt.Errorf("missing key parameter")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,129 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func rsaJWK(t *testing.T, kid string) map[string]string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	return map[string]string{"kid": kid, "kty": "RSA", "use": "sig", "n": encode(key.N), "e": encode(big.NewInt(int64(key.E)))}
+}
+
+func ecJWK(t *testing.T, kid string) map[string]string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	return map[string]string{"kid": kid, "kty": "EC", "crv": "P-256", "x": encode(key.X), "y": encode(key.Y)}
+}
+
+func TestParse(t *testing.T) {
+	encryption := rsaJWK(t, "encryption")
+	encryption["use"] = "enc"
+	data, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		rsaJWK(t, "rsa"),
+		ecJWK(t, "ec"),
+		encryption,
+		{"kid": "symmetric", "kty": "oct", "k": "c2VjcmV0"},
+	}})
+
+	keys, err := Parse(data)
+	if err != nil {
+		t.Fatalf("expected the key set to be parsed, got %s", err.Error())
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected only the signing keys, got %v", keys)
+	}
+	if _, ok := keys["rsa"].(*rsa.PublicKey); !ok {
+		t.Fatalf("expected an RSA key, got %T", keys["rsa"])
+	}
+	if key, ok := keys["ec"].(*ecdsa.PublicKey); !ok || key.Curve != elliptic.P256() {
+		t.Fatalf("expected a P-256 key, got %T", keys["ec"])
+	}
+
+	unsupported := ecJWK(t, "ec")
+	unsupported["crv"] = "P-192"
+	for name, set := range map[string]interface{}{
+		"a key on an unsupported curve": map[string]interface{}{"keys": []map[string]string{unsupported}},
+		"a key with an invalid modulus": map[string]interface{}{"keys": []map[string]string{{"kid": "rsa", "kty": "RSA", "n": "not base64!", "e": "AQAB"}}},
+		"a list instead of a key set":   []string{"rsa"},
+	} {
+		data, _ := json.Marshal(set)
+		if _, err := Parse(data); err == nil {
+			t.Fatalf("expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestKeyDoesNotWaitForFetch(t *testing.T) {
+	first, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{rsaJWK(t, "a")}})
+	rotated, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{rsaJWK(t, "a"), rsaJWK(t, "b")}})
+
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			w.Write(first)
+			return
+		}
+		<-release
+		w.Write(rotated)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	cache := New(server.URL, time.Hour)
+	cache.now = func() time.Time { return now }
+	if _, err := cache.Key("a"); err != nil {
+		t.Fatalf("expected key 'a' to be fetched, got %s", err.Error())
+	}
+
+	// a token signed with the rotated key makes the cache fetch the key set again, which hangs
+	now = now.Add(2 * minRefreshInterval)
+	rotatedKey := make(chan error)
+	go func() {
+		_, err := cache.Key("b")
+		rotatedKey <- err
+	}()
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	known := make(chan error)
+	go func() {
+		_, err := cache.Key("a")
+		known <- err
+	}()
+	select {
+	case err := <-known:
+		if err != nil {
+			t.Fatalf("expected the cached key to be returned, got %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the lookup of a cached key not to wait for the fetch")
+	}
+
+	close(release)
+	if err := <-rotatedKey; err != nil {
+		t.Fatalf("expected the rotated key to be fetched, got %s", err.Error())
+	}
+	if fetches.Load() != 2 {
+		t.Fatalf("expected the key set to be fetched twice, got %d fetches", fetches.Load())
+	}
+}
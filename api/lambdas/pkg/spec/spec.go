@@ -0,0 +1,233 @@
+package spec
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// MaxUnitDepth is the number of levels organizational units can be nested below the root in AWS Organizations
+const MaxUnitDepth = 5
+
+// Export describes the current baselines, units and accounts of an org as a spec. Accounts waiting to be closed are
+// described where they were placed before, where cancelling the closure moves them back to. Otherwise applying an
+// exported spec would move them out of the suspended unit
+func Export(org *types.Organization, units []*types.OrganizationalUnit, accounts []*types.Account) *types.OrgSpec {
+	spec := &types.OrgSpec{Baselines: org.Baselines, Units: []types.UnitSpec{}, Accounts: []types.AccountSpec{}}
+	for _, unit := range units {
+		spec.Units = append(spec.Units, types.UnitSpec{Name: unit.Name, Parent: unit.Parent, Tags: unit.Tags})
+	}
+	for _, account := range accounts {
+		unit, parentID := account.Unit, account.ParentID
+		if account.Closure != nil {
+			unit, parentID = account.Closure.Unit, account.Closure.ParentID
+		}
+		spec.Accounts = append(spec.Accounts, types.AccountSpec{
+			Name:     account.AccountName,
+			Email:    account.Email,
+			Unit:     unit,
+			ParentID: parentID,
+			Tags:     account.Tags,
+		})
+	}
+
+	sort.Slice(spec.Units, func(i, j int) bool { return spec.Units[i].Name < spec.Units[j].Name })
+	sort.Slice(spec.Accounts, func(i, j int) bool { return spec.Accounts[i].Name < spec.Accounts[j].Name })
+	return spec
+}
+
+// Validate checks that names are unique, that referenced units exist in the spec and that units aren't nested too deep
+func Validate(spec *types.OrgSpec) error {
+	units := map[string]types.UnitSpec{}
+	for _, unit := range spec.Units {
+		if _, ok := units[unit.Name]; ok {
+			return fmt.Errorf("organizational unit '%s' is specified more than once", unit.Name)
+		}
+		units[unit.Name] = unit
+	}
+	for _, unit := range spec.Units {
+		if unit.Parent != "" {
+			if _, ok := units[unit.Parent]; !ok {
+				return fmt.Errorf("parent '%s' of organizational unit '%s' is not specified", unit.Parent, unit.Name)
+			}
+		}
+		depth, err := unitDepth(units, unit.Name)
+		if err != nil {
+			return err
+		}
+		if depth > MaxUnitDepth {
+			return fmt.Errorf("organizational unit '%s' is nested deeper than %d levels", unit.Name, MaxUnitDepth)
+		}
+	}
+
+	accounts := map[string]bool{}
+	for _, account := range spec.Accounts {
+		if accounts[account.Name] {
+			return fmt.Errorf("account '%s' is specified more than once", account.Name)
+		}
+		accounts[account.Name] = true
+
+		if account.Unit != "" && account.ParentID != "" {
+			return fmt.Errorf("account '%s' can either reference a unit or a parent ID", account.Name)
+		}
+		if account.Unit != "" {
+			if _, ok := units[account.Unit]; !ok {
+				return fmt.Errorf("unit '%s' of account '%s' is not specified", account.Unit, account.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Plan diffs the desired spec of an org against its current state. The changes are ordered so that they can be
+// applied one after another: parents are created before their children and children are deleted before their parents.
+func Plan(current *types.OrgSpec, desired *types.OrgSpec) (*types.SpecPlan, error) {
+	// baselines go first so that new accounts get them right away
+	plan := &types.SpecPlan{Changes: planBaselines(current.Baselines, desired.Baselines)}
+
+	currentUnits := map[string]types.UnitSpec{}
+	for _, unit := range current.Units {
+		currentUnits[unit.Name] = unit
+	}
+	desiredUnits := map[string]types.UnitSpec{}
+	for _, unit := range desired.Units {
+		desiredUnits[unit.Name] = unit
+	}
+
+	var createUnits, deleteUnits []types.UnitSpec
+	var unitUpdates []types.SpecChange
+	for _, unit := range sortedUnits(desiredUnits) {
+		existing, ok := currentUnits[unit.Name]
+		if !ok {
+			createUnits = append(createUnits, unit)
+			continue
+		}
+		if existing.Parent != unit.Parent {
+			return nil, fmt.Errorf("organizational unit '%s' cannot be moved", unit.Name)
+		}
+		if !maps.Equal(existing.Tags, unit.Tags) {
+			unitUpdates = append(unitUpdates, types.SpecChange{Kind: types.SpecUnit, Name: unit.Name, Action: types.SpecUpdate, Fields: []string{"tags"}})
+		}
+	}
+	for _, unit := range sortedUnits(currentUnits) {
+		if _, ok := desiredUnits[unit.Name]; !ok {
+			deleteUnits = append(deleteUnits, unit)
+		}
+	}
+
+	// parents first for creation, children first for deletion
+	sort.SliceStable(createUnits, func(i, j int) bool {
+		return mustDepth(desiredUnits, createUnits[i].Name) < mustDepth(desiredUnits, createUnits[j].Name)
+	})
+	sort.SliceStable(deleteUnits, func(i, j int) bool {
+		return mustDepth(currentUnits, deleteUnits[i].Name) > mustDepth(currentUnits, deleteUnits[j].Name)
+	})
+	for _, unit := range createUnits {
+		plan.Changes = append(plan.Changes, types.SpecChange{Kind: types.SpecUnit, Name: unit.Name, Action: types.SpecCreate})
+	}
+	plan.Changes = append(plan.Changes, unitUpdates...)
+
+	currentAccounts := map[string]types.AccountSpec{}
+	for _, account := range current.Accounts {
+		currentAccounts[account.Name] = account
+	}
+	desiredAccounts := map[string]types.AccountSpec{}
+	for _, account := range desired.Accounts {
+		desiredAccounts[account.Name] = account
+	}
+
+	for _, account := range sortedAccounts(desiredAccounts) {
+		existing, ok := currentAccounts[account.Name]
+		if !ok {
+			plan.Changes = append(plan.Changes, types.SpecChange{Kind: types.SpecAccount, Name: account.Name, Action: types.SpecCreate})
+			continue
+		}
+
+		var fields []string
+		if existing.Email != account.Email {
+			fields = append(fields, "email")
+		}
+		if !maps.Equal(existing.Tags, account.Tags) {
+			fields = append(fields, "tags")
+		}
+		if len(fields) > 0 {
+			plan.Changes = append(plan.Changes, types.SpecChange{Kind: types.SpecAccount, Name: account.Name, Action: types.SpecUpdate, Fields: fields})
+		}
+		if existing.Unit != account.Unit || existing.ParentID != account.ParentID {
+			plan.Changes = append(plan.Changes, types.SpecChange{Kind: types.SpecAccount, Name: account.Name, Action: types.SpecMove})
+		}
+	}
+	for _, account := range sortedAccounts(currentAccounts) {
+		if _, ok := desiredAccounts[account.Name]; !ok {
+			plan.Changes = append(plan.Changes, types.SpecChange{Kind: types.SpecAccount, Name: account.Name, Action: types.SpecDelete})
+		}
+	}
+
+	for _, unit := range deleteUnits {
+		plan.Changes = append(plan.Changes, types.SpecChange{Kind: types.SpecUnit, Name: unit.Name, Action: types.SpecDelete})
+	}
+	return plan, nil
+}
+
+// planBaselines diffs the selected baselines by name. Their order only matters when they are applied
+func planBaselines(current []types.BaselineConfig, desired []types.BaselineConfig) []types.SpecChange {
+	changes := []types.SpecChange{}
+	currentByName := map[string]types.BaselineConfig{}
+	for _, b := range current {
+		currentByName[b.Name] = b
+	}
+	desiredByName := map[string]bool{}
+	for _, b := range desired {
+		desiredByName[b.Name] = true
+		existing, ok := currentByName[b.Name]
+		if !ok {
+			changes = append(changes, types.SpecChange{Kind: types.SpecBaseline, Name: b.Name, Action: types.SpecCreate})
+		} else if !maps.Equal(existing.Params, b.Params) {
+			changes = append(changes, types.SpecChange{Kind: types.SpecBaseline, Name: b.Name, Action: types.SpecUpdate, Fields: []string{"params"}})
+		}
+	}
+	for _, b := range current {
+		if !desiredByName[b.Name] {
+			changes = append(changes, types.SpecChange{Kind: types.SpecBaseline, Name: b.Name, Action: types.SpecDelete})
+		}
+	}
+	return changes
+}
+
+// unitDepth returns the level of a unit below the root, starting at 1
+func unitDepth(units map[string]types.UnitSpec, name string) (int, error) {
+	depth := 0
+	for current := name; current != ""; current = units[current].Parent {
+		depth++
+		if depth > len(units) {
+			return 0, fmt.Errorf("organizational unit '%s' is part of a cycle", name)
+		}
+	}
+	return depth, nil
+}
+
+// mustDepth is unitDepth for validated specs
+func mustDepth(units map[string]types.UnitSpec, name string) int {
+	depth, _ := unitDepth(units, name)
+	return depth
+}
+
+func sortedUnits(units map[string]types.UnitSpec) []types.UnitSpec {
+	result := make([]types.UnitSpec, 0, len(units))
+	for _, unit := range units {
+		result = append(result, unit)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func sortedAccounts(accounts map[string]types.AccountSpec) []types.AccountSpec {
+	result := make([]types.AccountSpec, 0, len(accounts))
+	for _, account := range accounts {
+		result = append(result, account)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
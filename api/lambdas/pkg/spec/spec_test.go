@@ -0,0 +1,119 @@
+package spec
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestValidate(t *testing.T) {
+	nested := []types.UnitSpec{{Name: "l1"}}
+	for i := 2; i <= MaxUnitDepth+1; i++ {
+		nested = append(nested, types.UnitSpec{Name: fmt.Sprintf("l%d", i), Parent: nested[len(nested)-1].Name})
+	}
+
+	for _, tc := range []struct {
+		name  string
+		spec  types.OrgSpec
+		valid bool
+	}{
+		{"empty", types.OrgSpec{}, true},
+		{"nested units", types.OrgSpec{
+			Units:    nested[:MaxUnitDepth],
+			Accounts: []types.AccountSpec{{Name: "dev", Unit: "l1"}, {Name: "prod", ParentID: "ou-a1b2-workload"}},
+		}, true},
+		{"units nested too deep", types.OrgSpec{Units: nested}, false},
+		{"duplicate unit", types.OrgSpec{Units: []types.UnitSpec{{Name: "a"}, {Name: "a"}}}, false},
+		{"unknown parent", types.OrgSpec{Units: []types.UnitSpec{{Name: "a", Parent: "b"}}}, false},
+		{"cycle", types.OrgSpec{Units: []types.UnitSpec{{Name: "a", Parent: "b"}, {Name: "b", Parent: "a"}}}, false},
+		{"duplicate account", types.OrgSpec{Accounts: []types.AccountSpec{{Name: "dev"}, {Name: "dev"}}}, false},
+		{"unknown unit", types.OrgSpec{Accounts: []types.AccountSpec{{Name: "dev", Unit: "a"}}}, false},
+		{"unit and parent ID", types.OrgSpec{
+			Units:    []types.UnitSpec{{Name: "a"}},
+			Accounts: []types.AccountSpec{{Name: "dev", Unit: "a", ParentID: "ou-a1b2-workload"}},
+		}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(&tc.spec)
+			if tc.valid && err != nil {
+				t.Fatalf("expected the spec to be valid, got %s", err.Error())
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf("expected the spec to be invalid")
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	current := &types.OrgSpec{
+		Units: []types.UnitSpec{{Name: "old"}, {Name: "old-child", Parent: "old"}, {Name: "workloads"}},
+		Accounts: []types.AccountSpec{
+			{Name: "dev", Email: "aws+dev@example.com", Unit: "workloads"},
+			{Name: "legacy", Email: "aws+legacy@example.com", Unit: "old-child"},
+			{Name: "prod", Email: "aws+prod@example.com", Unit: "workloads"},
+		},
+	}
+	desired := &types.OrgSpec{
+		Units: []types.UnitSpec{{Name: "new-child", Parent: "new"}, {Name: "new"}, {Name: "workloads", Tags: map[string]string{"env": "dev"}}},
+		Accounts: []types.AccountSpec{
+			{Name: "dev", Email: "aws+dev@example.com", Unit: "new-child", Tags: map[string]string{"team": "platform"}},
+			{Name: "prod", Email: "aws+prod@example.com", Unit: "workloads"},
+			{Name: "staging", Email: "aws+staging@example.com", Unit: "new"},
+		},
+		Baselines: []types.BaselineConfig{{Name: "cloudtrail"}},
+	}
+
+	plan, err := Plan(current, desired)
+	if err != nil {
+		t.Fatalf("expected a plan, got %s", err.Error())
+	}
+	expected := []types.SpecChange{
+		{Kind: types.SpecBaseline, Name: "cloudtrail", Action: types.SpecCreate},
+		{Kind: types.SpecUnit, Name: "new", Action: types.SpecCreate},
+		{Kind: types.SpecUnit, Name: "new-child", Action: types.SpecCreate},
+		{Kind: types.SpecUnit, Name: "workloads", Action: types.SpecUpdate},
+		{Kind: types.SpecAccount, Name: "dev", Action: types.SpecUpdate},
+		{Kind: types.SpecAccount, Name: "dev", Action: types.SpecMove},
+		{Kind: types.SpecAccount, Name: "staging", Action: types.SpecCreate},
+		{Kind: types.SpecAccount, Name: "legacy", Action: types.SpecDelete},
+		{Kind: types.SpecUnit, Name: "old-child", Action: types.SpecDelete},
+		{Kind: types.SpecUnit, Name: "old", Action: types.SpecDelete},
+	}
+	if len(plan.Changes) != len(expected) {
+		t.Fatalf("expected changes %+v, got %+v", expected, plan.Changes)
+	}
+	for i, change := range plan.Changes {
+		if change.Kind != expected[i].Kind || change.Name != expected[i].Name || change.Action != expected[i].Action {
+			t.Fatalf("expected change %d to be %+v, got %+v", i, expected[i], change)
+		}
+	}
+	if fields := plan.Changes[4].Fields; len(fields) != 1 || fields[0] != "tags" {
+		t.Fatalf("expected only the tags of dev to change, got %v", fields)
+	}
+
+	if plan, err := Plan(desired, desired); err != nil || len(plan.Changes) != 0 {
+		t.Fatalf("expected no changes between equal specs, got %+v, %v", plan, err)
+	}
+
+	moved := &types.OrgSpec{Units: []types.UnitSpec{{Name: "old"}, {Name: "old-child"}, {Name: "workloads"}}}
+	if _, err := Plan(current, moved); err == nil {
+		t.Fatalf("expected moving a unit to be rejected")
+	}
+}
+
+func TestExportOfSuspendedAccounts(t *testing.T) {
+	accounts := []*types.Account{
+		{AccountName: "prod", Unit: "workloads", Status: types.Created},
+		{AccountName: "dev", Unit: "suspended", Status: types.Suspended, Closure: &types.AccountClosure{SuspendedUnit: "suspended", Unit: "workloads"}},
+	}
+
+	exported := Export(&types.Organization{}, nil, accounts)
+	if len(exported.Accounts) != 2 || exported.Accounts[0].Name != "dev" || exported.Accounts[1].Name != "prod" {
+		t.Fatalf("expected the accounts sorted by name, got %+v", exported.Accounts)
+	}
+	if exported.Accounts[0].Unit != "workloads" {
+		t.Fatalf("expected the suspended account in the unit it's restored to, got %+v", exported.Accounts[0])
+	}
+}
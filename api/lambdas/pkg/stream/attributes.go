@@ -0,0 +1,62 @@
+package stream
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func AttributeValueMapFrom(m map[string]events.DynamoDBAttributeValue) *map[string]*dynamodb.AttributeValue {
+	result := map[string]*dynamodb.AttributeValue{}
+	for k, v := range m {
+		result[k] = AttributeValueFrom(v)
+	}
+	return &result
+}
+
+// AttributeValueFrom converts from events.DynamoDBAttributeValue to dynamodb.AttributeValue
+func AttributeValueFrom(from events.DynamoDBAttributeValue) *dynamodb.AttributeValue {
+	attr := dynamodb.AttributeValue{}
+	switch from.DataType() {
+	case events.DataTypeBinary:
+		return attr.SetB(from.Binary())
+	case events.DataTypeBinarySet:
+		return attr.SetBS(from.BinarySet())
+	case events.DataTypeBoolean:
+		return attr.SetBOOL(from.Boolean())
+	case events.DataTypeList:
+		var vs []*dynamodb.AttributeValue
+		for _, v := range from.List() {
+			lv := AttributeValueFrom(v)
+			vs = append(vs, lv)
+		}
+		return attr.SetL(vs)
+	case events.DataTypeMap:
+		mv := map[string]*dynamodb.AttributeValue{}
+		for k, v := range from.Map() {
+			mv[k] = AttributeValueFrom(v)
+		}
+		return attr.SetM(mv)
+	case events.DataTypeNull:
+		return attr.SetNULL(from.IsNull())
+	case events.DataTypeNumber:
+		return attr.SetN(from.Number())
+	case events.DataTypeNumberSet:
+		var ns []*string
+		for _, v := range from.NumberSet() {
+			ns = append(ns, &v)
+		}
+		return attr.SetNS(ns)
+	case events.DataTypeString:
+		return attr.SetS(from.String())
+	case events.DataTypeStringSet:
+		var ss []*string
+		for _, v := range from.StringSet() {
+			ss = append(ss, &v)
+		}
+		return attr.SetSS(ss)
+	default:
+		panic(fmt.Errorf("unknown ddb type: %v", from.DataType()))
+	}
+}
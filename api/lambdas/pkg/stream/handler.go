@@ -0,0 +1,480 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/google/uuid"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/iac"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// Provisioner applies the infrastructure for an account
+type Provisioner func(ctx context.Context, account *types.Account, org *types.Organization, onEvent iac.EventSink) (*iac.Provisioned, error)
+
+// Actor is the actor of the audit entries of state transitions the stream processor makes
+const Actor = "system:stream-processor"
+
+// Previewer runs a dry-run of an account's stack
+type Previewer func(ctx context.Context, account *types.Account, org *types.Organization) (*types.PreviewDiff, error)
+
+// previewLease is how long a run owns a preview. It exceeds the timeout of the stream processor, so a preview is
+// only run again if the run that claimed it was cut off
+const previewLease = 15 * time.Minute
+
+// deploymentLease is how long a deployment owns its account when the context has no deadline. It exceeds the timeout
+// of the stream processor
+const deploymentLease = 15 * time.Minute
+
+// OrgApplier applies the org stack with the given units and policies and returns their AWS IDs by name. Policies are
+// attached to the accounts in accountIDs, which holds the IDs of the AWS accounts by account name
+type OrgApplier func(ctx context.Context, org *types.Organization, units []*types.OrganizationalUnit, policies []*types.ServiceControlPolicy, accountIDs map[string]string) (*types.OrgResources, error)
+
+type Handler struct {
+	accountsDb    *db.AccountDB
+	orgDb         *db.OrganizationDB
+	deploymentsDb *db.DeploymentDB
+	unitsDb       *db.UnitDB
+	policiesDb    *db.PolicyDB
+	auditDb       *db.AuditDB
+	previewsDb    *db.PreviewDB
+	provision     Provisioner
+	applyOrg      OrgApplier
+	preview       Previewer
+}
+
+func NewHandler(tables *db.Tables, provision Provisioner, applyOrg OrgApplier, preview Previewer) *Handler {
+	return &Handler{
+		accountsDb:    tables.Accounts,
+		orgDb:         tables.Organizations,
+		deploymentsDb: tables.Deployments,
+		unitsDb:       tables.Units,
+		policiesDb:    tables.Policies,
+		auditDb:       tables.Audit,
+		previewsDb:    tables.Previews,
+		provision:     provision,
+		applyOrg:      applyOrg,
+		preview:       preview,
+	}
+}
+
+func (h *Handler) Handle(ctx context.Context, e events.DynamoDBEvent) error {
+	// the org stack contains all units and policies of an org, so it's applied at most once per org and batch
+	appliedOrgs := map[string]bool{}
+
+	for _, record := range e.Records {
+		if record.EventName != "INSERT" && record.EventName != "MODIFY" && record.EventName != "REMOVE" {
+			continue
+		}
+
+		fmt.Printf("Processing request data for event ID %s, type %s.\n", record.EventID, record.EventName)
+		handleRecord := false
+		handleOrg := false
+		handlePreview := false
+		for name, value := range record.Change.Keys {
+			if name == "pk" {
+				if value.DataType() != events.DataTypeString {
+					fmt.Printf("Received invalid record that does not have a string as pk")
+					break
+				}
+
+				if strings.HasPrefix(value.String(), "ACC#") {
+					handleRecord = record.EventName != "REMOVE"
+					break
+				}
+				if strings.HasPrefix(value.String(), "OU#") || strings.HasPrefix(value.String(), "SCP#") {
+					handleOrg = true
+					break
+				}
+				// previews are only run when they are requested, their results are written back to the same item
+				if strings.HasPrefix(value.String(), "PREVIEW#") {
+					handlePreview = record.EventName == "INSERT"
+					break
+				}
+			}
+		}
+
+		if handlePreview {
+			keys := AttributeValueMapFrom(record.Change.Keys)
+			// the PK has the form of "PREVIEW#:userId" and the SK of "ORG#:orgName#ACC#:accountName#PREVIEW#:previewID"
+			userId := strings.Split(*(*keys)["pk"].S, "#")[1]
+			sk := strings.Split(*(*keys)["sk"].S, "#")
+			if err := h.runPreview(ctx, userId, sk[1], sk[3], sk[5]); err != nil {
+				return err
+			}
+		}
+
+		if handleOrg {
+			keys := AttributeValueMapFrom(record.Change.Keys)
+			// the PK has the form of "OU#:userId" or "SCP#:userId" and the SK of "ORG#:orgName#OU#:unitName"
+			// or "ORG#:orgName#SCP#:policyName"
+			userId := strings.Split(*(*keys)["pk"].S, "#")[1]
+			orgName := strings.Split(*(*keys)["sk"].S, "#")[1]
+
+			if appliedOrgs[userId+"#"+orgName] {
+				continue
+			}
+			if record.EventName != "REMOVE" {
+				applied, err := isApplied(record)
+				if err != nil {
+					return err
+				}
+				// fast path for records written by the processor itself
+				if applied {
+					continue
+				}
+			}
+
+			if err := h.reconcileOrg(ctx, record.EventID, userId, orgName); err != nil {
+				return err
+			}
+			appliedOrgs[userId+"#"+orgName] = true
+		}
+
+		if handleRecord {
+			var acc db.AccountItem
+			newImage := AttributeValueMapFrom(record.Change.NewImage)
+			err := dynamodbattribute.UnmarshalMap(*newImage, &acc)
+			if err != nil {
+				return err
+			}
+
+			// the PK has the form of "ACC#:userId" => index 1 is the username
+			userId := strings.Split(*(*newImage)["pk"].S, "#")[1]
+			// the SK has the form of "ORG#:orgName#ACC#:accountName" => index 1 is the name of the org
+			orgName := strings.Split(*(*newImage)["sk"].S, "#")[1]
+
+			// fast path for records written by the processor itself, e.g. status updates
+			if acc.Version <= acc.LastHandledVersion() {
+				fmt.Printf("Ignoring account '%s' in org '%s'. Version %d is already applied\n", acc.AccountName, orgName, acc.Version)
+				continue
+			}
+
+			if err := h.reconcile(ctx, record.EventID, userId, orgName, acc.AccountName); err != nil {
+				return err
+			}
+
+			// policies can only be attached to accounts that exist in the AWS organization, so the org stack is
+			// applied again after an account it attaches policies to was created
+			if acc.Status == int(types.Pending) {
+				attached, err := h.hasPolicies(userId, orgName, acc.AccountName)
+				if err != nil {
+					return err
+				}
+				if attached {
+					if err := h.reconcileOrg(ctx, record.EventID, userId, orgName); err != nil {
+						return err
+					}
+					appliedOrgs[userId+"#"+orgName] = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// reconcile applies the desired state of an account until the latest version is applied. Stream records can be
+// stale or arrive more than once, so the item is re-read and every status transition is conditional on its version.
+// The transitions are audited with the ID of the stream record as request ID
+func (h *Handler) reconcile(ctx context.Context, eventID string, userId string, orgName string, accountName string) error {
+	deploymentID := ""
+	for {
+		version, handledVersion, leaseUntil, account, err := h.accountsDb.GetItemWithVersions(userId, orgName, accountName)
+		if err != nil {
+			fmt.Printf("failed to retrieve account: %s", err.Error())
+			return err
+		}
+		if account == nil {
+			fmt.Printf("account does not exist anymore")
+			return nil
+		}
+		if version <= handledVersion {
+			return nil
+		}
+		// a deployment that didn't end is resumed once its lease passed. Until then the record is retried
+		if account.LastDeploymentID != deploymentID && time.Now().Before(leaseUntil) {
+			return fmt.Errorf("deployment %s of account '%s' is still running", account.LastDeploymentID, accountName)
+		}
+
+		// an account can only be applied into a unit that exists in AWS. The records of units are on a different
+		// shard than the ones of accounts, so the unit might not be applied yet. Like failing to read the org,
+		// that's not a failure of the account: the record is retried without touching the account
+		org, err := h.orgDb.GetItem(userId, orgName, false)
+		if err != nil {
+			fmt.Printf("failed to retrieve org: %s", err.Error())
+			return err
+		}
+		if org == nil {
+			fmt.Printf("org does not exist")
+			return nil
+		}
+		if err := h.unitsDb.ResolveParent(userId, orgName, account); err != nil {
+			fmt.Printf("failed to resolve the parent of account '%s': %s", accountName, err.Error())
+			return err
+		}
+
+		// a resumed deployment keeps creating the account
+		status := types.Updating
+		if account.Status == types.Pending || account.Status == types.CreatingAccount {
+			status = types.CreatingAccount
+		}
+		fmt.Printf("Applying account '%s' in org '%s' (current version %d, handled version %d)\n", accountName, orgName, version, handledVersion)
+
+		deploymentID = uuid.NewString()
+		err = h.accountsDb.StartDeployment(userId, orgName, accountName, version, status, deploymentID, deploymentLeaseUntil(ctx))
+		if db.IsConditionalCheckFailed(err) {
+			// the account changed in the meantime, start over with the latest version
+			continue
+		}
+		if err != nil {
+			fmt.Printf("failed to update item type: %s", err.Error())
+			return err
+		}
+		h.auditDb.RecordTransition(Actor, eventID, userId, orgName, accountName, version, status, types.AuditSucceeded, "started deployment "+deploymentID)
+		version++
+
+		provisioned, err := h.provision(ctx, account, org, h.deploymentLog(userId, orgName, accountName, deploymentID))
+		if err != nil {
+			fmt.Printf("failed to apply stack: %s", err.Error())
+			// accounts waiting to be closed stay suspended, so the closure can still be cancelled or completed
+			failed := types.Failed
+			if account.Closure != nil {
+				failed = types.Suspended
+			}
+			statusErr := h.accountsDb.MarkFailed(userId, orgName, accountName, version, failed)
+			if db.IsConditionalCheckFailed(statusErr) {
+				// a newer desired state arrived while applying, which supersedes this failure
+				continue
+			}
+			if statusErr != nil {
+				fmt.Printf("failed to mark account as failed: %s", statusErr.Error())
+			} else {
+				h.auditDb.RecordTransition(Actor, eventID, userId, orgName, accountName, version, failed, types.AuditFailed, err.Error())
+			}
+			return err
+		}
+
+		// accounts waiting to be closed stay suspended, e.g. after they were moved to the suspended unit
+		applied := types.Created
+		if account.Closure != nil {
+			applied = types.Suspended
+		}
+		err = h.accountsDb.MarkProvisioned(userId, orgName, accountName, version, applied, provisioned.AccountID, provisioned.UpdateVersion)
+		if db.IsConditionalCheckFailed(err) {
+			// a newer desired state arrived while applying
+			continue
+		}
+		if err != nil {
+			fmt.Printf("failed to update item type: %s", err.Error())
+			return err
+		}
+		h.auditDb.RecordTransition(Actor, eventID, userId, orgName, accountName, version, applied, types.AuditSucceeded, "")
+	}
+}
+
+// deploymentLeaseUntil is when a deployment started now stops owning its account. That's the end of the invocation,
+// a retry of the record after a timeout resumes the deployment
+func deploymentLeaseUntil(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(deploymentLease)
+}
+
+// isApplied tells whether the unit or policy of a record has no changes the org stack didn't apply yet
+func isApplied(record events.DynamoDBEventRecord) (bool, error) {
+	newImage := *AttributeValueMapFrom(record.Change.NewImage)
+	if strings.HasPrefix(*newImage["pk"].S, "SCP#") {
+		var policy db.PolicyItem
+		if err := dynamodbattribute.UnmarshalMap(newImage, &policy); err != nil {
+			return false, err
+		}
+		return policy.Version <= policy.AppliedVersion, nil
+	}
+
+	var unit db.UnitItem
+	if err := dynamodbattribute.UnmarshalMap(newImage, &unit); err != nil {
+		return false, err
+	}
+	return unit.Version <= unit.AppliedVersion, nil
+}
+
+// hasPolicies tells whether policies are attached to an account directly
+func (h *Handler) hasPolicies(userId string, orgName string, accountName string) (bool, error) {
+	policies, err := h.policiesDb.ListItems(userId, orgName)
+	if err != nil {
+		return false, err
+	}
+	for _, policy := range policies {
+		for _, name := range policy.Accounts {
+			if name == accountName {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// reconcileOrg applies the org stack with the current units and policies of an org and records the applied versions
+func (h *Handler) reconcileOrg(ctx context.Context, eventID string, userId string, orgName string) error {
+	items, err := h.unitsDb.ListVersionedItems(userId, orgName, true)
+	if err != nil {
+		fmt.Printf("failed to list units: %s", err.Error())
+		return err
+	}
+	policyItems, err := h.policiesDb.ListVersionedItems(userId, orgName, true)
+	if err != nil {
+		fmt.Printf("failed to list policies: %s", err.Error())
+		return err
+	}
+
+	org, err := h.orgDb.GetItem(userId, orgName, false)
+	if err != nil {
+		fmt.Printf("failed to retrieve org: %s", err.Error())
+		return err
+	}
+	if org == nil {
+		fmt.Printf("org does not exist")
+		return nil
+	}
+
+	// policies are attached to the AWS accounts that were created or imported so far
+	accounts, err := h.accountsDb.ListItems(userId, orgName, "")
+	if err != nil {
+		fmt.Printf("failed to list accounts: %s", err.Error())
+		return err
+	}
+	accountIDs := map[string]string{}
+	for _, account := range accounts {
+		if account.AccountID != "" {
+			accountIDs[account.AccountName] = account.AccountID
+		}
+	}
+
+	units := make([]*types.OrganizationalUnit, len(items))
+	for i, item := range items {
+		units[i] = item.ToUnit()
+	}
+
+	policies := make([]*types.ServiceControlPolicy, len(policyItems))
+	for i, item := range policyItems {
+		policies[i] = item.ToPolicy()
+	}
+
+	fmt.Printf("Applying %d organizational units and %d policies of org '%s'\n", len(units), len(policies), orgName)
+	ids, err := h.applyOrg(ctx, org, units, policies, accountIDs)
+	entry := &types.AuditEntry{RequestID: eventID, Action: "org.apply", Outcome: types.AuditSucceeded}
+	if err != nil {
+		entry.Outcome = types.AuditFailed
+		entry.Message = err.Error()
+	}
+	h.auditDb.Record(Actor, userId, orgName, entry)
+	if err != nil {
+		fmt.Printf("failed to apply org stack: %s", err.Error())
+		return err
+	}
+
+	// units and policies that changed in the meantime are applied again once their own record arrives
+	for _, item := range items {
+		err := h.unitsDb.MarkApplied(userId, orgName, item.Name, item.Version, ids.Units[item.Name])
+		if err != nil && !db.IsConditionalCheckFailed(err) {
+			fmt.Printf("failed to mark unit '%s' as applied: %s", item.Name, err.Error())
+			return err
+		}
+	}
+	for _, item := range policyItems {
+		err := h.policiesDb.MarkApplied(userId, orgName, item.Name, item.Version, ids.Policies[item.Name])
+		if err != nil && !db.IsConditionalCheckFailed(err) {
+			fmt.Printf("failed to mark policy '%s' as applied: %s", item.Name, err.Error())
+			return err
+		}
+	}
+
+	// accounts whose unit didn't exist yet were left for the retries of their record, which can run out before the
+	// org stack is applied. They are applied now instead
+	for _, item := range items {
+		if item.UnitID != "" || ids.Units[item.Name] == "" {
+			continue
+		}
+		if err := h.reconcileUnitAccounts(ctx, eventID, userId, orgName, item.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileUnitAccounts applies the accounts of a unit that have changes which weren't handled yet
+func (h *Handler) reconcileUnitAccounts(ctx context.Context, eventID string, userId string, orgName string, unitName string) error {
+	accounts, err := h.accountsDb.ListItems(userId, orgName, "")
+	if err != nil {
+		fmt.Printf("failed to list accounts: %s", err.Error())
+		return err
+	}
+	for _, account := range accounts {
+		if account.Unit != unitName {
+			continue
+		}
+		if err := h.reconcile(ctx, eventID, userId, orgName, account.AccountName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPreview runs a requested preview of an account's stack and stores its result. Failed previews are results as
+// well, only failing to store the result fails the record
+func (h *Handler) runPreview(ctx context.Context, userId string, orgName string, accountName string, previewID string) error {
+	err := h.previewsDb.Start(userId, orgName, accountName, previewID, time.Now().UTC(), previewLease)
+	if db.IsConditionalCheckFailed(err) {
+		// another run owns the preview or finished it, e.g. because the record was delivered again
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("failed to start preview: %s", err.Error())
+		return err
+	}
+
+	fmt.Printf("Previewing account '%s' in org '%s'\n", accountName, orgName)
+	diff, err := h.previewAccount(ctx, userId, orgName, accountName)
+	if err != nil {
+		fmt.Printf("failed to preview stack: %s", err.Error())
+	}
+	if err := h.previewsDb.Finish(userId, orgName, accountName, previewID, diff, err, time.Now().UTC()); err != nil {
+		fmt.Printf("failed to store preview: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (h *Handler) previewAccount(ctx context.Context, userId string, orgName string, accountName string) (*types.PreviewDiff, error) {
+	org, err := h.orgDb.GetItem(userId, orgName, false)
+	if err != nil {
+		return nil, err
+	}
+	account, err := h.accountsDb.GetItem(userId, orgName, accountName, true)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil || account == nil {
+		return nil, fmt.Errorf("account does not exist anymore")
+	}
+	if err := h.unitsDb.ResolveParent(userId, orgName, account); err != nil {
+		return nil, err
+	}
+	return h.preview(ctx, account, org)
+}
+
+// deploymentLog stores the events of a deployment. Failing to store an event doesn't fail the deployment
+func (h *Handler) deploymentLog(userID string, orgName string, accountName string, deploymentID string) iac.EventSink {
+	return func(event types.DeploymentEvent) {
+		if err := h.deploymentsDb.PutEvent(userID, orgName, accountName, deploymentID, event); err != nil {
+			fmt.Printf("failed to store deployment event %d: %s\n", event.Sequence, err.Error())
+		}
+	}
+}
@@ -2,6 +2,8 @@ package types
 
 import (
     "fmt"
+    "strings"
+    "time"
 )
 
 type AccountStatus int
@@ -11,6 +13,12 @@ const (
     CreatingAccount
 	Created
 	Failed
+	// Updating means changes to the desired state of a created account are being applied
+	Updating
+	// Suspended means the account is going to be closed once the grace period of its closure ended
+	Suspended
+	// Closing means the stack of a suspended account is being destroyed, which closes the AWS account
+	Closing
 )
 
 func (e AccountStatus) String() string {
@@ -23,24 +31,437 @@ func (e AccountStatus) String() string {
 		return "Failed"
     case CreatingAccount:
         return "CreatingAccount"
+	case Updating:
+		return "Updating"
+	case Suspended:
+		return "Suspended"
+	case Closing:
+		return "Closing"
 	default:
 		panic(fmt.Errorf("unknown AccountStatus: %d", e))
 	}
 }
 
+type BackendType string
+
+const (
+	PulumiCloudBackend BackendType = "pulumi-cloud"
+	S3Backend          BackendType = "s3"
+	AzureBlobBackend   BackendType = "azblob"
+	GCSBackend         BackendType = "gcs"
+	LocalBackend       BackendType = "file"
+)
+
+type SecretsProviderType string
+
+const (
+	DefaultSecretsProvider       SecretsProviderType = "default"
+	PassphraseSecretsProvider    SecretsProviderType = "passphrase"
+	AwsKmsSecretsProvider        SecretsProviderType = "awskms"
+	AzureKeyVaultSecretsProvider SecretsProviderType = "azurekeyvault"
+	GcpKmsSecretsProvider        SecretsProviderType = "gcpkms"
+)
+
+// Backend configures where the Pulumi state of an organization's stacks is kept and how secrets are encrypted.
+// An empty backend type means Pulumi Cloud.
+type Backend struct {
+	Type BackendType `json:"type"`
+	// URL of the backend, e.g. s3://my-bucket/festus. Optional for Pulumi Cloud
+	URL             string          `json:"url,omitempty"`
+	SecretsProvider SecretsProvider `json:"secretsProvider"`
+}
+
+type SecretsProvider struct {
+	Type SecretsProviderType `json:"type"`
+	// KeyID identifies the KMS key for the awskms, azurekeyvault and gcpkms providers, e.g. alias/festus or
+	// arn:aws:kms:us-west-2:111122223333:key/1234 for awskms, https://festus.vault.azure.net/keys/state for
+	// azurekeyvault and projects/acme/locations/global/keyRings/festus/cryptoKeys/state for gcpkms
+	KeyID string `json:"keyID,omitempty"`
+	// Region of the awskms key. Required unless KeyID is an ARN, which contains the region
+	Region string `json:"region,omitempty"`
+	// TODO: the passphrase should be encrypted or stored in ESC
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
 type Organization struct {
-	OrgName                  string `json:"orgName"`
-	PulumiAccessToken        string `json:"pulumiAccessToken"`
-	OrgManagementEnvironment string `json:"orgManagementEnvironment"`
+	OrgName                  string  `json:"orgName"`
+	PulumiAccessToken        string  `json:"pulumiAccessToken,omitempty"`
+	OrgManagementEnvironment string  `json:"orgManagementEnvironment"`
+	Backend                  Backend `json:"backend"`
+	// PulumiOrg is the Pulumi Cloud organization ESC environments are created in. Accounts of the org trust the
+	// Pulumi OIDC issuer for it, so the environments can vend short-lived credentials. Unset disables the bootstrap.
+	PulumiOrg string `json:"pulumiOrg,omitempty"`
+	// TODO: like for accounts, the management account creds should come from ESC. They are used for the org stack
+	AwsAccessKey    string `json:"awsAccessKey,omitempty"`
+	AwsSecretKey    string `json:"awsSecretKey,omitempty"`
+	AwsSessionToken string `json:"awsSessionToken,omitempty"`
+	// Baselines are applied to every account of the org, in this order
+	Baselines []BaselineConfig `json:"baselines,omitempty"`
+	// SuspendedUnit is the organizational unit accounts are moved to when they are closed. Unset leaves them in place
+	SuspendedUnit string `json:"suspendedUnit,omitempty"`
+	// ClosureGracePeriodDays is the number of days closed accounts stay suspended before they are actually closed.
+	// Zero means DefaultClosureGracePeriodDays
+	ClosureGracePeriodDays int `json:"closureGracePeriodDays,omitempty"`
+	// EmailTemplate renders the email of accounts that are created without one, e.g. "aws+{org}-{account}@example.com".
+	// {org} and {account} are replaced by the names of the org and the account
+	EmailTemplate string `json:"emailTemplate,omitempty"`
+	// NamingRules constrain the names of new accounts. Unset allows every valid name
+	NamingRules *NamingRules `json:"namingRules,omitempty"`
+}
+
+// NamingRules are the conventions new accounts of an org have to follow, e.g. names of the form "<team>-<env>"
+type NamingRules struct {
+	// Pattern is a regular expression the whole account name has to match
+	Pattern string `json:"pattern,omitempty"`
+	// RequiredPrefixes lists the prefixes of which account names have to start with one
+	RequiredPrefixes []string `json:"requiredPrefixes,omitempty"`
+	// ReservedNames can't be used as account names, regardless of their case
+	ReservedNames []string `json:"reservedNames,omitempty"`
+	// MaxAccountsPerUnit limits the accounts of an organizational unit. Zero means no limit
+	MaxAccountsPerUnit int `json:"maxAccountsPerUnit,omitempty"`
+}
+
+const DefaultClosureGracePeriodDays = 7
+
+const (
+	OrgPlaceholder     = "{org}"
+	AccountPlaceholder = "{account}"
+)
+
+// AccountEmail renders the email template of the org for an account. It's empty if the org has no template
+func (org *Organization) AccountEmail(accountName string) string {
+	if org.EmailTemplate == "" {
+		return ""
+	}
+	return strings.NewReplacer(OrgPlaceholder, org.OrgName, AccountPlaceholder, accountName).Replace(org.EmailTemplate)
+}
+
+// Redacted returns a copy of the org without its credentials and the passphrase of its secrets provider. They are
+// only needed to apply stacks, so responses never contain them
+func (org *Organization) Redacted() *Organization {
+	redacted := *org
+	redacted.PulumiAccessToken = ""
+	redacted.AwsAccessKey = ""
+	redacted.AwsSecretKey = ""
+	redacted.AwsSessionToken = ""
+	redacted.Backend.SecretsProvider.Passphrase = ""
+	return &redacted
+}
+
+// ClosureGracePeriod returns how long closed accounts of the org can be restored
+func (org *Organization) ClosureGracePeriod() time.Duration {
+	days := org.ClosureGracePeriodDays
+	if days == 0 {
+		days = DefaultClosureGracePeriodDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// BaselineConfig selects a baseline of the baseline registry and sets its parameters
+type BaselineConfig struct {
+	Name   string            `json:"name" yaml:"name"`
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// MemberRole is the role of a user in an org. The user who created an org is always one of its owners
+type MemberRole string
+
+const (
+	// OwnerRole has full access, including deleting the org and managing other owners
+	OwnerRole MemberRole = "owner"
+	// AdminRole manages the org's settings, units, policies and members
+	AdminRole MemberRole = "admin"
+	// OperatorRole manages accounts
+	OperatorRole MemberRole = "operator"
+	// ViewerRole has read-only access
+	ViewerRole MemberRole = "viewer"
+)
+
+// Member grants a user access to an org created by someone else
+type Member struct {
+	UserID    string     `json:"userID"`
+	Role      MemberRole `json:"role"`
+	InvitedBy string     `json:"invitedBy,omitempty"`
+	InvitedAt *time.Time `json:"invitedAt,omitempty"`
+}
+
+// APIKey authenticates automation clients like CI pipelines. A key only has access to the org it was created for,
+// limited to its scopes
+type APIKey struct {
+	// ID is the public part of the key. It identifies the key without revealing the secret
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt defaults to DefaultAPIKeyLifetime after the key was created
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	CreatedBy  string     `json:"createdBy,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	// Key is the secret API key. It's only returned when the key is created, festus just stores a hash of it
+	Key string `json:"key,omitempty"`
+}
+
+const DefaultAPIKeyLifetime = 90 * 24 * time.Hour
+
+type AuditOutcome string
+
+const (
+	AuditSucceeded AuditOutcome = "succeeded"
+	AuditFailed    AuditOutcome = "failed"
+	// AuditDenied marks requests that were rejected because the actor lacked a permission
+	AuditDenied AuditOutcome = "denied"
+)
+
+// AuditEntry records a mutation of an org or of something in it, either by a request or by a state transition of
+// the stream processor. Entries are never changed or deleted
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	// Actor is the user or API key that sent the request, or the festus component that made the transition
+	Actor     string `json:"actor"`
+	RequestID string `json:"requestID,omitempty"`
+	// Action names the mutation, e.g. "account.create" or "account.status"
+	Action string `json:"action"`
+	// Target is the path of the changed resource within the org, e.g. "accounts/dev"
+	Target        string       `json:"target"`
+	BeforeVersion *int         `json:"beforeVersion,omitempty"`
+	AfterVersion  *int         `json:"afterVersion,omitempty"`
+	Outcome       AuditOutcome `json:"outcome"`
+	// Status is the HTTP status code of the request or the account status after a transition
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// OrganizationalUnit groups accounts of an org. Units are nested by referencing their parent unit by name,
+// units without a parent are placed in the root of the AWS organization.
+type OrganizationalUnit struct {
+	Name   string            `json:"name"`
+	Parent string            `json:"parent,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	// UnitID is the ID of the AWS organizational unit. It is empty until the org stack created the unit
+	UnitID string `json:"unitID,omitempty"`
+	// Applied tells whether the latest changes to the unit were applied by the org stack
+	Applied bool `json:"applied"`
+}
+
+// ServiceControlPolicy is a guardrail of an org. It's attached to units and accounts of the org by name
+type ServiceControlPolicy struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Document is the JSON policy document
+	Document string   `json:"document"`
+	Units    []string `json:"units,omitempty"`
+	Accounts []string `json:"accounts,omitempty"`
+	// PolicyID is the ID of the AWS policy. It is empty until the org stack created the policy
+	PolicyID string `json:"policyID,omitempty"`
+	// Applied tells whether the latest changes to the policy were applied by the org stack
+	Applied bool `json:"applied"`
+}
+
+// EffectivePolicy is a policy that applies to an account, either because it's attached to the account or to one
+// of the units the account is nested in. AttachedTo is "account/<name>" or "unit/<name>"
+type EffectivePolicy struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Document    string `json:"document"`
+	AttachedTo  string `json:"attachedTo"`
+}
+
+// OrgResources are the AWS IDs of the resources managed by the org stack, by name
+type OrgResources struct {
+	Units    map[string]string
+	Policies map[string]string
 }
 
 type Account struct {
 	AccountName     string        `json:"accountName"`
 	Email           string        `json:"email"`
 	ParentID        string        `json:"parentID"`
+	// Unit is the name of the organizational unit the account belongs to. It takes precedence over ParentID
+	Unit            string        `json:"unit,omitempty"`
     // TODO: The AWS creds shouldn't be passed in with the request but rather retrieved from ESC or some other short lived credential service
 	AwsAccessKey    string        `json:"awsAccessKey"`
-	AwsSecretKey    string        `json:"awsSecretKey"`
-	AwsSessionToken string        `json:"awsSessionToken"`
+	AwsSecretKey    string        `json:"awsSecretKey,omitempty"`
+	AwsSessionToken string        `json:"awsSessionToken,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	// ParentHistory lists the moves of the account between organizational units, oldest first
+	ParentHistory   []ParentMove  `json:"parentHistory,omitempty"`
 	Status          AccountStatus `json:"status"`
+	// LastDeploymentID identifies the deployment log of the most recent provisioning run
+	LastDeploymentID string `json:"lastDeploymentID,omitempty"`
+	// LastUpdateVersion is the version of the Pulumi update that ran the deployment LastDeploymentID, as listed in
+	// the update history of the account's stack. It's set once the deployment succeeded
+	LastUpdateVersion int `json:"lastUpdateVersion,omitempty"`
+	DriftStatus      DriftStatus `json:"driftStatus,omitempty"`
+	// DriftSummary lists the resources that drifted during the last drift check
+	DriftSummary   string     `json:"driftSummary,omitempty"`
+	DriftCheckedAt *time.Time `json:"driftCheckedAt,omitempty"`
+	// Closure is set while a suspended account is waiting to be closed
+	Closure *AccountClosure `json:"closure,omitempty"`
+	// AccountID is the ID of the AWS account. Imported accounts are created with it, other accounts get it once they
+	// were provisioned
+	AccountID string `json:"accountID,omitempty"`
+	// Imported is set for accounts that adopted an existing AWS account
+	Imported bool `json:"imported,omitempty"`
+	// AccessRole is the IAM role inside an imported account that is assumed to apply the baselines and ESC roles.
+	// Imported accounts without one don't get them. Created accounts use the role AWS Organizations created in them
+	AccessRole string `json:"accessRole,omitempty"`
+}
+
+// Redacted returns a copy of the account without its AWS credentials. Only the access key ID identifies them
+func (account *Account) Redacted() *Account {
+	redacted := *account
+	redacted.AwsSecretKey = ""
+	redacted.AwsSessionToken = ""
+	return &redacted
+}
+
+// AccountClosure describes the pending closure of an account
+type AccountClosure struct {
+	RequestedAt time.Time `json:"requestedAt"`
+	// CloseAfter is the end of the grace period. Until then the closure can be cancelled
+	CloseAfter time.Time `json:"closeAfter"`
+	// SuspendedUnit is the unit the account was moved to. Unit and ParentID are where it was placed before
+	SuspendedUnit string `json:"suspendedUnit,omitempty"`
+	Unit          string `json:"unit,omitempty"`
+	ParentID      string `json:"parentID,omitempty"`
+}
+
+// ParentMove records a move of an account from one parent (root or organizational unit) to another
+type ParentMove struct {
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	FromUnit string    `json:"fromUnit,omitempty"`
+	ToUnit   string    `json:"toUnit,omitempty"`
+	MovedAt  time.Time `json:"movedAt"`
+}
+
+type DriftStatus string
+
+const (
+	InSync           DriftStatus = "in-sync"
+	Drifted          DriftStatus = "drifted"
+	DriftCheckFailed DriftStatus = "check-failed"
+)
+
+type DeploymentEventStatus string
+
+const (
+	DeploymentEventStarted   DeploymentEventStatus = "started"
+	DeploymentEventSucceeded DeploymentEventStatus = "succeeded"
+	DeploymentEventFailed    DeploymentEventStatus = "failed"
+	DeploymentEventWarning   DeploymentEventStatus = "warning"
+	DeploymentEventError     DeploymentEventStatus = "error"
+	DeploymentEventSummary   DeploymentEventStatus = "summary"
+)
+
+// DeploymentEvent is a condensed, per-resource Pulumi engine event of a deployment
+type DeploymentEvent struct {
+	Sequence     int                   `json:"sequence"`
+	Timestamp    int                   `json:"timestamp"`
+	Status       DeploymentEventStatus `json:"status"`
+	URN          string                `json:"urn,omitempty"`
+	ResourceType string                `json:"resourceType,omitempty"`
+	Op           string                `json:"op,omitempty"`
+	Message      string                `json:"message,omitempty"`
+}
+
+// ResourceChanges counts the planned changes of a set of resources
+type ResourceChanges struct {
+	Creates  int `json:"creates"`
+	Updates  int `json:"updates"`
+	Replaces int `json:"replaces"`
+	Deletes  int `json:"deletes"`
+}
+
+// ResourceChange is a planned change of a single resource
+type ResourceChange struct {
+	URN  string `json:"urn"`
+	Type string `json:"type"`
+	Op   string `json:"op"`
+	// Diffs are the properties that change
+	Diffs []string `json:"diffs,omitempty"`
+}
+
+// PreviewDiff is the structured result of a dry-run of an account's stack
+type PreviewDiff struct {
+	Totals        ResourceChanges             `json:"totals"`
+	ResourceTypes map[string]*ResourceChanges `json:"resourceTypes"`
+	Resources     []ResourceChange            `json:"resources"`
+}
+
+type OperationStatus string
+
+const (
+	// OperationPending means the operation was requested and hasn't finished yet
+	OperationPending   OperationStatus = "pending"
+	OperationSucceeded OperationStatus = "succeeded"
+	OperationFailed    OperationStatus = "failed"
+)
+
+// OperationResult describes an operation on an account's stack. Operations run asynchronously, they are pending
+// until the stream processor finished them
+type OperationResult struct {
+	ID          string          `json:"id"`
+	Operation   string          `json:"operation"`
+	Status      OperationStatus `json:"status"`
+	RequestedAt time.Time       `json:"requestedAt"`
+	StartedAt   *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty"`
+	Diff        *PreviewDiff    `json:"diff,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+// OrgSpec is the declarative desired state of an org. It's exchanged as JSON or YAML
+type OrgSpec struct {
+	Baselines []BaselineConfig `json:"baselines,omitempty" yaml:"baselines,omitempty"`
+	Units    []UnitSpec    `json:"units" yaml:"units"`
+	Accounts []AccountSpec `json:"accounts" yaml:"accounts"`
+}
+
+type UnitSpec struct {
+	Name   string            `json:"name" yaml:"name"`
+	Parent string            `json:"parent,omitempty" yaml:"parent,omitempty"`
+	Tags   map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+type AccountSpec struct {
+	Name     string            `json:"name" yaml:"name"`
+	Email    string            `json:"email" yaml:"email"`
+	Unit     string            `json:"unit,omitempty" yaml:"unit,omitempty"`
+	ParentID string            `json:"parentID,omitempty" yaml:"parentID,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+type SpecAction string
+
+const (
+	SpecCreate SpecAction = "create"
+	SpecUpdate SpecAction = "update"
+	SpecMove   SpecAction = "move"
+	SpecDelete SpecAction = "delete"
+)
+
+type SpecKind string
+
+const (
+	SpecBaseline SpecKind = "baseline"
+	SpecUnit     SpecKind = "unit"
+	SpecAccount  SpecKind = "account"
+)
+
+// SpecChange is a single step of a plan. Fields lists the changed fields of updates. Applied is set for the changes
+// that were applied when applying a plan failed part way
+type SpecChange struct {
+	Kind    SpecKind   `json:"kind" yaml:"kind"`
+	Name    string     `json:"name" yaml:"name"`
+	Action  SpecAction `json:"action" yaml:"action"`
+	Fields  []string   `json:"fields,omitempty" yaml:"fields,omitempty"`
+	Applied bool       `json:"applied,omitempty" yaml:"applied,omitempty"`
+}
+
+// SpecPlan lists the changes that converge an org to its spec, in the order they are applied
+type SpecPlan struct {
+	Changes []SpecChange `json:"changes" yaml:"changes"`
+	Applied bool         `json:"applied" yaml:"applied"`
 }
@@ -0,0 +1,128 @@
+// Package validation checks the fields of requests against the rules AWS and Pulumi impose on them, so that invalid
+// requests are rejected by the API instead of failing during provisioning
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FieldError describes why the value of a field is invalid
+type FieldError struct {
+	// Field is the JSON path of the field, e.g. "email" or "accounts[1].email"
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors collects the invalid fields of a request. Validators return it as error, so that the API can list every
+// invalid field at once
+type Errors []FieldError
+
+// Add records that a field is invalid
+func (e *Errors) Add(field string, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Merge records the errors of a nested object under the given field
+func (e *Errors) Merge(field string, errs Errors) {
+	for _, fieldErr := range errs {
+		*e = append(*e, FieldError{Field: field + "." + fieldErr.Field, Message: fieldErr.Message})
+	}
+}
+
+// Err returns nil if no field is invalid
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		messages = append(messages, fieldErr.Field+": "+fieldErr.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+const (
+	// MaxAccountNameLength is the longest name AWS accepts for accounts
+	MaxAccountNameLength = 50
+	// MaxOrgNameLength is the longest Pulumi project name. Orgs are Pulumi projects
+	MaxOrgNameLength  = 100
+	MinEmailLength    = 6
+	MaxEmailLength    = 64
+	MaxTags           = 50
+	MaxTagKeyLength   = 128
+	MaxTagValueLength = 256
+)
+
+var (
+	// names end up in Pulumi project and stack names, which are more restrictive than AWS account names
+	namePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	// emailPattern is the pattern AWS Organizations checks account emails with
+	emailPattern    = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
+	parentIDPattern = regexp.MustCompile(`^(r-[0-9a-z]{4,32}|ou-[0-9a-z]{4,32}-[a-z0-9]{8,32})$`)
+)
+
+// Name checks the name of an account or org
+func Name(errs *Errors, field string, name string, maxLength int) {
+	switch {
+	case name == "":
+		errs.Add(field, "is required")
+	case len(name) > maxLength:
+		errs.Add(field, "must not be longer than %d characters", maxLength)
+	case !namePattern.MatchString(name):
+		errs.Add(field, "must only contain letters, digits, '.', '_' and '-'")
+	}
+}
+
+// Email checks the root email address of an account
+func Email(errs *Errors, field string, email string) {
+	switch {
+	case email == "":
+		errs.Add(field, "is required")
+	case len(email) < MinEmailLength || len(email) > MaxEmailLength:
+		errs.Add(field, "must be between %d and %d characters long", MinEmailLength, MaxEmailLength)
+	case !emailPattern.MatchString(email):
+		errs.Add(field, "must be an email address")
+	}
+}
+
+// ParentID checks the ID of a root or organizational unit, if set
+func ParentID(errs *Errors, field string, parentID string) {
+	if parentID != "" && !parentIDPattern.MatchString(parentID) {
+		errs.Add(field, "must be the ID of a root (r-xxxx) or organizational unit (ou-xxxx-xxxxxxxx)")
+	}
+}
+
+// Tags checks tags against the limits of AWS Organizations
+func Tags(errs *Errors, field string, tags map[string]string) {
+	if len(tags) > MaxTags {
+		errs.Add(field, "must not contain more than %d tags", MaxTags)
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := tags[key]
+		switch {
+		case key == "" || len(key) > MaxTagKeyLength:
+			errs.Add(field, "keys must be between 1 and %d characters long", MaxTagKeyLength)
+		case strings.HasPrefix(strings.ToLower(key), "aws:"):
+			errs.Add(field+"."+key, "the prefix 'aws:' is reserved by AWS")
+		case len(value) > MaxTagValueLength:
+			errs.Add(field+"."+key, "must not be longer than %d characters", MaxTagValueLength)
+		}
+	}
+}
+
+// NormalizeEmail returns the form of an email that two addresses share if AWS considers them the same
+func NormalizeEmail(email string) string {
+	return strings.ToLower(email)
+}
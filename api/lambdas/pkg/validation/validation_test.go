@@ -0,0 +1,78 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	if errs.Err() != nil {
+		t.Fatalf("expected no error without invalid fields")
+	}
+
+	var nested Errors
+	nested.Add("email", "is required")
+	errs.Add("accountName", "must not be longer than %d characters", 50)
+	errs.Merge("accounts[1]", nested)
+
+	err := errs.Err()
+	if err == nil {
+		t.Fatalf("expected an error for the invalid fields")
+	}
+	expected := "accountName: must not be longer than 50 characters; accounts[1].email: is required"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestFields(t *testing.T) {
+	tooManyTags := map[string]string{}
+	for i := 0; i <= MaxTags; i++ {
+		tooManyTags[strings.Repeat("k", i+1)] = "v"
+	}
+
+	for _, tc := range []struct {
+		name     string
+		validate func(errs *Errors)
+		expected []string
+	}{
+		{"valid name", func(errs *Errors) { Name(errs, "name", "dev-app_1.0", MaxAccountNameLength) }, nil},
+		{"missing name", func(errs *Errors) { Name(errs, "name", "", MaxAccountNameLength) }, []string{"name"}},
+		{"long name", func(errs *Errors) { Name(errs, "name", strings.Repeat("a", 51), MaxAccountNameLength) }, []string{"name"}},
+		{"name with a slash", func(errs *Errors) { Name(errs, "name", "dev/app", MaxAccountNameLength) }, []string{"name"}},
+		{"valid email", func(errs *Errors) { Email(errs, "email", "aws+dev@example.com") }, nil},
+		{"missing email", func(errs *Errors) { Email(errs, "email", "") }, []string{"email"}},
+		{"short email", func(errs *Errors) { Email(errs, "email", "a@b.c") }, []string{"email"}},
+		{"email without domain", func(errs *Errors) { Email(errs, "email", "aws@example") }, []string{"email"}},
+		{"no parent", func(errs *Errors) { ParentID(errs, "parentID", "") }, nil},
+		{"root", func(errs *Errors) { ParentID(errs, "parentID", "r-a1b2") }, nil},
+		{"unit", func(errs *Errors) { ParentID(errs, "parentID", "ou-a1b2-workload") }, nil},
+		{"account as parent", func(errs *Errors) { ParentID(errs, "parentID", "123456789012") }, []string{"parentID"}},
+		{"valid tags", func(errs *Errors) { Tags(errs, "tags", map[string]string{"team": "platform"}) }, nil},
+		{"reserved tag", func(errs *Errors) { Tags(errs, "tags", map[string]string{"AWS:team": "platform"}) }, []string{"tags.AWS:team"}},
+		{"long tag value", func(errs *Errors) {
+			Tags(errs, "tags", map[string]string{"team": strings.Repeat("v", MaxTagValueLength+1)})
+		}, []string{"tags.team"}},
+		{"too many tags", func(errs *Errors) { Tags(errs, "tags", tooManyTags) }, []string{"tags"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var errs Errors
+			tc.validate(&errs)
+			if len(errs) != len(tc.expected) {
+				t.Fatalf("expected invalid fields %v, got %v", tc.expected, errs)
+			}
+			for i, field := range tc.expected {
+				if errs[i].Field != field {
+					t.Fatalf("expected invalid fields %v, got %v", tc.expected, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	if NormalizeEmail("AWS+Dev@Example.com") != NormalizeEmail("aws+dev@example.com") {
+		t.Fatalf("expected emails that only differ in case to be the same")
+	}
+}
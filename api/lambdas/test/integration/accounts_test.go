@@ -0,0 +1,336 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/iac"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+const userID = "integration-user"
+
+func createOrgAndAccount(h *harness, orgName string, accountName string) types.Account {
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           orgName,
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+
+	var acc types.Account
+	h.mustRequest(http.MethodPost, fmt.Sprintf("/organizations/%s/accounts", orgName), userID, types.Account{
+		AccountName: accountName,
+		Email:       fmt.Sprintf("aws+%s@example.com", accountName),
+	}, http.StatusCreated, &acc)
+	return acc
+}
+
+func TestAccountProvisioning(t *testing.T) {
+	h := newHarness(t)
+
+	acc := createOrgAndAccount(h, "acme", "dev")
+	if acc.Status != types.Pending {
+		t.Fatalf("expected new account to be %s, got %s", types.Pending, acc.Status)
+	}
+
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Created {
+		t.Fatalf("expected account to be %s, got %s", types.Created, acc.Status)
+	}
+	if acc.AccountID != "dev_id" || acc.Imported {
+		t.Fatalf("expected the ID of the created AWS account to be stored, got %+v", acc)
+	}
+	if acc.LastDeploymentID == "" || acc.LastUpdateVersion != 1 {
+		t.Fatalf("expected the deployment to be stored with its Pulumi update, got %+v", acc)
+	}
+
+	if len(h.mocks.resources) == 0 {
+		t.Fatalf("expected the account program to register resources")
+	}
+
+	var log struct {
+		Events []types.DeploymentEvent `json:"events"`
+	}
+	h.mustRequest(http.MethodGet, fmt.Sprintf("/organizations/acme/accounts/dev/deployments/%s/events", acc.LastDeploymentID), userID, nil, http.StatusOK, &log)
+	if len(log.Events) != len(h.mocks.resources) {
+		t.Fatalf("expected %d deployment events, got %d", len(h.mocks.resources), len(log.Events))
+	}
+}
+
+func TestAccountProvisioningFailure(t *testing.T) {
+	h := newHarness(t)
+	h.provisionErr = fmt.Errorf("boom")
+
+	createOrgAndAccount(h, "acme", "dev")
+
+	if err := h.processStream(context.Background()); err == nil {
+		t.Fatalf("expected stream handler to return the provisioning error")
+	}
+
+	var acc types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Failed || acc.LastUpdateVersion != 0 {
+		t.Fatalf("expected account to be %s without a Pulumi update, got %+v", types.Failed, acc)
+	}
+
+	// a redelivered record doesn't retry a failed apply, a change of the account does
+	h.provisionErr = nil
+	if err := h.replayStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Failed {
+		t.Fatalf("expected account to stay %s after a redelivery, got %s", types.Failed, acc.Status)
+	}
+
+	h.mustRequest(http.MethodPut, "/organizations/acme/accounts/dev", userID, types.Account{
+		Email: "aws+dev@example.com",
+		Tags:  map[string]string{"retry": "true"},
+	}, http.StatusOK, nil)
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Created {
+		t.Fatalf("expected the changed account to be %s, got %s", types.Created, acc.Status)
+	}
+}
+
+func TestResumeOfInterruptedDeployment(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+	accounts := db.NewTables(h.ddb, h.tableName).Accounts
+
+	createOrgAndAccount(h, "acme", "dev")
+
+	// a deployment that was cut off before it marked the account provisioned or failed
+	leaseUntil := time.Now().Add(2 * time.Second)
+	if err := accounts.StartDeployment(userID, "acme", "dev", 0, types.CreatingAccount, "interrupted", leaseUntil); err != nil {
+		t.Fatalf("failed to start deployment: %s", err.Error())
+	}
+	version, handledVersion, _, _, err := accounts.GetItemWithVersions(userID, "acme", "dev")
+	if err != nil {
+		t.Fatalf("failed to read account: %s", err.Error())
+	}
+	if version <= handledVersion {
+		t.Fatalf("expected a started deployment to leave version %d unhandled, handled version is %d", version, handledVersion)
+	}
+
+	// the record is retried while the deployment owns the account
+	if err := h.processStream(ctx); err == nil {
+		t.Fatalf("expected the stream handler to fail while the deployment is running")
+	}
+	var acc types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.CreatingAccount || acc.LastDeploymentID != "interrupted" {
+		t.Fatalf("expected the running deployment to keep the account, got %+v", acc)
+	}
+
+	time.Sleep(time.Until(leaseUntil) + time.Second)
+	if err := h.replayStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Created || acc.AccountID != "dev_id" {
+		t.Fatalf("expected the deployment to be resumed, got %+v", acc)
+	}
+	if acc.LastDeploymentID == "interrupted" {
+		t.Fatalf("expected the resumed deployment to get a new ID")
+	}
+}
+
+func TestAccountUpdate(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	createOrgAndAccount(h, "acme", "dev")
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	var created types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &created)
+
+	var updated types.Account
+	h.mustRequest(http.MethodPut, "/organizations/acme/accounts/dev", userID, types.Account{
+		Email: "aws+dev-new@example.com",
+		Tags:  map[string]string{"team": "platform"},
+	}, http.StatusOK, &updated)
+	if updated.Email != "aws+dev-new@example.com" || updated.Tags["team"] != "platform" {
+		t.Fatalf("expected desired state to be stored, got %+v", updated)
+	}
+
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	var acc types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Created {
+		t.Fatalf("expected account to be %s after the update, got %s", types.Created, acc.Status)
+	}
+	if acc.LastDeploymentID == "" || acc.LastDeploymentID == created.LastDeploymentID || acc.LastUpdateVersion != 2 {
+		t.Fatalf("expected the update to be applied in a new deployment, got %+v", acc)
+	}
+
+	// one run for the creation and one for the update
+	runs := len(h.mocks.resources)
+	if runs != 2 {
+		t.Fatalf("expected the account program to run twice, got %d registrations", runs)
+	}
+	if email := h.mocks.resources[1].Inputs["email"]; email.StringValue() != "aws+dev-new@example.com" {
+		t.Fatalf("expected the update to apply the new email, got %s", email)
+	}
+
+	// redelivered records of applied versions must not provision again
+	if err := h.replayStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	if len(h.mocks.resources) != runs {
+		t.Fatalf("expected replayed records to be ignored")
+	}
+}
+
+func TestAccountMove(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	createOrgAndAccount(h, "acme", "dev")
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	status, _ := h.request(http.MethodPut, "/organizations/acme/accounts/dev", userID, types.Account{
+		Email:    "aws+dev@example.com",
+		ParentID: "ou-a1b2-workloads",
+	})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected updates to reject parent changes, got %d", status)
+	}
+
+	for _, parentID := range []string{"ou-a1b2-workloads", "ou-a1b2-sandboxes"} {
+		h.mustRequest(http.MethodPut, "/organizations/acme/accounts/dev/parent", userID, map[string]string{
+			"parentID": parentID,
+		}, http.StatusOK, nil)
+		if err := h.processStream(ctx); err != nil {
+			t.Fatalf("stream handler failed: %s", err.Error())
+		}
+	}
+
+	var acc types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Created || acc.ParentID != "ou-a1b2-sandboxes" {
+		t.Fatalf("expected account to be moved to ou-a1b2-sandboxes, got %+v", acc)
+	}
+	if len(acc.ParentHistory) != 2 || acc.ParentHistory[0].From != "" || acc.ParentHistory[0].To != "ou-a1b2-workloads" ||
+		acc.ParentHistory[1].From != "ou-a1b2-workloads" || acc.ParentHistory[1].To != "ou-a1b2-sandboxes" {
+		t.Fatalf("expected both moves to be recorded, got %+v", acc.ParentHistory)
+	}
+
+	last := h.mocks.resources[len(h.mocks.resources)-1]
+	if parentID := last.Inputs["parentId"]; parentID.StringValue() != "ou-a1b2-sandboxes" {
+		t.Fatalf("expected the account program to apply the new parent, got %s", parentID)
+	}
+}
+
+func TestAccountPreview(t *testing.T) {
+	h := newHarness(t)
+	createOrgAndAccount(h, "acme", "dev")
+
+	// previews take longer than API Gateway waits, so they are only requested by the API
+	res := h.proxy(http.MethodPost, "/organizations/acme/accounts/dev/preview", map[string]interface{}{"principalId": userID}, nil, nil)
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected the preview to be accepted, got %d: %s", res.StatusCode, res.Body)
+	}
+	var result types.OperationResult
+	if err := json.Unmarshal([]byte(res.Body), &result); err != nil {
+		t.Fatal(err)
+	}
+	location := res.MultiValueHeaders["Location"]
+	if result.Status != types.OperationPending || len(location) != 1 || location[0] != "/organizations/acme/accounts/dev/previews/"+result.ID {
+		t.Fatalf("expected a pending preview and its location, got %+v at %v", result, location)
+	}
+
+	var acc types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Pending {
+		t.Fatalf("expected preview to leave the account %s, got %s", types.Pending, acc.Status)
+	}
+
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodGet, location[0], userID, nil, http.StatusOK, &result)
+	if result.Status != types.OperationSucceeded || result.Diff == nil || result.FinishedAt == nil {
+		t.Fatalf("expected a successful preview with a diff, got %+v", result)
+	}
+	if result.Diff.Totals.Creates == 0 || result.Diff.Totals.Creates != len(result.Diff.Resources) {
+		t.Fatalf("expected every planned resource to be a create, got %+v", result.Diff.Totals)
+	}
+
+	// a redelivered record doesn't run the preview again
+	if err := h.replayStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	var replayed types.OperationResult
+	h.mustRequest(http.MethodGet, location[0], userID, nil, http.StatusOK, &replayed)
+	if !replayed.FinishedAt.Equal(*result.FinishedAt) {
+		t.Fatalf("expected the preview to be run once, finished at %s and %s", result.FinishedAt, replayed.FinishedAt)
+	}
+
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev/previews/unknown", userID, nil, http.StatusNotFound, nil)
+}
+
+func TestAccountsAreScopedToUser(t *testing.T) {
+	h := newHarness(t)
+
+	createOrgAndAccount(h, "acme", "dev")
+
+	status, _ := h.request(http.MethodGet, "/organizations/acme/accounts/dev", "someone-else", nil)
+	if status == http.StatusOK {
+		t.Fatalf("expected account of another user to be invisible")
+	}
+}
+
+// TestAccountStackFileBackend checks that the account stack is created in the file:// backend.
+// It needs a pulumi CLI on the PATH.
+func TestAccountStackFileBackend(t *testing.T) {
+	if _, err := exec.LookPath("pulumi"); err != nil {
+		t.Skip("pulumi CLI not found on PATH")
+	}
+	ctx := context.Background()
+
+	account := &types.Account{AccountName: "dev"}
+	org := &types.Organization{OrgName: "acme"}
+	s, err := iac.UpsertAccountStack(ctx, account, org)
+	if err != nil {
+		t.Fatalf("failed to upsert stack: %s", err.Error())
+	}
+
+	summary, err := s.Workspace().Stack(ctx)
+	if err != nil {
+		t.Fatalf("failed to read stack: %s", err.Error())
+	}
+	if summary == nil || summary.Name != "dev" {
+		t.Fatalf("expected stack 'dev' to be selected, got %+v", summary)
+	}
+
+	cfg, err := s.GetConfig(ctx, "aws:region")
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err.Error())
+	}
+	if cfg.Value == "" {
+		t.Fatalf("expected aws:region to be configured")
+	}
+}
@@ -0,0 +1,93 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestAPIKeys(t *testing.T) {
+	h := newHarness(t)
+	const operator = "operator-user"
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "other",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", userID, types.Member{UserID: operator, Role: types.OperatorRole}, http.StatusCreated, nil)
+
+	for _, key := range []types.APIKey{
+		{Scopes: []string{"org:read"}},
+		{Name: "ci"},
+		{Name: "ci", Scopes: []string{"everything"}},
+		{Name: "ci", Scopes: []string{"org:read"}, ExpiresAt: time.Now().Add(-time.Hour)},
+		{Name: "ci", Scopes: []string{"org:read"}, ExpiresAt: time.Now().Add(2 * 365 * 24 * time.Hour)},
+	} {
+		status, body := h.request(http.MethodPost, "/organizations/acme/apikeys", userID, key)
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("expected key %+v to be rejected, got %d: %s", key, status, string(body))
+		}
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/apikeys", operator, types.APIKey{
+		Name:   "ci",
+		Scopes: []string{"org:read"},
+	}, http.StatusForbidden, nil)
+
+	var key types.APIKey
+	h.mustRequest(http.MethodPost, "/organizations/acme/apikeys", userID, types.APIKey{
+		Name:   "ci",
+		Scopes: []string{"org:read", "accounts:write"},
+	}, http.StatusCreated, &key)
+	if !strings.HasPrefix(key.Key, "fst_"+key.ID+"_") || key.CreatedBy != userID {
+		t.Fatalf("expected a new fst_ key, got %+v", key)
+	}
+	if lifetime := key.ExpiresAt.Sub(key.CreatedAt); lifetime != types.DefaultAPIKeyLifetime {
+		t.Fatalf("expected the default lifetime, got %s", lifetime)
+	}
+
+	withKey := func(method string, path string, secret string, body interface{}, expectedStatus int) []byte {
+		status, resBody := h.requestWithHeaders(method, path, "apikey", body, map[string]string{"Authorization": "Bearer " + secret})
+		if status != expectedStatus {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, expectedStatus, status, string(resBody))
+		}
+		return resBody
+	}
+
+	withKey(http.MethodPost, "/organizations/acme/accounts", key.Key, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+	}, http.StatusCreated)
+	withKey(http.MethodGet, "/organizations/acme/accounts/dev", key.Key, nil, http.StatusOK)
+	withKey(http.MethodPost, "/organizations/acme/units", key.Key, types.OrganizationalUnit{Name: "workloads"}, http.StatusForbidden)
+	withKey(http.MethodGet, "/organizations/other", key.Key, nil, http.StatusForbidden)
+	withKey(http.MethodPost, "/organizations", key.Key, types.Organization{OrgName: "ci-org"}, http.StatusForbidden)
+	withKey(http.MethodGet, "/organizations/acme", key.Key[:len(key.Key)-1]+"x", nil, http.StatusUnauthorized)
+	withKey(http.MethodGet, "/organizations/acme", "fst_unknown_secret", nil, http.StatusUnauthorized)
+
+	var account types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &account)
+	if account.AccountName != "dev" {
+		t.Fatalf("expected the key to create the account in the owner's org, got %+v", account)
+	}
+
+	var keys struct {
+		APIKeys []types.APIKey `json:"apiKeys"`
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/apikeys", userID, nil, http.StatusOK, &keys)
+	if len(keys.APIKeys) != 1 || keys.APIKeys[0].ID != key.ID || keys.APIKeys[0].Key != "" || keys.APIKeys[0].LastUsedAt == nil {
+		t.Fatalf("expected the key to be listed without its secret and with its last usage, got %+v", keys.APIKeys)
+	}
+
+	h.mustRequest(http.MethodDelete, "/organizations/acme/apikeys/"+key.ID, userID, nil, http.StatusNoContent, nil)
+	h.mustRequest(http.MethodDelete, "/organizations/acme/apikeys/"+key.ID, userID, nil, http.StatusNotFound, nil)
+	withKey(http.MethodGet, "/organizations/acme", key.Key, nil, http.StatusUnauthorized)
+}
@@ -0,0 +1,146 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/stream"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+type auditPage struct {
+	Entries       []types.AuditEntry `json:"entries"`
+	NextPageToken string             `json:"nextPageToken"`
+}
+
+func TestAuditLog(t *testing.T) {
+	h := newHarness(t)
+	const viewer = "viewer-user"
+	start := time.Now().Add(-time.Second)
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", userID, types.Member{UserID: viewer, Role: types.ViewerRole}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+	}, http.StatusCreated, nil)
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h.mustRequest(http.MethodPut, "/organizations/acme/accounts/dev", userID, types.Account{
+		Email: "aws+dev-new@example.com",
+	}, http.StatusOK, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", viewer, types.Account{
+		AccountName: "prod",
+		Email:       "aws+prod@example.com",
+	}, http.StatusForbidden, nil)
+	// plans don't change anything and aren't audited
+	var current types.OrgSpec
+	h.mustRequest(http.MethodGet, "/organizations/acme/spec", userID, nil, http.StatusOK, &current)
+	h.mustRequest(http.MethodPut, "/organizations/acme/spec", userID, current, http.StatusOK, nil)
+
+	h.mustRequest(http.MethodGet, "/organizations/acme/audit", viewer, nil, http.StatusForbidden, nil)
+
+	var log auditPage
+	h.mustRequest(http.MethodGet, "/organizations/acme/audit", userID, nil, http.StatusOK, &log)
+	var actions []string
+	for _, entry := range log.Entries {
+		actions = append(actions, entry.Action+" "+entry.Target+" "+string(entry.Outcome))
+	}
+	// newest first
+	expected := []string{
+		"account.create accounts/prod denied",
+		"account.update accounts/dev succeeded",
+		"account.status accounts/dev succeeded",
+		"account.status accounts/dev succeeded",
+		"account.create accounts/dev succeeded",
+		"member.invite members/viewer-user succeeded",
+		"org.create  succeeded",
+	}
+	if len(actions) != len(expected) {
+		t.Fatalf("expected entries %v, got %v", expected, actions)
+	}
+	for i := range expected {
+		if actions[i] != expected[i] {
+			t.Fatalf("expected entries %v, got %v", expected, actions)
+		}
+	}
+
+	denied, update, created, request := log.Entries[0], log.Entries[1], log.Entries[2], log.Entries[4]
+	if denied.Actor != viewer || denied.Status != "403" || denied.RequestID == "" {
+		t.Fatalf("expected the denied request of the viewer, got %+v", denied)
+	}
+	if created.Actor != stream.Actor || created.Status != types.Created.String() || *created.BeforeVersion != 1 || *created.AfterVersion != 2 {
+		t.Fatalf("expected the stream processor to record the creation, got %+v", created)
+	}
+	if request.Actor != userID || request.BeforeVersion != nil || *request.AfterVersion != 0 {
+		t.Fatalf("expected the creation request, got %+v", request)
+	}
+	if update.BeforeVersion == nil || update.AfterVersion == nil || *update.AfterVersion != *update.BeforeVersion+1 {
+		t.Fatalf("expected the update to bump the version, got %+v", update)
+	}
+
+	// paging returns every entry exactly once
+	var paged []types.AuditEntry
+	token := ""
+	for {
+		query := url.Values{"limit": {"3"}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		var page auditPage
+		h.mustRequest(http.MethodGet, "/organizations/acme/audit?"+query.Encode(), userID, nil, http.StatusOK, &page)
+		if len(page.Entries) > 3 {
+			t.Fatalf("expected at most 3 entries, got %d", len(page.Entries))
+		}
+		paged = append(paged, page.Entries...)
+		if page.NextPageToken == "" {
+			break
+		}
+		token = page.NextPageToken
+	}
+	if len(paged) != len(log.Entries) {
+		t.Fatalf("expected %d entries across pages, got %d", len(log.Entries), len(paged))
+	}
+	for i := range paged {
+		if paged[i].ID != log.Entries[i].ID {
+			t.Fatalf("expected the pages to follow the order of the log, got %s at %d", paged[i].ID, i)
+		}
+	}
+
+	var filtered auditPage
+	h.mustRequest(http.MethodGet, "/organizations/acme/audit?"+url.Values{"to": {start.Format(time.RFC3339)}}.Encode(), userID, nil, http.StatusOK, &filtered)
+	if len(filtered.Entries) != 0 {
+		t.Fatalf("expected no entries before the test started, got %+v", filtered.Entries)
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/audit?"+url.Values{
+		"from": {denied.Timestamp.Format(time.RFC3339Nano)},
+		"to":   {time.Now().Add(time.Minute).Format(time.RFC3339)},
+	}.Encode(), userID, nil, http.StatusOK, &filtered)
+	if len(filtered.Entries) != 1 || filtered.Entries[0].ID != denied.ID {
+		t.Fatalf("expected the entries since the denied request, got %+v", filtered.Entries)
+	}
+
+	for _, query := range []string{"limit=0", "limit=101", "from=yesterday", "pageToken=%25%25", "from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z"} {
+		h.mustRequest(http.MethodGet, "/organizations/acme/audit?"+query, userID, nil, http.StatusBadRequest, nil)
+	}
+
+	// the log outlives the org
+	h.mustRequest(http.MethodDelete, "/organizations/acme", userID, nil, http.StatusNoContent, nil)
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodGet, "/organizations/acme/audit?limit=2", userID, nil, http.StatusOK, &log)
+	if len(log.Entries) != 2 || log.Entries[0].Action != "org.create" || log.Entries[1].Action != "org.delete" {
+		t.Fatalf("expected the org to be deleted and created again, got %+v", log.Entries)
+	}
+}
@@ -0,0 +1,76 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestAccountBaselines(t *testing.T) {
+	h := newHarness(t)
+
+	var catalogue struct {
+		Baselines []struct {
+			Name string `json:"name"`
+		} `json:"baselines"`
+	}
+	h.mustRequest(http.MethodGet, "/baselines", userID, nil, http.StatusOK, &catalogue)
+	if len(catalogue.Baselines) != 5 {
+		t.Fatalf("expected 5 registered baselines, got %+v", catalogue.Baselines)
+	}
+
+	for _, baselines := range [][]types.BaselineConfig{
+		{{Name: "unknown"}},
+		{{Name: "budget", Params: map[string]string{"limit": "100"}}},
+		{{Name: "iam-password-policy", Params: map[string]string{"minimumLength": "short"}}},
+	} {
+		status, body := h.request(http.MethodPost, "/organizations", userID, types.Organization{
+			OrgName:           "acme",
+			PulumiAccessToken: "not-used",
+			Baselines:         baselines,
+		})
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("expected baselines %+v to be rejected, got %d: %s", baselines, status, string(body))
+		}
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+		Baselines: []types.BaselineConfig{
+			{Name: "s3-public-access-block"},
+			{Name: "budget", Params: map[string]string{"limit": "100", "email": "finance@example.com"}},
+		},
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+	}, http.StatusCreated, nil)
+
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	providers := h.mocks.registered("pulumi:providers:aws")
+	if len(providers) != 1 {
+		t.Fatalf("expected a provider for the account, got %d", len(providers))
+	}
+	if _, ok := providers[0].Inputs["assumeRole"]; !ok {
+		t.Fatalf("expected the account provider to assume a role, got %+v", providers[0].Inputs)
+	}
+
+	if len(h.mocks.registered("aws:s3/accountPublicAccessBlock:AccountPublicAccessBlock")) != 1 {
+		t.Fatalf("expected the public access block baseline to be applied")
+	}
+	budgets := h.mocks.registered("aws:budgets/budget:Budget")
+	if len(budgets) != 1 || budgets[0].Inputs["limitAmount"].StringValue() != "100" {
+		t.Fatalf("expected the budget baseline to be applied with its parameters, got %+v", budgets)
+	}
+	if len(h.mocks.registered("aws:cloudtrail/trail:Trail")) != 0 {
+		t.Fatalf("expected unselected baselines not to be applied")
+	}
+}
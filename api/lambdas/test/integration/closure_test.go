@@ -0,0 +1,243 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/closure"
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestAccountClosure(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:                "acme",
+		PulumiAccessToken:      "not-used",
+		SuspendedUnit:          "suspended",
+		ClosureGracePeriodDays: 3,
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "suspended"}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "workloads"}, http.StatusCreated, nil)
+	for _, name := range []string{"dev", "prod"} {
+		h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+			AccountName: name,
+			Email:       fmt.Sprintf("aws+%s@example.com", name),
+			Unit:        "workloads",
+		}, http.StatusCreated, nil)
+	}
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	var account types.Account
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts/dev/close", userID, nil, http.StatusAccepted, &account)
+	if account.Status != types.Suspended || account.Unit != "suspended" || account.Closure == nil {
+		t.Fatalf("expected dev to be suspended in the suspended unit, got %+v", account)
+	}
+	if gracePeriod := account.Closure.CloseAfter.Sub(account.Closure.RequestedAt); gracePeriod != 3*24*time.Hour {
+		t.Fatalf("expected a grace period of 3 days, got %s", gracePeriod)
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts/dev/close", userID, nil, http.StatusConflict, nil)
+	h.mustRequest(http.MethodPut, "/organizations/acme/accounts/dev/parent", userID, map[string]string{"unit": "workloads"}, http.StatusConflict, nil)
+
+	registered := len(h.mocks.registered("aws:organizations/account:Account"))
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	accounts := h.mocks.registered("aws:organizations/account:Account")
+	if len(accounts) != registered+1 || accounts[len(accounts)-1].Inputs["parentId"].StringValue() != "suspended_id" {
+		t.Fatalf("expected dev to be moved to the suspended unit, got %+v", accounts[registered:])
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &account)
+	if account.Status != types.Suspended {
+		t.Fatalf("expected dev to stay suspended after the move was applied, got %s", account.Status)
+	}
+
+	h.mustRequest(http.MethodDelete, "/organizations/acme/accounts/dev/close", userID, nil, http.StatusOK, &account)
+	if account.Status != types.Created || account.Unit != "workloads" || account.Closure != nil {
+		t.Fatalf("expected dev to be restored to its unit, got %+v", account)
+	}
+	h.mustRequest(http.MethodDelete, "/organizations/acme/accounts/dev/close", userID, nil, http.StatusConflict, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &account)
+	if account.Status != types.Created || account.Unit != "workloads" {
+		t.Fatalf("expected dev to be created in its unit again, got %+v", account)
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts/dev/close", userID, nil, http.StatusAccepted, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts/prod/close", userID, nil, http.StatusAccepted, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	var closed []string
+	closeAccount := func(ctx context.Context, account *types.Account, org *types.Organization) error {
+		if account.AccountName == "prod" {
+			return fmt.Errorf("destroy failed")
+		}
+		closed = append(closed, account.AccountName)
+		return nil
+	}
+
+	sweeper := closure.NewSweeper(db.NewTables(h.ddb, h.tableName), closeAccount, nil)
+	if err := sweeper.Run(ctx); err != nil {
+		t.Fatalf("closure sweep failed: %s", err.Error())
+	}
+	if len(closed) != 0 {
+		t.Fatalf("expected no account to be closed during the grace period, got %v", closed)
+	}
+
+	later := func() time.Time { return time.Now().Add(4 * 24 * time.Hour) }
+	sweeper = closure.NewSweeper(db.NewTables(h.ddb, h.tableName), closeAccount, later)
+	if err := sweeper.Run(ctx); err != nil {
+		t.Fatalf("closure sweep failed: %s", err.Error())
+	}
+	if len(closed) != 1 || closed[0] != "dev" {
+		t.Fatalf("expected dev to be closed, got %v", closed)
+	}
+	// missing accounts are reported with 302 by GetAccount
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusNotFound, nil)
+
+	// prod failed to close and is retried by the next sweep
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/prod", userID, nil, http.StatusOK, &account)
+	if account.Status != types.Suspended || account.Closure == nil {
+		t.Fatalf("expected prod to stay suspended, got %+v", account)
+	}
+}
+
+func TestClosureWithFailedMove(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+		SuspendedUnit:     "suspended",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "suspended"}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "workloads"}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+		Unit:        "workloads",
+	}, http.StatusCreated, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	// an account whose move to the suspended unit failed stays suspended
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts/dev/close", userID, nil, http.StatusAccepted, nil)
+	h.provisionErr = fmt.Errorf("boom")
+	if err := h.processStream(ctx); err == nil {
+		t.Fatalf("expected stream handler to return the provisioning error")
+	}
+	h.provisionErr = nil
+	var account types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &account)
+	if account.Status != types.Suspended || account.Closure == nil {
+		t.Fatalf("expected dev to stay suspended after the move failed, got %+v", account)
+	}
+
+	// so its closure can still be cancelled
+	h.mustRequest(http.MethodDelete, "/organizations/acme/accounts/dev/close", userID, nil, http.StatusOK, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &account)
+	if account.Status != types.Created || account.Unit != "workloads" {
+		t.Fatalf("expected dev to be restored to its unit, got %+v", account)
+	}
+
+	// or completed by the sweeper
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts/dev/close", userID, nil, http.StatusAccepted, nil)
+	h.provisionErr = fmt.Errorf("boom")
+	if err := h.processStream(ctx); err == nil {
+		t.Fatalf("expected stream handler to return the provisioning error")
+	}
+	var closed []string
+	closeAccount := func(ctx context.Context, account *types.Account, org *types.Organization) error {
+		closed = append(closed, account.AccountName)
+		return nil
+	}
+	later := func() time.Time { return time.Now().Add(8 * 24 * time.Hour) }
+	if err := closure.NewSweeper(db.NewTables(h.ddb, h.tableName), closeAccount, later).Run(ctx); err != nil {
+		t.Fatalf("closure sweep failed: %s", err.Error())
+	}
+	if len(closed) != 1 || closed[0] != "dev" {
+		t.Fatalf("expected dev to be closed, got %v", closed)
+	}
+}
+
+func TestSpecOfClosedAccounts(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+		SuspendedUnit:     "suspended",
+	}, http.StatusCreated, nil)
+	for _, unit := range []string{"suspended", "workloads", "sandboxes"} {
+		h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: unit}, http.StatusCreated, nil)
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+		Unit:        "workloads",
+	}, http.StatusCreated, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts/dev/close", userID, nil, http.StatusAccepted, nil)
+
+	// suspended accounts are exported where cancelling the closure restores them to
+	var exported types.OrgSpec
+	h.mustRequest(http.MethodGet, "/organizations/acme/spec", userID, nil, http.StatusOK, &exported)
+	if len(exported.Accounts) != 1 || exported.Accounts[0].Unit != "workloads" {
+		t.Fatalf("expected dev to be exported in its unit, got %+v", exported.Accounts)
+	}
+	var plan types.SpecPlan
+	h.mustRequest(http.MethodPut, "/organizations/acme/spec?apply=true", userID, exported, http.StatusOK, &plan)
+	if len(plan.Changes) != 0 {
+		t.Fatalf("expected the exported spec to have no changes, got %+v", plan.Changes)
+	}
+	var account types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &account)
+	if account.Status != types.Suspended || account.Unit != "suspended" {
+		t.Fatalf("expected dev to stay suspended in the suspended unit, got %+v", account)
+	}
+
+	moved := exported
+	moved.Accounts = []types.AccountSpec{exported.Accounts[0]}
+	moved.Accounts[0].Unit = "sandboxes"
+	h.mustRequest(http.MethodPut, "/organizations/acme/spec", userID, moved, http.StatusConflict, nil)
+	// the unit dev is restored to can't be deleted
+	h.mustRequest(http.MethodDelete, "/organizations/acme/units/workloads", userID, nil, http.StatusConflict, nil)
+
+	// accounts whose stack is being destroyed can't be changed at all
+	closeAccount := func(ctx context.Context, account *types.Account, org *types.Organization) error {
+		changed := exported
+		changed.Accounts = []types.AccountSpec{exported.Accounts[0]}
+		changed.Accounts[0].Tags = map[string]string{"team": "platform"}
+		if status, body := h.request(http.MethodPut, "/organizations/acme/spec?apply=true", userID, changed); status != http.StatusConflict {
+			t.Errorf("expected changes of a closing account to be rejected, got %d: %s", status, string(body))
+		}
+		h.mustRequest(http.MethodDelete, "/organizations/acme/accounts/dev", userID, nil, http.StatusConflict, nil)
+		return nil
+	}
+	later := func() time.Time { return time.Now().Add(30 * 24 * time.Hour) }
+	if err := closure.NewSweeper(db.NewTables(h.ddb, h.tableName), closeAccount, later).Run(ctx); err != nil {
+		t.Fatalf("closure sweep failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,58 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/drift"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestDriftDetection(t *testing.T) {
+	h := newHarness(t)
+	createOrgAndAccount(h, "acme", "dev")
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "prod",
+		Email:       "aws+prod@example.com",
+	}, http.StatusCreated, nil)
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	// only prod drifted
+	check := func(ctx context.Context, account *types.Account, org *types.Organization) (*types.PreviewDiff, error) {
+		diff := &types.PreviewDiff{}
+		if account.AccountName == "prod" {
+			diff.Resources = []types.ResourceChange{{
+				URN:  "urn:pulumi:prod::acme::aws:organizations/account:Account::prod",
+				Type: "aws:organizations/account:Account",
+				Op:   "update",
+			}}
+		}
+		return diff, nil
+	}
+	detector := drift.NewDetector(db.NewTables(h.ddb, h.tableName), check, 2)
+	if err := detector.Run(context.Background()); err != nil {
+		t.Fatalf("drift detection failed: %s", err.Error())
+	}
+
+	var list struct {
+		Accounts []types.Account `json:"accounts"`
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts?driftStatus=drifted", userID, nil, http.StatusOK, &list)
+	if len(list.Accounts) != 1 || list.Accounts[0].AccountName != "prod" {
+		t.Fatalf("expected only prod to be drifted, got %+v", list.Accounts)
+	}
+	if list.Accounts[0].DriftSummary == "" || list.Accounts[0].DriftCheckedAt == nil {
+		t.Fatalf("expected the drift check to be recorded, got %+v", list.Accounts[0])
+	}
+
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts?driftStatus=in-sync", userID, nil, http.StatusOK, &list)
+	if len(list.Accounts) != 1 || list.Accounts[0].AccountName != "dev" {
+		t.Fatalf("expected only dev to be in sync, got %+v", list.Accounts)
+	}
+}
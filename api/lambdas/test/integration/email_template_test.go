@@ -0,0 +1,69 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestAccountEmailTemplate(t *testing.T) {
+	h := newHarness(t)
+
+	for _, template := range []string{
+		"aws@example.com",
+		"aws+{team}-{account}@example.com",
+		"aws+{org}-{account}",
+		"aws+{account}-" + strings.Repeat("x", 60) + "@example.com",
+	} {
+		status, body := h.request(http.MethodPost, "/organizations", userID, types.Organization{
+			OrgName:           "acme",
+			PulumiAccessToken: "not-used",
+			EmailTemplate:     template,
+		})
+		if status != http.StatusUnprocessableEntity || !strings.Contains(string(body), "emailTemplate") {
+			t.Fatalf("expected template %q to be rejected, got %d: %s", template, status, string(body))
+		}
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+		EmailTemplate:     "aws+{org}-{account}@example.com",
+	}, http.StatusCreated, nil)
+	var org types.Organization
+	h.mustRequest(http.MethodGet, "/organizations/acme", userID, nil, http.StatusOK, &org)
+	if org.EmailTemplate != "aws+{org}-{account}@example.com" {
+		t.Fatalf("expected the email template to be stored, got %q", org.EmailTemplate)
+	}
+
+	var account types.Account
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{AccountName: "dev"}, http.StatusCreated, &account)
+	if account.Email != "aws+acme-dev@example.com" {
+		t.Fatalf("expected the email to be rendered from the template, got %q", account.Email)
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "prod",
+		Email:       "aws+acme-staging@example.com",
+	}, http.StatusCreated, &account)
+	if account.Email != "aws+acme-staging@example.com" {
+		t.Fatalf("expected the email of the request to take precedence, got %q", account.Email)
+	}
+
+	// rendered emails are checked like the ones clients send
+	for _, name := range []string{"staging", strings.Repeat("d", 45)} {
+		status, body := h.request(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{AccountName: name})
+		if status != http.StatusUnprocessableEntity || !strings.Contains(string(body), "rendered from the email template") {
+			t.Fatalf("expected the rendered email of %s to be rejected, got %d: %s", name, status, string(body))
+		}
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "other",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/other/accounts", userID, types.Account{AccountName: "dev"}, http.StatusUnprocessableEntity, nil)
+}
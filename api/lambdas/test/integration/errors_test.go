@@ -0,0 +1,58 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/apierror"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestProblemDetails(t *testing.T) {
+	h := newHarness(t)
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+
+	expectProblem := func(method string, path string, body interface{}, expectedStatus int, expectedCode apierror.Code) {
+		res := h.proxy(method, path, map[string]interface{}{"principalId": userID}, body, nil)
+		if res.StatusCode != expectedStatus {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, expectedStatus, res.StatusCode, res.Body)
+		}
+		if contentType := res.MultiValueHeaders["Content-Type"]; len(contentType) != 1 || !strings.HasPrefix(contentType[0], apierror.ContentType) {
+			t.Fatalf("%s %s: expected problem details, got content type %v", method, path, contentType)
+		}
+		var problem struct {
+			Type     string        `json:"type"`
+			Title    string        `json:"title"`
+			Status   int           `json:"status"`
+			Detail   string        `json:"detail"`
+			Instance string        `json:"instance"`
+			Code     apierror.Code `json:"code"`
+		}
+		if err := json.Unmarshal([]byte(res.Body), &problem); err != nil {
+			t.Fatalf("%s %s: failed to decode problem %q: %s", method, path, res.Body, err.Error())
+		}
+		if problem.Code != expectedCode || problem.Status != expectedStatus || problem.Type != "urn:festus:problem:"+string(expectedCode) ||
+			problem.Title != http.StatusText(expectedStatus) || problem.Detail == "" || problem.Instance != path {
+			t.Fatalf("%s %s: expected a %s problem, got %+v", method, path, expectedCode, problem)
+		}
+	}
+
+	expectProblem(http.MethodGet, "/organizations/unknown", nil, http.StatusNotFound, apierror.NotFoundCode)
+	expectProblem(http.MethodGet, "/organizations/acme/accounts/unknown", nil, http.StatusNotFound, apierror.NotFoundCode)
+	expectProblem(http.MethodGet, "/unknown", nil, http.StatusNotFound, apierror.NotFoundCode)
+	expectProblem(http.MethodPost, "/organizations/acme/accounts", []byte("{"), http.StatusBadRequest, apierror.BadRequestCode)
+	expectProblem(http.MethodPost, "/organizations/acme/accounts", types.Account{AccountName: "dev#1", Email: "aws+dev@example.com"}, http.StatusUnprocessableEntity, apierror.ValidationCode)
+	expectProblem(http.MethodPost, "/organizations", types.Organization{OrgName: "acme", PulumiAccessToken: "not-used"}, http.StatusConflict, apierror.AlreadyExistsCode)
+
+	if status, body := h.request(http.MethodDelete, "/organizations/acme", userID, nil); status != http.StatusNoContent || len(body) != 0 {
+		t.Fatalf("expected an empty 204 response, got %d: %q", status, string(body))
+	}
+}
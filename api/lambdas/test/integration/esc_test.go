@@ -0,0 +1,89 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestAccountESCBootstrap(t *testing.T) {
+	h := newHarness(t)
+
+	status, body := h.request(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:   "acme",
+		PulumiOrg: "acme-corp",
+	})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a pulumi org without access token to be rejected, got %d: %s", status, string(body))
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+		PulumiOrg:         "acme-corp",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+	}, http.StatusCreated, nil)
+
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	providers := h.mocks.registered("aws:iam/openIdConnectProvider:OpenIdConnectProvider")
+	if len(providers) != 1 {
+		t.Fatalf("expected an OIDC provider in the account, got %d", len(providers))
+	}
+	if providers[0].Inputs["url"].StringValue() != "https://api.pulumi.com/oidc" {
+		t.Fatalf("expected the OIDC provider to trust Pulumi Cloud, got %+v", providers[0].Inputs)
+	}
+	if audiences := providers[0].Inputs["clientIdLists"].ArrayValue(); len(audiences) != 1 || audiences[0].StringValue() != "acme-corp" {
+		t.Fatalf("expected the pulumi org to be the audience, got %+v", audiences)
+	}
+
+	roles := h.mocks.registered("aws:iam/role:Role")
+	if len(roles) != 2 {
+		t.Fatalf("expected an admin and a developer role, got %d", len(roles))
+	}
+	for _, role := range roles {
+		policy := role.Inputs["assumeRolePolicy"].StringValue()
+		// roles are named <account>-<role> and environments <org>-<account>-<role>
+		if !strings.Contains(policy, "pulumi:environments:org:acme-corp:env:acme-"+role.Name) {
+			t.Fatalf("expected role %s to trust its environment only, got %s", role.Name, policy)
+		}
+	}
+	if len(h.mocks.registered("aws:iam/rolePolicyAttachment:RolePolicyAttachment")) != 2 {
+		t.Fatalf("expected a policy attachment per role")
+	}
+
+	for _, env := range []string{"acme-dev-admin", "acme-dev-developer"} {
+		definition, ok := h.envs.get("acme-corp", env)
+		if !ok {
+			t.Fatalf("expected ESC environment %s to be created", env)
+		}
+		if !strings.Contains(definition, "fn::open::aws-login") || !strings.Contains(definition, "arn:aws:iam::dev_id:role/") {
+			t.Fatalf("expected environment %s to log into the account, got %s", env, definition)
+		}
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "other",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/other/accounts", userID, types.Account{
+		AccountName: "dev",
+		Email:       "aws+other-dev@example.com",
+	}, http.StatusCreated, nil)
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	if len(h.mocks.registered("aws:iam/openIdConnectProvider:OpenIdConnectProvider")) != 1 {
+		t.Fatalf("expected no OIDC provider for orgs without a pulumi org")
+	}
+}
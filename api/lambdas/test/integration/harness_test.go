@@ -0,0 +1,464 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
+	"github.com/gin-gonic/gin"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/feed"
+	"github.com/flostadler/festus/api/pkg/handlers"
+	"github.com/flostadler/festus/api/pkg/iac"
+	"github.com/flostadler/festus/api/pkg/stream"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// sess points at DynamoDB Local and is shared by all tests
+var sess *session.Session
+
+// TestMain starts DynamoDB Local (or uses the one at DYNAMODB_LOCAL_ENDPOINT). The stream handler runs the account
+// and org programs against Pulumi mocks, so the suite never talks to AWS or Pulumi Cloud. Tests that use the automation
+// API themselves need a pulumi CLI and get a file:// backend.
+// Without DynamoDB Local the suite is skipped locally, but fails in CI so that it can't pass without running.
+func TestMain(m *testing.M) {
+	endpoint, stop, err := startDynamoDBLocal()
+	if err != nil {
+		if os.Getenv("CI") != "" {
+			fmt.Printf("integration tests can't run: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("skipping integration tests: %s\n", err.Error())
+		os.Exit(0)
+	}
+
+	pulumiDir, err := os.MkdirTemp("", "festus-pulumi")
+	if err != nil {
+		stop()
+		panic(err)
+	}
+	os.Setenv("PULUMI_BACKEND_URL", "file://"+pulumiDir)
+	os.Setenv("PULUMI_CONFIG_PASSPHRASE", "festus-integration")
+	os.Setenv("PULUMI_HOME", pulumiDir)
+
+	sess = session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(endpoint),
+		Region:      aws.String("us-west-2"),
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	}))
+	gin.SetMode(gin.TestMode)
+
+	code := m.Run()
+
+	stop()
+	os.RemoveAll(pulumiDir)
+	os.Exit(code)
+}
+
+func startDynamoDBLocal() (string, func(), error) {
+	if endpoint := os.Getenv("DYNAMODB_LOCAL_ENDPOINT"); endpoint != "" {
+		return endpoint, func() {}, nil
+	}
+
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return "", nil, fmt.Errorf("neither DYNAMODB_LOCAL_ENDPOINT nor docker is available")
+	}
+
+	out, err := exec.Command(docker, "run", "-d", "--rm", "-p", "127.0.0.1::8000", "amazon/dynamodb-local", "-jar", "DynamoDBLocal.jar", "-inMemory").Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start DynamoDB Local: %w", err)
+	}
+	containerID := strings.TrimSpace(string(out))
+	stop := func() {
+		exec.Command(docker, "stop", containerID).Run()
+	}
+
+	out, err = exec.Command(docker, "port", containerID, "8000").Output()
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("failed to look up DynamoDB Local port: %w", err)
+	}
+	endpoint := "http://" + strings.TrimSpace(strings.Split(string(out), "\n")[0])
+
+	client := dynamodb.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(endpoint),
+		Region:      aws.String("us-west-2"),
+		Credentials: credentials.NewStaticCredentials("local", "local", ""),
+	})))
+	for i := 0; i < 50; i++ {
+		if _, err = client.ListTables(&dynamodb.ListTablesInput{}); err == nil {
+			return endpoint, stop, nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	stop()
+	return "", nil, fmt.Errorf("DynamoDB Local did not become ready: %w", err)
+}
+
+// harness wires the API router and the stream handler against a fresh table
+type harness struct {
+	t         *testing.T
+	tableName string
+	ddb       *dynamodb.DynamoDB
+	ginLambda *ginadapter.GinLambda
+	handler   *stream.Handler
+	mocks     *resourceMocks
+	envs      *fakeEnvironments
+
+	orgMu        sync.Mutex
+	orgResources []pulumi.MockResourceArgs
+
+	// provisionErr makes the mocked provisioner fail when set
+	provisionErr error
+
+	// stacks holds the state of the mocked account stacks by "<org>/<account>"
+	stacksMu sync.Mutex
+	stacks   map[string]mockStackState
+
+	streamArn    string
+	streamReader *feed.StreamReader
+	bus          *feed.Bus
+}
+
+func newHarness(t *testing.T) *harness {
+	h := &harness{
+		t:         t,
+		tableName: strings.ReplaceAll(fmt.Sprintf("festus-%s-%d", t.Name(), time.Now().UnixNano()), "/", "-"),
+		ddb:       dynamodb.New(sess),
+		mocks:     &resourceMocks{},
+		envs:      &fakeEnvironments{definitions: map[string]string{}},
+		stacks:    map[string]mockStackState{},
+		bus:       feed.NewBus(),
+	}
+
+	table, err := h.ddb.CreateTable(&dynamodb.CreateTableInput{
+		TableName:   aws.String(h.tableName),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String("pk"), KeyType: aws.String(dynamodb.KeyTypeHash)},
+			{AttributeName: aws.String("sk"), KeyType: aws.String(dynamodb.KeyTypeRange)},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{AttributeName: aws.String("pk"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+			{AttributeName: aws.String("sk"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+		},
+		StreamSpecification: &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(dynamodb.StreamViewTypeNewImage),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err.Error())
+	}
+	h.streamArn = *table.TableDescription.LatestStreamArn
+	h.streamReader = feed.NewStreamReader(dynamodbstreams.New(sess), h.streamArn, dynamodbstreams.ShardIteratorTypeTrimHorizon)
+	t.Cleanup(func() {
+		h.ddb.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(h.tableName)})
+	})
+
+	tables := db.NewTables(h.ddb, h.tableName)
+	h.ginLambda = ginadapter.New(handlers.NewRouter(handlers.RouterConfig{
+		Tables:       tables,
+		Feed:         h.bus,
+		WatchTimeout: 500 * time.Millisecond,
+	}))
+	h.handler = stream.NewHandler(tables, h.provision, h.applyOrg, h.preview)
+
+	return h
+}
+
+// provision provisions the account like the stream processor does in production, but runs the account program
+// against Pulumi mocks instead of the automation API and bootstraps the ESC environments with the fake
+func (h *harness) provision(ctx context.Context, account *types.Account, org *types.Organization, onEvent iac.EventSink) (*iac.Provisioned, error) {
+	if h.provisionErr != nil {
+		return nil, h.provisionErr
+	}
+	return iac.ProvisionAccount(ctx, &mockStack{h: h, account: account, org: org}, h.envs, account, org, testOIDCTrust(org), onEvent)
+}
+
+// mockStack is the stack of an account that runs the account program against the harness' Pulumi mocks. Its state
+// only remembers the AWS account and the number of successful runs. Created accounts get the mocked ID of their
+// account resource
+type mockStack struct {
+	h       *harness
+	account *types.Account
+	org     *types.Organization
+}
+
+type mockStackState struct {
+	accountID string
+	updates   int
+}
+
+func (s *mockStack) HasAccount(ctx context.Context, accountID string) (bool, error) {
+	s.h.stacksMu.Lock()
+	defer s.h.stacksMu.Unlock()
+	return s.h.stacks[s.org.OrgName+"/"+s.account.AccountName].accountID == accountID, nil
+}
+
+// Up reports every mocked resource to onEvent like the engine would
+func (s *mockStack) Up(ctx context.Context, program pulumi.RunFunc, onEvent iac.EventSink) (*iac.Provisioned, error) {
+	s.h.mocks.mu.Lock()
+	registered := len(s.h.mocks.resources)
+	s.h.mocks.mu.Unlock()
+	if err := pulumi.RunErr(program, pulumi.WithMocks(s.org.OrgName, s.account.AccountName, s.h.mocks)); err != nil {
+		return nil, err
+	}
+
+	s.h.mocks.mu.Lock()
+	resources := slices.Clone(s.h.mocks.resources[registered:])
+	s.h.mocks.mu.Unlock()
+	for i, r := range resources {
+		onEvent(types.DeploymentEvent{
+			Sequence:     i + 1,
+			Timestamp:    int(time.Now().Unix()),
+			Status:       types.DeploymentEventSucceeded,
+			URN:          fmt.Sprintf("urn:pulumi:%s::%s::%s::%s", s.account.AccountName, s.org.OrgName, r.TypeToken, r.Name),
+			ResourceType: r.TypeToken,
+			Op:           "create",
+		})
+	}
+
+	accountID := s.account.AccountName + "_id"
+	if s.account.Imported {
+		accountID = s.account.AccountID
+	}
+	s.h.stacksMu.Lock()
+	defer s.h.stacksMu.Unlock()
+	state := s.h.stacks[s.org.OrgName+"/"+s.account.AccountName]
+	state.accountID = accountID
+	state.updates++
+	s.h.stacks[s.org.OrgName+"/"+s.account.AccountName] = state
+	return &iac.Provisioned{AccountID: accountID, UpdateVersion: state.updates}, nil
+}
+
+// applyOrg runs the org stack program against Pulumi mocks and returns the mocked IDs of the units and policies.
+// The org stack resources of the last run are kept
+// in h.orgResources, because the org stack replaces them on every run
+func (h *harness) applyOrg(ctx context.Context, org *types.Organization, units []*types.OrganizationalUnit, policies []*types.ServiceControlPolicy, accountIDs map[string]string) (*types.OrgResources, error) {
+	mocks := &resourceMocks{}
+	err := pulumi.RunErr(iac.OrgProgram(units, policies, accountIDs), pulumi.WithMocks(org.OrgName, iac.OrgStackName, mocks))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := &types.OrgResources{Units: map[string]string{}, Policies: map[string]string{}}
+	for _, r := range mocks.registered("aws:organizations/organizationalUnit:OrganizationalUnit") {
+		ids.Units[r.Name] = r.Name + "_id"
+		h.mocks.mu.Lock()
+		h.mocks.resources = append(h.mocks.resources, r)
+		h.mocks.mu.Unlock()
+	}
+	for _, r := range mocks.registered("aws:organizations/policy:Policy") {
+		ids.Policies[r.Name] = r.Name + "_id"
+	}
+	h.orgMu.Lock()
+	h.orgResources = mocks.resources
+	h.orgMu.Unlock()
+	return ids, nil
+}
+
+// lastOrgRun returns the resources of the given type the org stack registered in its last run
+func (h *harness) lastOrgRun(typeToken string) []pulumi.MockResourceArgs {
+	h.orgMu.Lock()
+	defer h.orgMu.Unlock()
+	var result []pulumi.MockResourceArgs
+	for _, r := range h.orgResources {
+		if r.TypeToken == typeToken {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// preview runs the account program against fresh Pulumi mocks and plans every registered resource as a create
+func (h *harness) preview(ctx context.Context, account *types.Account, org *types.Organization) (*types.PreviewDiff, error) {
+	mocks := &resourceMocks{}
+	err := pulumi.RunErr(iac.AccountProgram(account, org, testOIDCTrust(org), ""), pulumi.WithMocks(org.OrgName, account.AccountName, mocks))
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []apitype.StepEventMetadata
+	for _, r := range mocks.resources {
+		steps = append(steps, apitype.StepEventMetadata{
+			Op:   apitype.OpCreate,
+			URN:  fmt.Sprintf("urn:pulumi:%s::%s::%s::%s", account.AccountName, org.OrgName, r.TypeToken, r.Name),
+			Type: r.TypeToken,
+		})
+	}
+	return iac.NewPreviewDiff(steps), nil
+}
+
+// testOIDCTrust is the OIDC trust of an org's accounts with a fixed thumbprint instead of the issuer's
+func testOIDCTrust(org *types.Organization) *iac.OIDCTrust {
+	if org.PulumiOrg == "" {
+		return nil
+	}
+	return &iac.OIDCTrust{PulumiOrg: org.PulumiOrg, Thumbprint: "0000000000000000000000000000000000000000"}
+}
+
+// fakeEnvironments records the ESC environments instead of calling Pulumi Cloud
+type fakeEnvironments struct {
+	mu sync.Mutex
+	// definitions by "<pulumi org>/<environment>"
+	definitions map[string]string
+}
+
+func (f *fakeEnvironments) UpsertEnvironment(ctx context.Context, org string, name string, definition []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.definitions[org+"/"+name] = string(definition)
+	return nil
+}
+
+func (f *fakeEnvironments) get(org string, name string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	definition, ok := f.definitions[org+"/"+name]
+	return definition, ok
+}
+
+// request sends an API Gateway proxy request through the gin router on behalf of userID
+func (h *harness) request(method string, path string, userID string, body interface{}) (int, []byte) {
+	return h.requestWithHeaders(method, path, userID, body, nil)
+}
+
+func (h *harness) requestWithHeaders(method string, path string, userID string, body interface{}, headers map[string]string) (int, []byte) {
+	return h.requestWithAuthorizer(method, path, map[string]interface{}{"principalId": userID}, body, headers)
+}
+
+// requestWithAuthorizer sends a request with the context the API Gateway authorizer passes on, e.g. token scopes
+func (h *harness) requestWithAuthorizer(method string, path string, authorizer map[string]interface{}, body interface{}, headers map[string]string) (int, []byte) {
+	res := h.proxy(method, path, authorizer, body, headers)
+	return res.StatusCode, []byte(res.Body)
+}
+
+// proxy sends a request and returns the whole response, including its headers
+func (h *harness) proxy(method string, path string, authorizer map[string]interface{}, body interface{}, headers map[string]string) events.APIGatewayProxyResponse {
+	// raw bodies are sent as they are, everything else as JSON
+	payload, raw := body.([]byte)
+	if body != nil && !raw {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			h.t.Fatalf("failed to marshal request body: %s", err.Error())
+		}
+	}
+
+	requestHeaders := map[string]string{"Content-Type": "application/json"}
+	for k, v := range headers {
+		requestHeaders[k] = v
+	}
+
+	res, err := h.ginLambda.ProxyWithContext(context.Background(), events.APIGatewayProxyRequest{
+		Path:       path,
+		HTTPMethod: method,
+		Headers:    requestHeaders,
+		Body:       string(payload),
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID:  fmt.Sprintf("req-%d", time.Now().UnixNano()),
+			Authorizer: authorizer,
+		},
+	})
+	if err != nil {
+		h.t.Fatalf("%s %s failed: %s", method, path, err.Error())
+	}
+	return res
+}
+
+// mustRequest is like request but fails the test if the status code doesn't match and decodes the body into out
+func (h *harness) mustRequest(method string, path string, userID string, body interface{}, expectedStatus int, out interface{}) {
+	status, resBody := h.request(method, path, userID, body)
+	if status != expectedStatus {
+		h.t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, expectedStatus, status, string(resBody))
+	}
+	if out != nil {
+		if err := json.Unmarshal(resBody, out); err != nil {
+			h.t.Fatalf("%s %s: failed to decode response %q: %s", method, path, string(resBody), err.Error())
+		}
+	}
+}
+
+// processStream feeds all pending table changes to the stream handler until the table settles.
+// It returns the first error returned by the handler.
+func (h *harness) processStream(ctx context.Context) error {
+	for {
+		records, err := h.streamReader.Read()
+		if err != nil {
+			h.t.Fatalf("failed to read stream: %s", err.Error())
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		h.bus.Publish(records)
+		if err := h.handler.Handle(ctx, events.DynamoDBEvent{Records: records}); err != nil {
+			return err
+		}
+	}
+}
+
+// replayStream hands every record of the table stream to the stream handler again, like a redelivery would
+func (h *harness) replayStream(ctx context.Context) error {
+	records, err := feed.NewStreamReader(dynamodbstreams.New(sess), h.streamArn, dynamodbstreams.ShardIteratorTypeTrimHorizon).Read()
+	if err != nil {
+		h.t.Fatalf("failed to read stream: %s", err.Error())
+	}
+	return h.handler.Handle(ctx, events.DynamoDBEvent{Records: records})
+}
+
+// resourceMocks records every resource the account program registers
+type resourceMocks struct {
+	mu        sync.Mutex
+	resources []pulumi.MockResourceArgs
+}
+
+func (m *resourceMocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resources = append(m.resources, args)
+	return args.Name + "_id", args.Inputs, nil
+}
+
+func (m *resourceMocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	if args.Token == "aws:organizations/getOrganization:getOrganization" {
+		return resource.NewPropertyMapFromMap(map[string]interface{}{
+			"id":    "o-mocked",
+			"roots": []interface{}{map[string]interface{}{"id": "r-mocked"}},
+		}), nil
+	}
+	return args.Args, nil
+}
+
+func (m *resourceMocks) registered(typeToken string) []pulumi.MockResourceArgs {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []pulumi.MockResourceArgs
+	for _, r := range m.resources {
+		if r.TypeToken == typeToken {
+			result = append(result, r)
+		}
+	}
+	return result
+}
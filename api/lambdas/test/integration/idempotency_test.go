@@ -0,0 +1,113 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	h := newHarness(t)
+	const other = "other-user"
+
+	post := func(path string, user string, key string, body interface{}, expectedStatus int) []byte {
+		status, resBody := h.requestWithHeaders(http.MethodPost, path, user, body, map[string]string{"Idempotency-Key": key})
+		if status != expectedStatus {
+			t.Fatalf("POST %s with key %s: expected status %d, got %d: %s", path, key, expectedStatus, status, string(resBody))
+		}
+		return resBody
+	}
+	org := types.Organization{OrgName: "acme", PulumiAccessToken: "not-used"}
+
+	created := post("/organizations", userID, "create-acme", org, http.StatusCreated)
+	if replayed := post("/organizations", userID, "create-acme", org, http.StatusCreated); !bytes.Equal(created, replayed) {
+		t.Fatalf("expected the retry to get the same response, got %s and %s", string(created), string(replayed))
+	}
+	// without a key the retry is rejected instead of overwriting the org
+	h.mustRequest(http.MethodPost, "/organizations", userID, org, http.StatusConflict, nil)
+	post("/organizations", userID, "create-acme", types.Organization{OrgName: "other", PulumiAccessToken: "not-used"}, http.StatusUnprocessableEntity)
+	// keys are scoped to the user
+	post("/organizations", other, "create-acme", org, http.StatusCreated)
+
+	account := types.Account{AccountName: "dev", Email: "aws+dev@example.com"}
+	post("/organizations/acme/accounts", userID, "create-dev", types.Account{AccountName: "dev#1", Email: "aws+dev@example.com"}, http.StatusUnprocessableEntity)
+	// client errors are replayed as well
+	post("/organizations/acme/accounts", userID, "create-dev", types.Account{AccountName: "dev#1", Email: "aws+dev@example.com"}, http.StatusUnprocessableEntity)
+	post("/organizations/acme/accounts", userID, "create-dev", account, http.StatusUnprocessableEntity)
+
+	first := post("/organizations/acme/accounts", userID, "create-dev-again", account, http.StatusCreated)
+	if replayed := post("/organizations/acme/accounts", userID, "create-dev-again", account, http.StatusCreated); !bytes.Equal(first, replayed) {
+		t.Fatalf("expected the retry to get the same response, got %s and %s", string(first), string(replayed))
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, account, http.StatusConflict, nil)
+	post("/organizations/acme/units", userID, "create-dev-again", account, http.StatusUnprocessableEntity)
+
+	post("/organizations/acme/units", userID, strings.Repeat("k", 256), types.OrganizationalUnit{Name: "workloads"}, http.StatusBadRequest)
+
+	var log auditPage
+	h.mustRequest(http.MethodGet, "/organizations/acme/audit", userID, nil, http.StatusOK, &log)
+	creates := 0
+	for _, entry := range log.Entries {
+		if entry.Action == "account.create" && entry.Outcome == types.AuditSucceeded {
+			creates++
+		}
+	}
+	if creates != 1 {
+		t.Fatalf("expected the account to be created once, got %d audited creations", creates)
+	}
+}
+
+func TestIdempotencyKeysWithSecrets(t *testing.T) {
+	h := newHarness(t)
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{OrgName: "acme", PulumiAccessToken: "not-used"}, http.StatusCreated, nil)
+
+	key := types.APIKey{Name: "ci", Scopes: []string{"org:read"}}
+	headers := map[string]string{"Idempotency-Key": "create-ci"}
+	status, body := h.requestWithHeaders(http.MethodPost, "/organizations/acme/apikeys", userID, key, headers)
+	var created types.APIKey
+	if status != http.StatusCreated || json.Unmarshal(body, &created) != nil || created.Key == "" {
+		t.Fatalf("expected the API key to be created, got %d: %s", status, string(body))
+	}
+
+	// the secret is only returned once, so the retry is told that the key exists instead
+	status, body = h.requestWithHeaders(http.MethodPost, "/organizations/acme/apikeys", userID, key, headers)
+	if status != http.StatusConflict || strings.Contains(string(body), created.Key) {
+		t.Fatalf("expected the retry to conflict without the secret, got %d: %s", status, string(body))
+	}
+
+	item, err := db.NewIdempotencyDB(h.ddb, h.tableName).Claim(userID, "create-ci", "", time.Now().UTC(), time.Minute, time.Hour)
+	if err != nil || item == nil {
+		t.Fatalf("expected the key to be taken, got %+v: %v", item, err)
+	}
+	if !item.BodyOmitted || len(item.Body) != 0 {
+		t.Fatalf("expected the response with the secret not to be stored, got %s", string(item.Body))
+	}
+}
+
+func TestIdempotencyKeyLease(t *testing.T) {
+	h := newHarness(t)
+	keys := db.NewIdempotencyDB(h.ddb, h.tableName)
+	claimed := time.Now().UTC()
+
+	if item, err := keys.Claim(userID, "timed-out", "request", claimed, time.Minute, time.Hour); err != nil || item != nil {
+		t.Fatalf("expected the key to be claimed, got %+v: %v", item, err)
+	}
+	if item, err := keys.Claim(userID, "timed-out", "request", claimed.Add(30*time.Second), time.Minute, time.Hour); err != nil || item == nil || item.Completed() {
+		t.Fatalf("expected the key to be in progress during its lease, got %+v: %v", item, err)
+	}
+	// the request that claimed the key was cut off, so its lease runs out
+	if item, err := keys.Claim(userID, "timed-out", "other-request", claimed.Add(2*time.Minute), time.Minute, time.Hour); err != nil || item == nil {
+		t.Fatalf("expected other requests not to take over the key, got %+v: %v", item, err)
+	}
+	if item, err := keys.Claim(userID, "timed-out", "request", claimed.Add(2*time.Minute), time.Minute, time.Hour); err != nil || item != nil {
+		t.Fatalf("expected the retry to take over the key after its lease, got %+v: %v", item, err)
+	}
+}
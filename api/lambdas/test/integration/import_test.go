@@ -0,0 +1,118 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestImportAccount(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+		Baselines:         []types.BaselineConfig{{Name: "s3-public-access-block"}},
+	}, http.StatusCreated, nil)
+
+	for _, account := range []types.Account{
+		{AccountName: "legacy", Email: "aws+legacy@example.com"},
+		{AccountName: "legacy", Email: "aws+legacy@example.com", AccountID: "1234"},
+		{AccountName: "legacy", AccountID: "123456789012"},
+	} {
+		status, body := h.request(http.MethodPost, "/organizations/acme/accounts:import", userID, account)
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("expected import %+v to be rejected, got %d: %s", account, status, string(body))
+		}
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "legacy",
+		Email:       "aws+legacy@example.com",
+		AccountID:   "123456789012",
+	}, http.StatusUnprocessableEntity, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts:unknown", userID, nil, http.StatusNotFound, nil)
+	h.mustRequest(http.MethodPost, "/organizations/unknown/accounts:import", userID, types.Account{
+		AccountName: "legacy",
+		Email:       "aws+legacy@example.com",
+		AccountID:   "123456789012",
+	}, http.StatusNotFound, nil)
+
+	var account types.Account
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts:import", userID, types.Account{
+		AccountName: "legacy",
+		Email:       "aws+legacy@example.com",
+		AccountID:   "123456789012",
+	}, http.StatusCreated, &account)
+	if account.Status != types.Pending || account.AccountID != "123456789012" || !account.Imported {
+		t.Fatalf("expected a pending imported account, got %+v", account)
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts:import", userID, types.Account{
+		AccountName: "other",
+		Email:       "aws+other@example.com",
+		AccountID:   "123456789012",
+	}, http.StatusConflict, nil)
+
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	accounts := h.mocks.registered("aws:organizations/account:Account")
+	if len(accounts) != 1 || accounts[0].ID != "123456789012" {
+		t.Fatalf("expected the AWS account to be imported, got %+v", accounts)
+	}
+	if _, ok := accounts[0].Inputs["roleName"]; ok {
+		t.Fatalf("expected the imported account not to set the role name, got %+v", accounts[0].Inputs)
+	}
+	// imported accounts don't necessarily have the role AWS Organizations creates in new accounts
+	if len(h.mocks.registered("pulumi:providers:aws")) != 0 || len(h.mocks.registered("aws:s3/accountPublicAccessBlock:AccountPublicAccessBlock")) != 0 {
+		t.Fatalf("expected no resources inside an imported account without an access role")
+	}
+
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/legacy", userID, nil, http.StatusOK, &account)
+	if account.Status != types.Created || account.AccountID != "123456789012" {
+		t.Fatalf("expected the imported account to be created with its ID, got %+v", account)
+	}
+
+	// later runs update the adopted account instead of importing it again
+	h.mustRequest(http.MethodPut, "/organizations/acme/accounts/legacy", userID, types.Account{
+		Email: "aws+legacy@example.com",
+		Tags:  map[string]string{"team": "platform"},
+	}, http.StatusOK, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	accounts = h.mocks.registered("aws:organizations/account:Account")
+	if len(accounts) != 2 || accounts[1].ID != "" {
+		t.Fatalf("expected the account to be updated without an import, got %+v", accounts)
+	}
+
+	// with an access role the baselines are applied by assuming it
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts:import", userID, types.Account{
+		AccountName: "shared",
+		Email:       "aws+shared@example.com",
+		AccountID:   "210987654321",
+		AccessRole:  "role/admin",
+	}, http.StatusUnprocessableEntity, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts:import", userID, types.Account{
+		AccountName: "shared",
+		Email:       "aws+shared@example.com",
+		AccountID:   "210987654321",
+		AccessRole:  "LandingZoneAdmin",
+	}, http.StatusCreated, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	providers := h.mocks.registered("pulumi:providers:aws")
+	if len(providers) != 1 || !strings.Contains(providers[0].Inputs["assumeRole"].String(), "role/LandingZoneAdmin") {
+		t.Fatalf("expected the account provider to assume the access role, got %+v", providers)
+	}
+	if len(h.mocks.registered("aws:s3/accountPublicAccessBlock:AccountPublicAccessBlock")) != 1 {
+		t.Fatalf("expected the baselines to be applied to the imported account")
+	}
+}
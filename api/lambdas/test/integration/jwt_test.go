@@ -0,0 +1,154 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/handlers"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestJWTAuth(t *testing.T) {
+	h := newHarness(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	jwksJSON, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kid": "rsa", "kty": "RSA", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+	}})
+	if err := os.WriteFile(jwksFile, jwksJSON, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := db.NewTables(h.ddb, h.tableName)
+	cfg := handlers.JWTConfig{
+		JWKSURI:  "file://" + jwksFile,
+		Issuer:   "https://issuer.example.com/",
+		Audience: "festus",
+	}
+	// tokens issued for other applications would be accepted without an issuer or audience to check
+	for _, incomplete := range []handlers.JWTConfig{{JWKSURI: cfg.JWKSURI, Issuer: cfg.Issuer}, {JWKSURI: cfg.JWKSURI, Audience: cfg.Audience}} {
+		if _, err := handlers.JWTAuth(incomplete, tables.APIKeys); err == nil {
+			t.Fatalf("expected the JWT configuration %+v to be rejected", incomplete)
+		}
+	}
+	auth, err := handlers.JWTAuth(cfg, tables.APIKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := handlers.NewRouter(handlers.RouterConfig{
+		Tables: tables,
+		Feed:   h.bus,
+		Auth:   auth,
+	})
+	router.GET("/claims", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"userID": handlers.GetUserID(c), "groups": handlers.GetGroups(c)})
+	})
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	validClaims := func(sub string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":    sub,
+			"iss":    "https://issuer.example.com/",
+			"aud":    "festus",
+			"exp":    time.Now().Add(time.Hour).Unix(),
+			"groups": []string{"platform", "admins"},
+		}
+	}
+	send := func(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	res := send(http.MethodGet, "/claims", sign(jwt.SigningMethodRS256, "rsa", rsaKey, validClaims("alice")), nil)
+	var identity struct {
+		UserID string   `json:"userID"`
+		Groups []string `json:"groups"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &identity)
+	if res.Code != http.StatusOK || identity.UserID != "alice" || len(identity.Groups) != 2 || identity.Groups[0] != "platform" {
+		t.Fatalf("expected alice with her groups, got %d: %s", res.Code, res.Body.String())
+	}
+
+	es256 := sign(jwt.SigningMethodES256, "ec", ecKey, validClaims("bob"))
+	if res := send(http.MethodPost, "/organizations", es256, types.Organization{OrgName: "acme", PulumiAccessToken: "not-used"}); res.Code != http.StatusCreated {
+		t.Fatalf("expected bob to create an org, got %d: %s", res.Code, res.Body.String())
+	}
+	if res := send(http.MethodGet, "/organizations/acme", es256, nil); res.Code != http.StatusOK {
+		t.Fatalf("expected bob to read his org, got %d: %s", res.Code, res.Body.String())
+	}
+
+	expired := validClaims("alice")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	noExpiry := validClaims("alice")
+	delete(noExpiry, "exp")
+	wrongIssuer := validClaims("alice")
+	wrongIssuer["iss"] = "https://attacker.example.com/"
+	wrongAudience := validClaims("alice")
+	wrongAudience["aud"] = "other-api"
+	noSubject := validClaims("")
+
+	for name, token := range map[string]string{
+		"missing":        "",
+		"garbage":        "not-a-jwt",
+		"expired":        sign(jwt.SigningMethodRS256, "rsa", rsaKey, expired),
+		"without expiry": sign(jwt.SigningMethodRS256, "rsa", rsaKey, noExpiry),
+		"wrong issuer":   sign(jwt.SigningMethodRS256, "rsa", rsaKey, wrongIssuer),
+		"wrong audience": sign(jwt.SigningMethodRS256, "rsa", rsaKey, wrongAudience),
+		"no subject":     sign(jwt.SigningMethodRS256, "rsa", rsaKey, noSubject),
+		"unknown key":    sign(jwt.SigningMethodRS256, "other", otherKey, validClaims("alice")),
+		"forged":         sign(jwt.SigningMethodRS256, "rsa", otherKey, validClaims("alice")),
+		"HS256":          sign(jwt.SigningMethodHS256, "rsa", []byte("secret"), validClaims("alice")),
+	} {
+		if res := send(http.MethodGet, "/claims", token, nil); res.Code != http.StatusUnauthorized {
+			t.Fatalf("expected the %s token to be rejected, got %d: %s", name, res.Code, res.Body.String())
+		}
+	}
+}
@@ -0,0 +1,112 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestOrganizationMembers(t *testing.T) {
+	h := newHarness(t)
+	const admin, operator, viewer, outsider = "admin-user", "operator-user", "viewer-user", "outsider-user"
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations", outsider, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+
+	for _, member := range []types.Member{
+		{UserID: "", Role: types.ViewerRole},
+		{UserID: viewer, Role: "superuser"},
+	} {
+		status, body := h.request(http.MethodPost, "/organizations/acme/members", userID, member)
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("expected member %+v to be rejected, got %d: %s", member, status, string(body))
+		}
+	}
+	// outsider owns an org named acme, so requests of theirs couldn't tell the two apart
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", userID, types.Member{UserID: outsider, Role: types.ViewerRole}, http.StatusConflict, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", userID, types.Member{UserID: userID, Role: types.AdminRole}, http.StatusConflict, nil)
+
+	var member types.Member
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", userID, types.Member{UserID: admin, Role: types.AdminRole}, http.StatusCreated, &member)
+	if member.InvitedBy != userID || member.InvitedAt == nil {
+		t.Fatalf("expected the invitation to be recorded, got %+v", member)
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", admin, types.Member{UserID: operator, Role: types.OperatorRole}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", admin, types.Member{UserID: viewer, Role: types.ViewerRole}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", admin, types.Member{UserID: viewer, Role: types.OperatorRole}, http.StatusConflict, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", admin, types.Member{UserID: "another-owner", Role: types.OwnerRole}, http.StatusForbidden, nil)
+	h.mustRequest(http.MethodPost, "/organizations", viewer, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusConflict, nil)
+
+	var members struct {
+		Members []types.Member `json:"members"`
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/members", viewer, nil, http.StatusOK, &members)
+	roles := map[string]types.MemberRole{}
+	for _, m := range members.Members {
+		roles[m.UserID] = m.Role
+	}
+	if len(roles) != 4 || roles[userID] != types.OwnerRole || roles[admin] != types.AdminRole ||
+		roles[operator] != types.OperatorRole || roles[viewer] != types.ViewerRole {
+		t.Fatalf("expected the owner and three members, got %+v", members.Members)
+	}
+
+	// members work on the owner's org, not on orgs of the same name they'd own themselves
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", operator, types.OrganizationalUnit{Name: "workloads"}, http.StatusForbidden, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", admin, types.OrganizationalUnit{Name: "workloads"}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", viewer, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+	}, http.StatusForbidden, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", operator, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+		Unit:        "workloads",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts:import", viewer, types.Account{
+		AccountName: "legacy",
+		Email:       "aws+legacy@example.com",
+		AccountID:   "123456789012",
+	}, http.StatusForbidden, nil)
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	var account types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &account)
+	if account.Status != types.Created || account.Unit != "workloads" {
+		t.Fatalf("expected the operator's account to be created in the owner's org, got %+v", account)
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", viewer, nil, http.StatusOK, nil)
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", outsider, nil, http.StatusNotFound, nil)
+
+	h.mustRequest(http.MethodDelete, "/organizations/acme", admin, nil, http.StatusForbidden, nil)
+	h.mustRequest(http.MethodDelete, "/organizations/acme/members/"+viewer, operator, nil, http.StatusForbidden, nil)
+	h.mustRequest(http.MethodDelete, "/organizations/acme/members/"+userID, admin, nil, http.StatusConflict, nil)
+	h.mustRequest(http.MethodDelete, "/organizations/acme/members/"+outsider, admin, nil, http.StatusNotFound, nil)
+	h.mustRequest(http.MethodDelete, "/organizations/acme/members/"+viewer, admin, nil, http.StatusNoContent, nil)
+
+	// without the membership the viewer is back to their own (non-existent) org of that name
+	h.mustRequest(http.MethodGet, "/organizations/acme", viewer, nil, http.StatusNotFound, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", userID, types.Member{UserID: viewer, Role: types.ViewerRole}, http.StatusCreated, nil)
+
+	// deleting the org revokes all memberships
+	h.mustRequest(http.MethodDelete, "/organizations/acme", userID, nil, http.StatusNoContent, nil)
+	h.mustRequest(http.MethodGet, "/organizations/acme", admin, nil, http.StatusNotFound, nil)
+	h.mustRequest(http.MethodPost, "/organizations", admin, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+}
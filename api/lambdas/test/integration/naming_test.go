@@ -0,0 +1,134 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/flostadler/festus/api/pkg/validation"
+)
+
+func TestNamingRules(t *testing.T) {
+	h := newHarness(t)
+
+	invalidFields := func(path string, body interface{}) validation.Errors {
+		status, resBody := h.request(http.MethodPost, path, userID, body)
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("POST %s: expected status %d, got %d: %s", path, http.StatusUnprocessableEntity, status, string(resBody))
+		}
+		var problem struct {
+			Errors validation.Errors `json:"errors"`
+		}
+		if err := json.Unmarshal(resBody, &problem); err != nil {
+			t.Fatalf("POST %s: failed to decode problem %q: %s", path, string(resBody), err.Error())
+		}
+		return problem.Errors
+	}
+	fieldsOf := func(errs validation.Errors) []string {
+		var fields []string
+		for _, fieldErr := range errs {
+			fields = append(fields, fieldErr.Field)
+		}
+		return fields
+	}
+
+	errs := invalidFields("/organizations", types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+		NamingRules: &types.NamingRules{
+			Pattern:            "[a-z+",
+			RequiredPrefixes:   []string{"payments-", ""},
+			ReservedNames:      []string{""},
+			MaxAccountsPerUnit: -1,
+		},
+	})
+	expected := []string{"namingRules.pattern", "namingRules.requiredPrefixes[1]", "namingRules.reservedNames[0]", "namingRules.maxAccountsPerUnit"}
+	if fields := fieldsOf(errs); !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected invalid fields %v, got %v", expected, errs)
+	}
+
+	rules := types.NamingRules{
+		Pattern:            "[a-z]+-(dev|prod)",
+		RequiredPrefixes:   []string{"payments-", "search-"},
+		ReservedNames:      []string{"Payments-Prod"},
+		MaxAccountsPerUnit: 1,
+	}
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+		NamingRules:       &rules,
+	}, http.StatusCreated, nil)
+	var org types.Organization
+	h.mustRequest(http.MethodGet, "/organizations/acme", userID, nil, http.StatusOK, &org)
+	if org.NamingRules == nil || !reflect.DeepEqual(*org.NamingRules, rules) {
+		t.Fatalf("expected the naming rules to be stored, got %+v", org.NamingRules)
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "workloads"}, http.StatusCreated, nil)
+
+	// every rule an account breaks is explained
+	errs = invalidFields("/organizations/acme/accounts", types.Account{AccountName: "billing-staging", Email: "aws+billing@example.com"})
+	if fields := fieldsOf(errs); !reflect.DeepEqual(fields, []string{"accountName", "accountName"}) {
+		t.Fatalf("expected the pattern and the prefixes to be violated, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Message, rules.Pattern) || !strings.Contains(errs[1].Message, "search-") {
+		t.Fatalf("expected the violations to name the rules, got %v", errs)
+	}
+	errs = invalidFields("/organizations/acme/accounts", types.Account{AccountName: "payments-prod", Email: "aws+payments@example.com"})
+	if fields := fieldsOf(errs); !reflect.DeepEqual(fields, []string{"accountName"}) || !strings.Contains(errs[0].Message, "reserved") {
+		t.Fatalf("expected the name to be reserved, got %v", errs)
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "payments-dev",
+		Email:       "aws+payments-dev@example.com",
+		Unit:        "workloads",
+	}, http.StatusCreated, nil)
+	// accounts outside of units aren't limited
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "search-dev",
+		Email:       "aws+search-dev@example.com",
+	}, http.StatusCreated, nil)
+
+	errs = invalidFields("/organizations/acme/accounts", types.Account{
+		AccountName: "search",
+		Email:       "aws+search-prod@example.com",
+		Unit:        "workloads",
+	})
+	if fields := fieldsOf(errs); !reflect.DeepEqual(fields, []string{"accountName", "accountName", "unit"}) {
+		t.Fatalf("expected the name and the unit to be rejected, got %v", errs)
+	}
+
+	// imports, moves and specs follow the same rules
+	errs = invalidFields("/organizations/acme/accounts:import", types.Account{AccountName: "billing-dev", Email: "aws+billing@example.com", AccountID: "111122223333"})
+	if fields := fieldsOf(errs); !reflect.DeepEqual(fields, []string{"accountName"}) {
+		t.Fatalf("expected the imported name to be rejected, got %v", errs)
+	}
+	status, body := h.request(http.MethodPut, "/organizations/acme/accounts/search-dev/parent", userID, map[string]string{"unit": "workloads"})
+	if status != http.StatusUnprocessableEntity || !strings.Contains(string(body), "at most 1") {
+		t.Fatalf("expected the move into the full unit to be rejected, got %d: %s", status, string(body))
+	}
+
+	var current types.OrgSpec
+	h.mustRequest(http.MethodGet, "/organizations/acme/spec", userID, nil, http.StatusOK, &current)
+	desired := current
+	desired.Accounts = append(slices.Clone(current.Accounts), types.AccountSpec{Name: "search-prod", Email: "aws+search-prod@example.com", Unit: "workloads"})
+	status, body = h.request(http.MethodPut, "/organizations/acme/spec?apply=true", userID, desired)
+	if status != http.StatusUnprocessableEntity || !strings.Contains(string(body), "accounts[2].unit") {
+		t.Fatalf("expected the spec to be rejected for the full unit, got %d: %s", status, string(body))
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/search-prod", userID, nil, http.StatusNotFound, nil)
+
+	// accounts that are being closed leave their unit
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts/payments-dev/close", userID, nil, http.StatusAccepted, nil)
+	h.mustRequest(http.MethodPut, "/organizations/acme/accounts/search-dev/parent", userID, map[string]string{"unit": "workloads"}, http.StatusOK, nil)
+}
@@ -0,0 +1,117 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestOrganizationBackend(t *testing.T) {
+	h := newHarness(t)
+	backend := types.Backend{
+		Type: types.LocalBackend,
+		URL:  "file:///tmp/festus-state",
+		SecretsProvider: types.SecretsProvider{
+			Type:       types.PassphraseSecretsProvider,
+			Passphrase: "correct horse battery staple",
+		},
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName: "self-managed",
+		Backend: backend,
+	}, http.StatusCreated, nil)
+
+	// the passphrase is stored, but never returned
+	returned := backend
+	returned.SecretsProvider.Passphrase = ""
+	var org types.Organization
+	h.mustRequest(http.MethodGet, "/organizations/self-managed", userID, nil, http.StatusOK, &org)
+	if org.Backend != returned {
+		t.Fatalf("expected backend %+v, got %+v", returned, org.Backend)
+	}
+
+	status, _ := h.request(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName: "no-secrets",
+		Backend: types.Backend{Type: types.S3Backend, URL: "s3://festus-state"},
+	})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a self-managed backend without secrets provider to be rejected, got %d", status)
+	}
+
+	for _, tc := range []struct {
+		secrets types.SecretsProvider
+		valid   bool
+	}{
+		{types.SecretsProvider{Type: types.AwsKmsSecretsProvider, KeyID: "arn:aws:kms:us-west-2:111122223333:key/1234"}, true},
+		{types.SecretsProvider{Type: types.AwsKmsSecretsProvider, KeyID: "alias/festus", Region: "eu-central-1"}, true},
+		{types.SecretsProvider{Type: types.AwsKmsSecretsProvider, KeyID: "alias/festus"}, false},
+		{types.SecretsProvider{Type: types.AwsKmsSecretsProvider, KeyID: "arn:aws:kms:key/1234"}, false},
+		{types.SecretsProvider{Type: types.AzureKeyVaultSecretsProvider, KeyID: "https://festus.vault.azure.net/keys/state"}, true},
+		{types.SecretsProvider{Type: types.AzureKeyVaultSecretsProvider, KeyID: "state"}, false},
+		{types.SecretsProvider{Type: types.GcpKmsSecretsProvider, KeyID: "projects/acme/locations/global/keyRings/festus/cryptoKeys/state"}, true},
+		{types.SecretsProvider{Type: types.GcpKmsSecretsProvider, KeyID: "state"}, false},
+	} {
+		status, body := h.request(http.MethodPost, "/organizations", userID, types.Organization{
+			OrgName: "kms",
+			Backend: types.Backend{Type: types.S3Backend, URL: "s3://festus-state", SecretsProvider: tc.secrets},
+		})
+		if tc.valid && status == http.StatusCreated {
+			h.mustRequest(http.MethodDelete, "/organizations/kms", userID, nil, http.StatusNoContent, nil)
+			continue
+		}
+		if tc.valid || status != http.StatusUnprocessableEntity {
+			t.Fatalf("expected secrets provider %+v to be valid=%t, got %d: %s", tc.secrets, tc.valid, status, string(body))
+		}
+	}
+}
+
+func TestCredentialsAreRedacted(t *testing.T) {
+	h := newHarness(t)
+
+	secrets := []string{"pulumi-token", "org-access-key", "org-secret-key", "org-session-token", "account-secret-key", "account-session-token"}
+	_, body := h.request(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "pulumi-token",
+		AwsAccessKey:      "org-access-key",
+		AwsSecretKey:      "org-secret-key",
+		AwsSessionToken:   "org-session-token",
+	})
+	responses := []string{string(body)}
+
+	_, body = h.request(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName:     "dev",
+		Email:           "aws+dev@example.com",
+		AwsAccessKey:    "account-access-key",
+		AwsSecretKey:    "account-secret-key",
+		AwsSessionToken: "account-session-token",
+	})
+	responses = append(responses, string(body))
+
+	for _, path := range []string{"/organizations/acme", "/organizations/acme/accounts", "/organizations/acme/accounts/dev"} {
+		status, body := h.request(http.MethodGet, path, userID, nil)
+		if status != http.StatusOK {
+			t.Fatalf("expected GET %s to succeed, got %d: %s", path, status, string(body))
+		}
+		responses = append(responses, string(body))
+	}
+
+	for _, response := range responses {
+		for _, secret := range secrets {
+			if strings.Contains(response, secret) {
+				t.Fatalf("expected the response not to contain %s: %s", secret, response)
+			}
+		}
+	}
+
+	// the stored credentials are still used to apply the stacks
+	stored, err := db.NewOrganizationDB(h.ddb, h.tableName).GetItem(userID, "acme", true)
+	if err != nil || stored == nil || stored.AwsSecretKey != "org-secret-key" {
+		t.Fatalf("expected the credentials of the org to be stored, got %+v: %v", stored, err)
+	}
+}
@@ -0,0 +1,137 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+const denyLeaveOrganization = `{
+  "Version": "2012-10-17",
+  "Statement": [{"Effect": "Deny", "Action": "organizations:LeaveOrganization", "Resource": "*"}]
+}`
+
+func TestServiceControlPolicies(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "workloads"}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+		Unit:        "workloads",
+	}, http.StatusCreated, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	for _, document := range []string{
+		"",
+		"not json",
+		`{"Version": "2008-10-17", "Statement": [{"Effect": "Deny", "Action": "*", "Resource": "*"}]}`,
+		`{"Version": "2012-10-17", "Statement": []}`,
+		`{"Version": "2012-10-17", "Statement": [{"Effect": "Block", "Action": "*"}]}`,
+		`{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Resource": "*"}]}`,
+		`{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Action": "*", "NotAction": "s3:*"}]}`,
+		`{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Action": "*", "Principal": "*"}]}`,
+		`{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Action": 42}]}`,
+		`{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Action": "*", "Unknown": true}]}`,
+	} {
+		status, body := h.request(http.MethodPost, "/organizations/acme/policies", userID, types.ServiceControlPolicy{
+			Name:     "invalid",
+			Document: document,
+		})
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("expected document %q to be rejected, got %d: %s", document, status, string(body))
+		}
+	}
+	status, body := h.request(http.MethodPost, "/organizations/acme/policies", userID, types.ServiceControlPolicy{
+		Name:     "deny-leave",
+		Document: denyLeaveOrganization,
+		Units:    []string{"unknown"},
+	})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a policy attached to an unknown unit to be rejected, got %d: %s", status, string(body))
+	}
+
+	// the sandbox account doesn't exist in AWS yet, it's attached once it was created
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "sandbox",
+		Email:       "aws+sandbox@example.com",
+	}, http.StatusCreated, nil)
+	var policy types.ServiceControlPolicy
+	h.mustRequest(http.MethodPost, "/organizations/acme/policies", userID, types.ServiceControlPolicy{
+		Name:        "deny-leave",
+		Description: "Accounts cannot leave the organization",
+		Document:    denyLeaveOrganization,
+		Units:       []string{"workloads"},
+		Accounts:    []string{"sandbox"},
+	}, http.StatusCreated, &policy)
+	if policy.Applied {
+		t.Fatalf("expected a new policy not to be applied yet")
+	}
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	policies := h.lastOrgRun("aws:organizations/policy:Policy")
+	if len(policies) != 1 || policies[0].Inputs["type"].StringValue() != "SERVICE_CONTROL_POLICY" ||
+		policies[0].Inputs["content"].StringValue() != denyLeaveOrganization {
+		t.Fatalf("expected the org stack to create the policy, got %+v", policies)
+	}
+	attachments := map[string]string{}
+	for _, r := range h.lastOrgRun("aws:organizations/policyAttachment:PolicyAttachment") {
+		attachments[r.Name] = r.Inputs["targetId"].StringValue()
+	}
+	if attachments["deny-leave-unit-workloads"] != "workloads_id" || attachments["deny-leave-account-sandbox"] != "sandbox_id" {
+		t.Fatalf("expected the policy to be attached to the unit and the account, got %+v", attachments)
+	}
+
+	h.mustRequest(http.MethodGet, "/organizations/acme/policies/deny-leave", userID, nil, http.StatusOK, &policy)
+	if !policy.Applied || policy.PolicyID != "deny-leave_id" {
+		t.Fatalf("expected the policy to be applied, got %+v", policy)
+	}
+
+	var effective struct {
+		Policies []types.EffectivePolicy `json:"policies"`
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev/policies", userID, nil, http.StatusOK, &effective)
+	if len(effective.Policies) != 1 || effective.Policies[0].AttachedTo != "unit/workloads" {
+		t.Fatalf("expected dev to inherit the policy from its unit, got %+v", effective.Policies)
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/sandbox/policies", userID, nil, http.StatusOK, &effective)
+	if len(effective.Policies) != 1 || effective.Policies[0].AttachedTo != "account/sandbox" {
+		t.Fatalf("expected the policy to be attached to sandbox directly, got %+v", effective.Policies)
+	}
+
+	h.mustRequest(http.MethodPut, "/organizations/acme/policies/deny-leave", userID, types.ServiceControlPolicy{
+		Document: denyLeaveOrganization,
+		Units:    []string{"workloads"},
+	}, http.StatusOK, &policy)
+	if policy.Applied || len(policy.Accounts) != 0 {
+		t.Fatalf("expected the policy to be detached from sandbox, got %+v", policy)
+	}
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	if attachments := h.lastOrgRun("aws:organizations/policyAttachment:PolicyAttachment"); len(attachments) != 1 {
+		t.Fatalf("expected only the unit attachment to remain, got %+v", attachments)
+	}
+
+	h.mustRequest(http.MethodDelete, "/organizations/acme/policies/deny-leave", userID, nil, http.StatusNoContent, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	if policies := h.lastOrgRun("aws:organizations/policy:Policy"); len(policies) != 0 {
+		t.Fatalf("expected the org stack to delete the policy, got %+v", policies)
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/policies/deny-leave", userID, nil, http.StatusNotFound, nil)
+}
@@ -0,0 +1,60 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestTokenScopes(t *testing.T) {
+	h := newHarness(t)
+	const viewer = "viewer-user"
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/members", userID, types.Member{UserID: viewer, Role: types.ViewerRole}, http.StatusCreated, nil)
+
+	expectError := func(method string, path string, authorizer map[string]interface{}, body interface{}, expectedStatus int, requiredScope string) {
+		status, resBody := h.requestWithAuthorizer(method, path, authorizer, body, nil)
+		var res struct {
+			Detail        string `json:"detail"`
+			RequiredScope string `json:"requiredScope"`
+		}
+		if err := json.Unmarshal(resBody, &res); err != nil || status != expectedStatus || res.Detail == "" || res.RequiredScope != requiredScope {
+			t.Fatalf("%s %s: expected %d requiring '%s', got %d: %s", method, path, expectedStatus, requiredScope, status, string(resBody))
+		}
+	}
+	withScope := func(user string, scope string) map[string]interface{} {
+		return map[string]interface{}{"principalId": user, "scope": scope}
+	}
+	account := types.Account{AccountName: "dev", Email: "aws+dev@example.com"}
+
+	expectError(http.MethodGet, "/organizations/acme", map[string]interface{}{}, nil, http.StatusUnauthorized, "")
+	expectError(http.MethodGet, "/organizations/acme", map[string]interface{}{"principalId": ""}, nil, http.StatusUnauthorized, "")
+
+	readOnly := withScope(userID, "org:read")
+	if status, body := h.requestWithAuthorizer(http.MethodGet, "/organizations/acme", readOnly, nil, nil); status != http.StatusOK {
+		t.Fatalf("expected a read-only token to read the org, got %d: %s", status, string(body))
+	}
+	expectError(http.MethodPost, "/organizations/acme/accounts", readOnly, account, http.StatusForbidden, "accounts:write")
+	expectError(http.MethodPost, "/organizations/acme/accounts:import", readOnly, account, http.StatusForbidden, "accounts:write")
+	expectError(http.MethodPost, "/organizations", readOnly, types.Organization{OrgName: "other", PulumiAccessToken: "not-used"}, http.StatusForbidden, "org:write")
+	expectError(http.MethodPost, "/organizations/acme/apikeys", withScope(userID, "apikeys:write"), types.APIKey{
+		Name:   "ci",
+		Scopes: []string{"org:read"},
+	}, http.StatusForbidden, "org:read")
+	expectError(http.MethodGet, "/organizations/acme", withScope(userID, ""), nil, http.StatusForbidden, "org:read")
+
+	if status, body := h.requestWithAuthorizer(http.MethodPost, "/organizations/acme/accounts", withScope(userID, "org:read accounts:write"), account, nil); status != http.StatusCreated {
+		t.Fatalf("expected a token with accounts:write to create the account, got %d: %s", status, string(body))
+	}
+
+	// scopes never grant more than the member's role
+	expectError(http.MethodPut, "/organizations/acme/accounts/dev", withScope(viewer, "org:read accounts:write"), account, http.StatusForbidden, "accounts:write")
+}
@@ -0,0 +1,185 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+const orgSpec = `
+units:
+  - name: festus
+  - name: dev
+    parent: festus
+    tags:
+      env: dev
+accounts:
+  - name: sandbox
+    email: aws+sandbox@example.com
+  - name: dev-app
+    email: aws+dev-app@example.com
+    unit: dev
+`
+
+func TestOrganizationSpec(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+
+	yamlHeaders := map[string]string{"Content-Type": "application/yaml"}
+
+	// without apply only the plan is returned
+	var plan types.SpecPlan
+	putSpec(h, "/organizations/acme/spec", []byte(orgSpec), yamlHeaders, &plan)
+	if plan.Applied || len(plan.Changes) != 4 {
+		t.Fatalf("expected an unapplied plan with 4 changes, got %+v", plan)
+	}
+	if first := plan.Changes[0]; first.Kind != types.SpecUnit || first.Name != "festus" || first.Action != types.SpecCreate {
+		t.Fatalf("expected parent unit to be created first, got %+v", first)
+	}
+
+	var current types.OrgSpec
+	h.mustRequest(http.MethodGet, "/organizations/acme/spec", userID, nil, http.StatusOK, &current)
+	if len(current.Units) != 0 || len(current.Accounts) != 0 {
+		t.Fatalf("expected the plan not to change the org, got %+v", current)
+	}
+
+	putSpec(h, "/organizations/acme/spec?apply=true", []byte(orgSpec), yamlHeaders, &plan)
+	if !plan.Applied {
+		t.Fatalf("expected the plan to be applied")
+	}
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	var acc types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev-app", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Created || acc.Unit != "dev" {
+		t.Fatalf("expected spec account to be created in unit dev, got %+v", acc)
+	}
+
+	// the export round-trips and applying it again is a no-op
+	h.mustRequest(http.MethodGet, "/organizations/acme/spec", userID, nil, http.StatusOK, &current)
+	if len(current.Units) != 2 || len(current.Accounts) != 2 {
+		t.Fatalf("expected exported spec to contain 2 units and 2 accounts, got %+v", current)
+	}
+	exported, _ := json.Marshal(current)
+	putSpec(h, "/organizations/acme/spec", exported, nil, &plan)
+	if len(plan.Changes) != 0 {
+		t.Fatalf("expected no changes for the exported spec, got %+v", plan.Changes)
+	}
+
+	// dropping the sandbox account is rejected, accounts are removed by closing them
+	dropped := strings.Replace(orgSpec, "  - name: sandbox\n    email: aws+sandbox@example.com\n", "", 1)
+	status, body := h.requestWithHeaders(http.MethodPut, "/organizations/acme/spec?apply=true", userID, []byte(dropped), yamlHeaders)
+	if status != http.StatusUnprocessableEntity || !strings.Contains(string(body), "/organizations/acme/accounts/sandbox/close") {
+		t.Fatalf("expected dropping an account to point to the closure, got %d: %s", status, string(body))
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/sandbox", userID, nil, http.StatusOK, nil)
+
+	// retagging dev
+	changed := strings.Replace(orgSpec, "env: dev", "env: development", 1)
+	putSpec(h, "/organizations/acme/spec?apply=true", []byte(changed), yamlHeaders, &plan)
+	expected := []types.SpecChange{
+		{Kind: types.SpecUnit, Name: "dev", Action: types.SpecUpdate, Fields: []string{"tags"}},
+	}
+	if len(plan.Changes) != len(expected) {
+		t.Fatalf("expected changes %+v, got %+v", expected, plan.Changes)
+	}
+	for i, change := range plan.Changes {
+		if change.Kind != expected[i].Kind || change.Name != expected[i].Name || change.Action != expected[i].Action {
+			t.Fatalf("expected changes %+v, got %+v", expected, plan.Changes)
+		}
+	}
+}
+
+func TestOrganizationSpecValidation(t *testing.T) {
+	h := newHarness(t)
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+
+	invalid := `
+accounts:
+  - name: dev-app
+    email: aws+dev-app@example.com
+    unit: missing
+`
+	status, body := h.requestWithHeaders(http.MethodPut, "/organizations/acme/spec", userID, []byte(invalid), map[string]string{"Content-Type": "application/yaml"})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected spec with an unknown unit to be rejected, got %d: %s", status, string(body))
+	}
+	// policies aren't part of specs, but units they are attached to can't be deleted by them either
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "workloads"}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/policies", userID, types.ServiceControlPolicy{
+		Name:     "deny-leave",
+		Document: denyLeaveOrganization,
+		Units:    []string{"workloads"},
+	}, http.StatusCreated, nil)
+	status, body = h.requestWithHeaders(http.MethodPut, "/organizations/acme/spec?apply=true", userID, []byte("units: []\n"), map[string]string{"Content-Type": "application/yaml"})
+	if status != http.StatusConflict || !strings.Contains(string(body), "deny-leave") {
+		t.Fatalf("expected the deletion of a unit with a policy to be rejected, got %d: %s", status, string(body))
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/units/workloads", userID, nil, http.StatusOK, nil)
+}
+
+func putSpec(h *harness, path string, body []byte, headers map[string]string, plan *types.SpecPlan) {
+	status, resBody := h.requestWithHeaders(http.MethodPut, path, userID, body, headers)
+	if status != http.StatusOK {
+		h.t.Fatalf("PUT %s: expected status 200, got %d: %s", path, status, string(resBody))
+	}
+	*plan = types.SpecPlan{}
+	if err := json.Unmarshal(resBody, plan); err != nil {
+		h.t.Fatalf("PUT %s: failed to decode plan: %s", path, err.Error())
+	}
+}
+
+func TestOrganizationSpecChecks(t *testing.T) {
+	h := newHarness(t)
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+		EmailTemplate:     "aws+{org}-{account}@example.com",
+	}, http.StatusCreated, nil)
+
+	// new accounts without an email get one rendered from the template like accounts created one by one
+	var plan types.SpecPlan
+	h.mustRequest(http.MethodPut, "/organizations/acme/spec?apply=true", userID, types.OrgSpec{
+		Accounts: []types.AccountSpec{{Name: "dev"}},
+	}, http.StatusOK, &plan)
+	if !plan.Applied || len(plan.Changes) != 1 || !plan.Changes[0].Applied {
+		t.Fatalf("expected the account to be created, got %+v", plan)
+	}
+	var account types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev", userID, nil, http.StatusOK, &account)
+	if account.Email != "aws+acme-dev@example.com" {
+		t.Fatalf("expected the email to be rendered from the template, got %q", account.Email)
+	}
+
+	// every change is checked before the first one is applied
+	status, body := h.request(http.MethodPut, "/organizations/acme/spec?apply=true", userID, types.OrgSpec{
+		Units: []types.UnitSpec{{Name: "workloads"}},
+		Accounts: []types.AccountSpec{
+			{Name: "dev", Email: "aws+acme-dev@example.com"},
+			{Name: "prod", Email: "aws+acme-dev@example.com"},
+			{Name: "staging", Unit: "workloads"},
+		},
+	})
+	if status != http.StatusUnprocessableEntity || !strings.Contains(string(body), "accounts[1].email") {
+		t.Fatalf("expected the email of another account to be rejected, got %d: %s", status, string(body))
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/units/workloads", userID, nil, http.StatusNotFound, nil)
+}
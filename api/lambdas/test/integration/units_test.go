@@ -0,0 +1,149 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+func TestOrganizationalUnits(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "festus"}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "dev", Parent: "festus"}, http.StatusCreated, nil)
+
+	status, _ := h.request(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "prod", Parent: "missing"})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected units with an unknown parent to be rejected, got %d", status)
+	}
+
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	var list struct {
+		Units []types.OrganizationalUnit `json:"units"`
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/units", userID, nil, http.StatusOK, &list)
+	if len(list.Units) != 2 {
+		t.Fatalf("expected 2 units, got %d", len(list.Units))
+	}
+	for _, unit := range list.Units {
+		if !unit.Applied || unit.UnitID != unit.Name+"_id" {
+			t.Fatalf("expected unit '%s' to be created by the org stack, got %+v", unit.Name, unit)
+		}
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "dev-account",
+		Email:       "aws+dev-account@example.com",
+		Unit:        "dev",
+	}, http.StatusCreated, nil)
+	if err := h.processStream(ctx); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	var acc types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev-account", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Created {
+		t.Fatalf("expected account to be %s, got %s", types.Created, acc.Status)
+	}
+	accounts := h.mocks.registered("aws:organizations/account:Account")
+	if parentID := accounts[len(accounts)-1].Inputs["parentId"]; parentID.StringValue() != "dev_id" {
+		t.Fatalf("expected account to be placed in unit 'dev', got parent %s", parentID)
+	}
+
+	for _, name := range []string{"festus", "dev"} {
+		status, _ := h.request(http.MethodDelete, fmt.Sprintf("/organizations/acme/units/%s", name), userID, nil)
+		if status != http.StatusConflict {
+			t.Fatalf("expected deleting non-empty unit '%s' to conflict, got %d", name, status)
+		}
+	}
+}
+
+// The records of units and accounts are on different shards, so an account can arrive before its unit was applied
+func TestAccountBeforeUnit(t *testing.T) {
+	h := newHarness(t)
+	ctx := context.Background()
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "dev"}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "dev-account",
+		Email:       "aws+dev-account@example.com",
+		Unit:        "dev",
+	}, http.StatusCreated, nil)
+
+	records, err := h.streamReader.Read()
+	if err != nil {
+		t.Fatalf("failed to read stream: %s", err.Error())
+	}
+	var accountRecords, otherRecords []events.DynamoDBEventRecord
+	for _, record := range records {
+		if strings.HasPrefix(record.Change.Keys["pk"].String(), "ACC#") {
+			accountRecords = append(accountRecords, record)
+		} else {
+			otherRecords = append(otherRecords, record)
+		}
+	}
+
+	if err := h.handler.Handle(ctx, events.DynamoDBEvent{Records: accountRecords}); err == nil {
+		t.Fatalf("expected the account record to be retried while its unit doesn't exist")
+	}
+	var acc types.Account
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev-account", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Pending {
+		t.Fatalf("expected account to stay %s, got %s", types.Pending, acc.Status)
+	}
+
+	// applying the unit applies the accounts that waited for it
+	if err := h.handler.Handle(ctx, events.DynamoDBEvent{Records: otherRecords}); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+	h.mustRequest(http.MethodGet, "/organizations/acme/accounts/dev-account", userID, nil, http.StatusOK, &acc)
+	if acc.Status != types.Created {
+		t.Fatalf("expected account to be %s once its unit was applied, got %s", types.Created, acc.Status)
+	}
+
+	if err := h.handler.Handle(ctx, events.DynamoDBEvent{Records: accountRecords}); err != nil {
+		t.Fatalf("expected the retried account record to succeed, got %s", err.Error())
+	}
+}
+
+func TestOrganizationalUnitDepth(t *testing.T) {
+	h := newHarness(t)
+
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+
+	parent := ""
+	for i := 1; i <= 5; i++ {
+		name := fmt.Sprintf("level-%d", i)
+		h.mustRequest(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: name, Parent: parent}, http.StatusCreated, nil)
+		parent = name
+	}
+
+	status, _ := h.request(http.MethodPost, "/organizations/acme/units", userID, types.OrganizationalUnit{Name: "level-6", Parent: parent})
+	if status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected units nested deeper than 5 levels to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,141 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/flostadler/festus/api/pkg/types"
+	"github.com/flostadler/festus/api/pkg/validation"
+)
+
+func TestRequestValidation(t *testing.T) {
+	h := newHarness(t)
+
+	expectInvalid := func(method string, path string, body interface{}, expectedFields ...string) {
+		status, resBody := h.requestWithHeaders(method, path, userID, body, nil)
+		if status != http.StatusUnprocessableEntity {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, http.StatusUnprocessableEntity, status, string(resBody))
+		}
+		var problem struct {
+			Errors validation.Errors `json:"errors"`
+		}
+		if err := json.Unmarshal(resBody, &problem); err != nil {
+			t.Fatalf("%s %s: failed to decode problem %q: %s", method, path, string(resBody), err.Error())
+		}
+		var fields []string
+		for _, fieldErr := range problem.Errors {
+			if fieldErr.Message == "" {
+				t.Fatalf("%s %s: expected a message for field %s", method, path, fieldErr.Field)
+			}
+			fields = append(fields, fieldErr.Field)
+		}
+		if !reflect.DeepEqual(fields, expectedFields) {
+			t.Fatalf("%s %s: expected invalid fields %v, got %s", method, path, expectedFields, string(resBody))
+		}
+	}
+
+	expectInvalid(http.MethodPost, "/organizations", types.Organization{PulumiAccessToken: "not-used"}, "orgName")
+	expectInvalid(http.MethodPost, "/organizations", types.Organization{
+		OrgName:                "acme corp",
+		ClosureGracePeriodDays: -1,
+		PulumiOrg:              "acme-corp",
+	}, "orgName", "closureGracePeriodDays", "pulumiAccessToken")
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "acme",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+
+	for _, tc := range []struct {
+		account types.Account
+		fields  []string
+	}{
+		{types.Account{Email: "aws+dev@example.com"}, []string{"accountName"}},
+		{types.Account{AccountName: "dev", Email: "not-an-email"}, []string{"email"}},
+		{types.Account{AccountName: "dev", Email: "a@b.c"}, []string{"email"}},
+		{types.Account{AccountName: "dev", Email: "aws+" + strings.Repeat("d", 60) + "@example.com"}, []string{"email"}},
+		{types.Account{AccountName: strings.Repeat("d", 51), Email: "aws+dev@example.com"}, []string{"accountName"}},
+		{types.Account{AccountName: "dev account", Email: "aws+dev@example.com"}, []string{"accountName"}},
+		{types.Account{AccountName: "organization", Email: "aws+dev@example.com"}, []string{"accountName"}},
+		{types.Account{AccountName: "dev", Email: "aws+dev@example.com", ParentID: "ou-workloads"}, []string{"parentID"}},
+		{types.Account{AccountName: "dev", Email: "aws+dev@example.com", ParentID: "root"}, []string{"parentID"}},
+		{types.Account{AccountName: "dev", Email: "aws+dev@example.com", Tags: map[string]string{"aws:team": "platform"}}, []string{"tags.aws:team"}},
+		{types.Account{ParentID: "ou-1"}, []string{"accountName", "email", "parentID"}},
+		// the state of an account is owned by the server
+		{types.Account{AccountName: "dev", Email: "aws+dev@example.com", Status: types.Created}, []string{"status"}},
+		{types.Account{AccountName: "dev", Email: "aws+dev@example.com", Status: types.AccountStatus(42)}, []string{"status"}},
+		{types.Account{AccountName: "dev", Email: "aws+dev@example.com", LastDeploymentID: "d", DriftStatus: types.Drifted}, []string{"lastDeploymentID", "driftStatus"}},
+		{types.Account{AccountName: "dev", Email: "aws+dev@example.com", Closure: &types.AccountClosure{}, ParentHistory: []types.ParentMove{{To: "r-a1b2"}}}, []string{"closure", "parentHistory"}},
+	} {
+		expectInvalid(http.MethodPost, "/organizations/acme/accounts", tc.account, tc.fields...)
+	}
+
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "dev",
+		Email:       "aws+dev@example.com",
+		ParentID:    "ou-a1b2-workloads",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "prod",
+		Email:       "aws+prod@example.com",
+		ParentID:    "r-a1b2",
+	}, http.StatusCreated, nil)
+
+	// emails are unique within an org, regardless of their case
+	expectInvalid(http.MethodPost, "/organizations/acme/accounts", types.Account{AccountName: "staging", Email: "AWS+dev@example.com"}, "email")
+	expectInvalid(http.MethodPost, "/organizations/acme/accounts:import", types.Account{
+		AccountName: "legacy",
+		Email:       "aws+dev@example.com",
+		AccountID:   "123456789012",
+	}, "email")
+	expectInvalid(http.MethodPut, "/organizations/acme/accounts/prod", types.Account{Email: "aws+dev@example.com"}, "email")
+	expectInvalid(http.MethodPut, "/organizations/acme/accounts/prod", types.Account{Email: "not-an-email"}, "email")
+	expectInvalid(http.MethodPut, "/organizations/acme/accounts/prod/parent", map[string]string{"parentID": "ou-sandbox"}, "parentID")
+
+	// the email is kept when only the tags change
+	var retagged types.Account
+	h.mustRequest(http.MethodPut, "/organizations/acme/accounts/prod", userID, types.Account{Tags: map[string]string{"team": "platform"}}, http.StatusOK, &retagged)
+	if retagged.Email != "aws+prod@example.com" || retagged.Tags["team"] != "platform" {
+		t.Fatalf("expected a tags-only update to keep the email, got %+v", retagged)
+	}
+
+	// changing the email of an account frees the previous one
+	h.mustRequest(http.MethodPut, "/organizations/acme/accounts/dev", userID, types.Account{Email: "aws+dev-new@example.com"}, http.StatusOK, nil)
+	h.mustRequest(http.MethodPut, "/organizations/acme/accounts/prod", userID, types.Account{Email: "aws+dev@example.com"}, http.StatusOK, nil)
+	expectInvalid(http.MethodPost, "/organizations/acme/accounts", types.Account{AccountName: "staging", Email: "aws+dev-new@example.com"}, "email")
+
+	// and so does deleting it
+	h.mustRequest(http.MethodDelete, "/organizations/acme/accounts/dev", userID, nil, http.StatusNoContent, nil)
+	h.mustRequest(http.MethodPost, "/organizations/acme/accounts", userID, types.Account{
+		AccountName: "staging",
+		Email:       "aws+dev-new@example.com",
+	}, http.StatusCreated, nil)
+
+	// orgs of the same owner don't share their emails
+	h.mustRequest(http.MethodPost, "/organizations", userID, types.Organization{
+		OrgName:           "other",
+		PulumiAccessToken: "not-used",
+	}, http.StatusCreated, nil)
+	h.mustRequest(http.MethodPost, "/organizations/other/accounts", userID, types.Account{
+		AccountName: "staging",
+		Email:       "aws+dev-new@example.com",
+	}, http.StatusCreated, nil)
+
+	duplicates := `
+accounts:
+  - name: staging
+    email: aws+staging@example.com
+  - name: prod
+    email: AWS+staging@example.com
+  - name: sandbox
+    email: sandbox
+`
+	status, body := h.requestWithHeaders(http.MethodPut, "/organizations/acme/spec", userID, []byte(duplicates), map[string]string{"Content-Type": "application/yaml"})
+	if status != http.StatusUnprocessableEntity || !strings.Contains(string(body), "accounts[1].email") || !strings.Contains(string(body), "accounts[2].email") {
+		t.Fatalf("expected the duplicate and the invalid email of the spec to be rejected, got %d: %s", status, string(body))
+	}
+}
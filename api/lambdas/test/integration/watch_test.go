@@ -0,0 +1,105 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
+
+	"github.com/flostadler/festus/api/pkg/db"
+	"github.com/flostadler/festus/api/pkg/feed"
+	"github.com/flostadler/festus/api/pkg/handlers"
+	"github.com/flostadler/festus/api/pkg/types"
+)
+
+// sseEvents returns the event names and IDs of a Server-Sent Events body
+func sseEvents(body string) ([]string, []string) {
+	var names, ids []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "event:") {
+			names = append(names, strings.TrimPrefix(line, "event:"))
+		}
+		if strings.HasPrefix(line, "id:") {
+			ids = append(ids, strings.TrimPrefix(line, "id:"))
+		}
+	}
+	return names, ids
+}
+
+func TestWatchAccount(t *testing.T) {
+	h := newHarness(t)
+	createOrgAndAccount(h, "acme", "dev")
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	status, body := h.request(http.MethodGet, "/organizations/acme/accounts/dev/watch", userID, nil)
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, string(body))
+	}
+	names, ids := sseEvents(string(body))
+	if len(names) != len(h.mocks.resources)+1 || names[0] != "status" {
+		t.Fatalf("expected the status followed by %d deployment events, got %v", len(h.mocks.resources), names)
+	}
+
+	// the last event ID contains the status version too, so resuming after it repeats nothing
+	_, body = h.requestWithHeaders(http.MethodGet, "/organizations/acme/accounts/dev/watch", userID, nil, map[string]string{
+		"Last-Event-ID": ids[len(ids)-1],
+	})
+	if names, _ = sseEvents(string(body)); len(names) != 0 {
+		t.Fatalf("expected no events after resuming, got %v", names)
+	}
+}
+
+// TestWatchAccountFromStream watches like the API lambda does: changes are read from the table stream and streams end
+// as soon as they have events, because API Gateway only returns complete responses
+func TestWatchAccountFromStream(t *testing.T) {
+	h := newHarness(t)
+	createOrgAndAccount(h, "acme", "dev")
+	if err := h.processStream(context.Background()); err != nil {
+		t.Fatalf("stream handler failed: %s", err.Error())
+	}
+
+	tables := db.NewTables(h.ddb, h.tableName)
+	h.ginLambda = ginadapter.New(handlers.NewRouter(handlers.RouterConfig{
+		Tables:        tables,
+		Feed:          feed.NewStreamFeed(dynamodbstreams.New(sess), h.streamArn),
+		WatchTimeout:  20 * time.Second,
+		BufferedWatch: true,
+	}))
+
+	start := time.Now()
+	_, body := h.request(http.MethodGet, "/organizations/acme/accounts/dev/watch", userID, nil)
+	names, ids := sseEvents(string(body))
+	if len(names) != len(h.mocks.resources)+1 || time.Since(start) > 10*time.Second {
+		t.Fatalf("expected the snapshot to be returned right away, got %v after %s", names, time.Since(start))
+	}
+
+	updated := make(chan int)
+	go func() {
+		time.Sleep(2 * time.Second)
+		status, _ := h.request(http.MethodPut, "/organizations/acme/accounts/dev", userID, types.Account{
+			Email: "aws+dev@example.com",
+			Tags:  map[string]string{"team": "platform"},
+		})
+		updated <- status
+	}()
+
+	start = time.Now()
+	_, body = h.requestWithHeaders(http.MethodGet, "/organizations/acme/accounts/dev/watch", userID, nil, map[string]string{
+		"Last-Event-ID": ids[len(ids)-1],
+	})
+	if status := <-updated; status != http.StatusOK {
+		t.Fatalf("expected the update to succeed, got %d", status)
+	}
+	names, _ = sseEvents(string(body))
+	if len(names) != 1 || names[0] != "status" || time.Since(start) > 10*time.Second {
+		t.Fatalf("expected the stream to end with the update, got %v after %s", names, time.Since(start))
+	}
+}